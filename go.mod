@@ -6,6 +6,7 @@ require (
 	go.uber.org/ratelimit v0.3.1
 	golang.org/x/sync v0.8.0
 	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
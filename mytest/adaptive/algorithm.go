@@ -0,0 +1,183 @@
+package adaptive
+
+import (
+	"math"
+	"time"
+)
+
+// Outcome 一次调用的结果, Release时告诉限流器
+type Outcome int
+
+const (
+	// Success 调用成功, rtt参与计算
+	Success Outcome = iota
+	// Dropped 调用失败/超时/被后端拒绝, 说明后端已经过载
+	Dropped
+	// Ignored 和后端健康无关的结果(比如调用方自己取消), 不参与计算
+	Ignored
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Success:
+		return "success"
+	case Dropped:
+		return "dropped"
+	case Ignored:
+		return "ignored"
+	}
+	return "unknown"
+}
+
+// Algorithm 根据一次调用的观测值算出新的并发上限
+// inflight 是这次调用开始时正在运行的请求数
+type Algorithm interface {
+	Limit() int
+	Update(inflight int, rtt time.Duration, outcome Outcome) int
+}
+
+// AIMD 加性增, 乘性减
+// 没有出错并且并发数用到一半以上时上限加1, 出错或者rtt超过Timeout时上限乘以Backoff
+type AIMD struct {
+	MinLimit int
+	MaxLimit int
+	Backoff  float64
+	Timeout  time.Duration
+
+	limit int
+}
+
+// NewAIMD 创建AIMD算法, initial是初始上限
+func NewAIMD(initial int) *AIMD {
+	return &AIMD{
+		MinLimit: 1,
+		MaxLimit: 1000,
+		Backoff:  0.9,
+		Timeout:  5 * time.Second,
+		limit:    initial,
+	}
+}
+
+func (a *AIMD) Limit() int { return a.limit }
+
+func (a *AIMD) Update(inflight int, rtt time.Duration, outcome Outcome) int {
+	switch {
+	case outcome == Ignored:
+	case outcome == Dropped || (a.Timeout > 0 && rtt > a.Timeout):
+		a.limit = int(float64(a.limit) * a.Backoff)
+	case inflight*2 >= a.limit:
+		a.limit++
+	}
+	a.limit = clamp(a.limit, a.MinLimit, a.MaxLimit)
+	return a.limit
+}
+
+// Vegas 参考TCP Vegas, 用最小rtt估计排队长度
+// queue = limit * (1 - minRtt/rtt)
+// 排队很少时加大上限, 排队过多或者出错时减小上限
+type Vegas struct {
+	MinLimit int
+	MaxLimit int
+
+	limit  float64
+	minRtt time.Duration
+}
+
+// NewVegas 创建Vegas算法, initial是初始上限
+func NewVegas(initial int) *Vegas {
+	return &Vegas{MinLimit: 1, MaxLimit: 1000, limit: float64(initial)}
+}
+
+func (v *Vegas) Limit() int { return int(v.limit) }
+
+func (v *Vegas) Update(inflight int, rtt time.Duration, outcome Outcome) int {
+	if outcome == Ignored || rtt <= 0 {
+		return v.Limit()
+	}
+	// 失败的调用可能很快返回(连接被拒绝, 马上503), 它们的rtt不代表没有排队时的延迟
+	if outcome == Success && (v.minRtt == 0 || rtt < v.minRtt) {
+		v.minRtt = rtt
+	}
+
+	log := math.Max(1, math.Log10(v.limit))
+	switch {
+	case outcome == Dropped:
+		v.limit -= log
+	case float64(inflight)*2 < v.limit:
+		// 并发没有用满, 观测不到后端的真实能力, 不调整
+	default:
+		queue := math.Ceil(v.limit * (1 - float64(v.minRtt)/float64(rtt)))
+		alpha, beta := 3*log, 6*log
+		switch {
+		case queue <= log:
+			v.limit += beta
+		case queue < alpha:
+			v.limit += log
+		case queue > beta:
+			v.limit -= log
+		}
+	}
+
+	v.limit = float64(clamp(int(v.limit), v.MinLimit, v.MaxLimit))
+	return v.Limit()
+}
+
+// Gradient 用 minRtt/rtt 的比例(梯度)缩放上限, 再加上sqrt(limit)的排队余量
+// 新上限做指数平滑, 避免抖动
+type Gradient struct {
+	MinLimit  int
+	MaxLimit  int
+	Tolerance float64 // 允许rtt涨到minRtt的多少倍还不减小上限
+	Smoothing float64
+
+	limit  float64
+	minRtt time.Duration
+}
+
+// NewGradient 创建Gradient算法, initial是初始上限
+func NewGradient(initial int) *Gradient {
+	return &Gradient{
+		MinLimit:  1,
+		MaxLimit:  1000,
+		Tolerance: 1.5,
+		Smoothing: 0.2,
+		limit:     float64(initial),
+	}
+}
+
+func (g *Gradient) Limit() int { return int(g.limit) }
+
+func (g *Gradient) Update(inflight int, rtt time.Duration, outcome Outcome) int {
+	if outcome == Ignored || rtt <= 0 {
+		return g.Limit()
+	}
+	// 失败的调用可能很快返回(连接被拒绝, 马上503), 它们的rtt不代表没有排队时的延迟
+	if outcome == Success && (g.minRtt == 0 || rtt < g.minRtt) {
+		g.minRtt = rtt
+	}
+
+	var newLimit float64
+	if outcome == Dropped {
+		newLimit = g.limit / 2
+	} else {
+		if float64(inflight)*2 < g.limit {
+			return g.Limit()
+		}
+		gradient := math.Max(0.5, math.Min(1, g.Tolerance*float64(g.minRtt)/float64(rtt)))
+		newLimit = g.limit*gradient + math.Sqrt(g.limit)
+	}
+
+	g.limit = g.limit*(1-g.Smoothing) + newLimit*g.Smoothing
+	g.limit = math.Max(float64(g.MinLimit), math.Min(float64(g.MaxLimit), g.limit))
+	return g.Limit()
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if max > 0 && n > max {
+		return max
+	}
+	return n
+}
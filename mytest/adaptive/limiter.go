@@ -0,0 +1,142 @@
+package adaptive
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Metrics 限流器当前的状态
+type Metrics struct {
+	Limit    int
+	InFlight int
+	Waiting  int
+	Success  uint64
+	Dropped  uint64
+	Ignored  uint64
+}
+
+// Limiter 并发上限会随后端的rtt和错误率变化的限流器
+// 相当于上限可以动态调整的errgroup.SetLimit
+type Limiter struct {
+	mu       sync.Mutex
+	alg      Algorithm
+	inflight int
+	waiters  list.List // 元素是*waiter, 先进先出
+	metrics  Metrics
+	now      func() time.Time
+}
+
+// NewLimiter 用给定的算法创建限流器
+func NewLimiter(alg Algorithm) *Limiter {
+	return &Limiter{alg: alg, now: time.Now}
+}
+
+// Token 代表一个已经拿到的并发名额, 用完后必须Release
+type Token struct {
+	l        *Limiter
+	start    time.Time
+	inflight int
+	once     sync.Once
+}
+
+type waiter struct {
+	ready chan struct{}
+	token *Token
+}
+
+// Acquire 拿一个并发名额, 达到上限时排队等待, ctx取消时返回ctx.Err()
+func (l *Limiter) Acquire(ctx context.Context) (*Token, error) {
+	l.mu.Lock()
+	if l.inflight < l.alg.Limit() && l.waiters.Len() == 0 {
+		t := l.acquireLocked()
+		l.mu.Unlock()
+		return t, nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	elem := l.waiters.PushBack(w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.token, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		select {
+		case <-w.ready:
+			// 已经拿到名额了, 还回去让给下一个
+			l.inflight--
+			l.notifyLocked()
+		default:
+			l.waiters.Remove(elem)
+		}
+		l.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// TryAcquire 不等待, 没有名额时返回false
+func (l *Limiter) TryAcquire() (*Token, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight < l.alg.Limit() && l.waiters.Len() == 0 {
+		return l.acquireLocked(), true
+	}
+	return nil, false
+}
+
+func (l *Limiter) acquireLocked() *Token {
+	l.inflight++
+	return &Token{l: l, start: l.now(), inflight: l.inflight}
+}
+
+// Release 归还名额, 并把这次调用的结果交给算法调整上限
+// 多次调用只有第一次生效
+func (t *Token) Release(outcome Outcome) {
+	t.once.Do(func() {
+		l := t.l
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.inflight--
+		switch outcome {
+		case Success:
+			l.metrics.Success++
+		case Dropped:
+			l.metrics.Dropped++
+		default:
+			l.metrics.Ignored++
+		}
+		l.alg.Update(t.inflight, l.now().Sub(t.start), outcome)
+		l.notifyLocked()
+	})
+}
+
+// notifyLocked 上限允许的话, 按顺序把名额直接交给等待的go程
+func (l *Limiter) notifyLocked() {
+	for e := l.waiters.Front(); e != nil && l.inflight < l.alg.Limit(); e = l.waiters.Front() {
+		w := l.waiters.Remove(e).(*waiter)
+		w.token = l.acquireLocked()
+		close(w.ready)
+	}
+}
+
+// Limit 返回当前的并发上限
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.alg.Limit()
+}
+
+// Metrics 返回当前的状态快照
+func (l *Limiter) Metrics() Metrics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := l.metrics
+	m.Limit = l.alg.Limit()
+	m.InFlight = l.inflight
+	m.Waiting = l.waiters.Len()
+	return m
+}
@@ -0,0 +1,159 @@
+package adaptive
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_AIMD(t *testing.T) {
+	a := NewAIMD(10)
+
+	// 并发没用到一半, 不加
+	if n := a.Update(2, time.Millisecond, Success); n != 10 {
+		t.Fatalf("limit = %d", n)
+	}
+	if n := a.Update(8, time.Millisecond, Success); n != 11 {
+		t.Fatalf("limit = %d", n)
+	}
+	if n := a.Update(8, time.Millisecond, Dropped); n != 9 {
+		t.Fatalf("limit = %d", n)
+	}
+	// 超时当成失败
+	if n := a.Update(8, time.Minute, Success); n != 8 {
+		t.Fatalf("limit = %d", n)
+	}
+	if n := a.Update(8, time.Minute, Ignored); n != 8 {
+		t.Fatalf("limit = %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		a.Update(1, time.Millisecond, Dropped)
+	}
+	if a.Limit() != a.MinLimit {
+		t.Fatalf("limit = %d", a.Limit())
+	}
+}
+
+func Test_Vegas(t *testing.T) {
+	v := NewVegas(20)
+
+	// rtt一直等于最小rtt, 说明没有排队, 上限一直涨
+	for i := 0; i < 10; i++ {
+		v.Update(v.Limit(), 10*time.Millisecond, Success)
+	}
+	grown := v.Limit()
+	if grown <= 20 {
+		t.Fatalf("limit should grow, got %d", grown)
+	}
+
+	// rtt变成4倍, 排队很长, 上限往下走
+	for i := 0; i < 10; i++ {
+		v.Update(v.Limit(), 40*time.Millisecond, Success)
+	}
+	if v.Limit() >= grown {
+		t.Fatalf("limit should shrink, got %d >= %d", v.Limit(), grown)
+	}
+
+	before := v.Limit()
+	v.Update(v.Limit(), 10*time.Millisecond, Dropped)
+	if v.Limit() >= before {
+		t.Fatalf("drop should shrink the limit")
+	}
+}
+
+func Test_Gradient(t *testing.T) {
+	g := NewGradient(20)
+	for i := 0; i < 20; i++ {
+		g.Update(g.Limit(), 10*time.Millisecond, Success)
+	}
+	grown := g.Limit()
+	if grown <= 20 {
+		t.Fatalf("limit should grow, got %d", grown)
+	}
+	for i := 0; i < 20; i++ {
+		g.Update(g.Limit(), 100*time.Millisecond, Success)
+	}
+	if g.Limit() >= grown {
+		t.Fatalf("limit should shrink, got %d >= %d", g.Limit(), grown)
+	}
+}
+
+func Test_Limiter_Block(t *testing.T) {
+	l := NewLimiter(NewAIMD(2))
+	ctx := context.Background()
+
+	t1, _ := l.Acquire(ctx)
+	t2, _ := l.Acquire(ctx)
+	if _, ok := l.TryAcquire(); ok {
+		t.Fatal("TryAcquire should fail at the limit")
+	}
+
+	got := make(chan *Token)
+	go func() {
+		tok, _ := l.Acquire(ctx)
+		got <- tok
+	}()
+	waitFor(t, func() bool { return l.Metrics().Waiting == 1 })
+
+	t1.Release(Dropped)
+	// Dropped之后上限变成1, t2还没释放, 等待者还要继续等
+	select {
+	case <-got:
+		t.Fatal("waiter should still block")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	t2.Release(Ignored)
+	tok := <-got
+	tok.Release(Success)
+	tok.Release(Success) // 重复释放不生效
+
+	m := l.Metrics()
+	if m.InFlight != 0 || m.Waiting != 0 || m.Dropped != 1 || m.Ignored != 1 || m.Success != 1 {
+		t.Fatalf("metrics: %+v", m)
+	}
+}
+
+func Test_Limiter_AcquireCancel(t *testing.T) {
+	l := NewLimiter(NewAIMD(1))
+	tok, _ := l.Acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+
+	tok.Release(Ignored)
+	if m := l.Metrics(); m.InFlight != 0 || m.Waiting != 0 {
+		t.Fatalf("metrics: %+v", m)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+// Test_FastDropsKeepMinRtt 连接被拒绝这种很快的失败不能把minRtt拉到接近0,
+// 否则之后正常的rtt都被当成排队, 上限一路掉到最小
+func Test_FastDropsKeepMinRtt(t *testing.T) {
+	for name, alg := range map[string]Algorithm{"vegas": NewVegas(20), "gradient": NewGradient(20)} {
+		for i := 0; i < 5; i++ {
+			alg.Update(alg.Limit(), 50*time.Microsecond, Dropped)
+		}
+		dropped := alg.Limit()
+		for i := 0; i < 30; i++ {
+			alg.Update(alg.Limit(), 10*time.Millisecond, Success)
+		}
+		if alg.Limit() <= dropped {
+			t.Fatalf("%s: limit should recover after successes, got %d <= %d", name, alg.Limit(), dropped)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package autotune
+
+import (
+	"errors"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 按容器的限制调整Go runtime
+// read-book/docker/test/loop里开n个死循环的go程, GOMAXPROCS默认是机器的核数, 比cpu quota大很多时
+// 每个period很快用完quota, 剩下的时间整个进程都被限流. memlimit里一直分配内存, GC只看GOGC,
+// 不知道容器的内存上限, 堆还没到触发GC的大小就被OOM kill了
+// Tuner启动时和之后定时读cgroup, 设置GOMAXPROCS和debug.SetMemoryLimit
+
+const (
+	defaultInterval = time.Minute
+	defaultHeadroom = 0.1
+)
+
+type options struct {
+	root          string
+	interval      time.Duration
+	headroom      float64
+	headroomBytes uint64
+	minProcs      int
+	clock         second.Clock
+	logf          func(format string, args ...any)
+}
+
+// Option Tuner的选项
+type Option func(*options)
+
+// WithRoot 直接读root下的cgroup文件, 测试时指向fixture目录
+// 默认用cgroup.Self找当前进程所在的cgroup, 没有cgroup namespace时(systemd服务, v2的cgroupns=host)
+// 挂载点是根cgroup, 根cgroup没有cpu.max和memory.max, 读挂载点什么也调不了
+func WithRoot(root string) Option {
+	return func(o *options) {
+		o.root = root
+	}
+}
+
+// WithInterval 多久重新读一次cgroup, 默认1分钟, 容器的限制可以在运行时改(docker update)
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithHeadroom 内存上限里留给非堆内存(栈, cgo, page cache)的比例, 默认0.1
+func WithHeadroom(ratio float64) Option {
+	return func(o *options) {
+		o.headroom = ratio
+	}
+}
+
+// WithHeadroomBytes 至少留多少字节, 和WithHeadroom取大的, 小容器里按比例留得太少
+func WithHeadroomBytes(n uint64) Option {
+	return func(o *options) {
+		o.headroomBytes = n
+	}
+}
+
+// WithMinProcs GOMAXPROCS最小是多少, 默认1
+func WithMinProcs(n int) Option {
+	return func(o *options) {
+		o.minProcs = n
+	}
+}
+
+// WithClock 定时用的时钟, 测试时用FakeClock
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithLogger 每次调整时的日志, 默认log.Printf
+func WithLogger(logf func(format string, args ...any)) Option {
+	return func(o *options) {
+		o.logf = logf
+	}
+}
+
+// Tuner 按cgroup的限制设置GOMAXPROCS和内存上限
+// 环境变量GOMAXPROCS或GOMEMLIMIT设置过时, 对应的那一项不动, 以用户的为准
+type Tuner struct {
+	opts options
+	cg   *cgroup.Cgroup
+
+	// 下面几个测试时替换
+	getenv       func(string) string
+	numCPU       int
+	setMaxProcs  func(int) int
+	setMemLimit  func(int64) int64
+	origProcs    int
+	origMemLimit int64
+}
+
+func buildOptions(opts []Option) options {
+	o := options{
+		interval: defaultInterval,
+		headroom: defaultHeadroom,
+		minProcs: 1,
+		clock:    second.RealClock,
+		logf:     log.Printf,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// New 创建Tuner, 读不到cgroup时返回错误
+func New(opts ...Option) (*Tuner, error) {
+	o := buildOptions(opts)
+	open := cgroup.Self
+	if o.root != "" {
+		open = func() (*cgroup.Cgroup, error) { return cgroup.Open(o.root) }
+	}
+	cg, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return newTuner(cg, o), nil
+}
+
+func newTuner(cg *cgroup.Cgroup, o options) *Tuner {
+	return &Tuner{
+		opts:         o,
+		cg:           cg,
+		getenv:       os.Getenv,
+		numCPU:       runtime.NumCPU(),
+		setMaxProcs:  runtime.GOMAXPROCS,
+		setMemLimit:  debug.SetMemoryLimit,
+		origProcs:    runtime.GOMAXPROCS(0),
+		origMemLimit: debug.SetMemoryLimit(-1),
+	}
+}
+
+// Tune 读一次cgroup并调整, 两项都会尝试, 返回遇到的错误
+func (t *Tuner) Tune() error {
+	return errors.Join(t.tuneProcs(), t.tuneMemory())
+}
+
+// tuneProcs GOMAXPROCS取quota向下取整, 和uber的automaxprocs一样
+// 向上取整的话最后一个P只能用到一部分quota, 还是会被限流
+func (t *Tuner) tuneProcs() error {
+	if t.getenv("GOMAXPROCS") != "" {
+		return nil
+	}
+	s, err := t.cg.CPU()
+	if err != nil {
+		return err
+	}
+
+	want := t.origProcs
+	if limit := s.Limit(); limit > 0 {
+		want = min(max(int(math.Floor(limit)), t.opts.minProcs), t.numCPU)
+	}
+	if prev := t.setMaxProcs(0); prev != want {
+		t.setMaxProcs(want)
+		t.opts.logf("autotune: GOMAXPROCS %d -> %d (cpu limit %.2f)", prev, want, s.Limit())
+	}
+	return nil
+}
+
+// tuneMemory 内存上限 = limit - headroom, 没有限制时恢复启动时的值
+func (t *Tuner) tuneMemory() error {
+	if t.getenv("GOMEMLIMIT") != "" {
+		return nil
+	}
+	m, err := t.cg.Memory()
+	if err != nil {
+		return err
+	}
+
+	want := t.origMemLimit
+	if m.Limit > 0 {
+		want = int64(m.Limit - t.headroom(m.Limit))
+	}
+	if prev := t.setMemLimit(-1); prev != want {
+		t.setMemLimit(want)
+		t.opts.logf("autotune: memory limit %s -> %s (cgroup limit %s)",
+			formatBytes(prev), formatBytes(want), formatBytes(int64(m.Limit)))
+	}
+	return nil
+}
+
+func (t *Tuner) headroom(limit uint64) uint64 {
+	h := max(uint64(float64(limit)*t.opts.headroom), t.opts.headroomBytes)
+	// 留得比上限还多就没法设了, 至少给堆留一半
+	return min(h, limit/2)
+}
+
+// Run 定时调整, stop关闭时返回, 调整失败只打日志
+func (t *Tuner) Run(stop <-chan struct{}) {
+	ticker := t.opts.clock.NewTicker(t.opts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			if err := t.Tune(); err != nil {
+				t.opts.logf("autotune: %s", err)
+			}
+		}
+	}
+}
+
+// Start 马上调整一次, 然后在后台定时调整, 返回的函数停止后台的go程
+// 在main的开头调用:
+//
+//	stop, err := autotune.Start()
+//	if err != nil {
+//		log.Printf("autotune: %s", err)
+//	}
+//	defer stop()
+func Start(opts ...Option) (stop func(), err error) {
+	t, err := New(opts...)
+	if err != nil {
+		return func() {}, err
+	}
+	// 第一次失败也接着跑, 可能只是某个controller没挂载
+	err = t.Tune()
+
+	done := make(chan struct{})
+	go t.Run(done)
+	return func() { close(done) }, err
+}
+
+// formatBytes 日志里用的, math.MaxInt64表示没有限制
+func formatBytes(n int64) string {
+	if n == math.MaxInt64 {
+		return "unlimited"
+	}
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + string("KMGTPE"[exp]) + "iB"
+}
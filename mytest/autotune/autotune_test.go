@@ -0,0 +1,299 @@
+package autotune
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+const mb = 1 << 20
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeRuntime 代替runtime.GOMAXPROCS和debug.SetMemoryLimit, 不改测试进程本身
+type fakeRuntime struct {
+	mu       sync.Mutex
+	procs    int
+	memLimit int64
+	env      map[string]string
+	logs     []string
+}
+
+func (f *fakeRuntime) get() (int, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.procs, f.memLimit
+}
+
+func (f *fakeRuntime) logged() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.logs...)
+}
+
+func newTestTuner(t *testing.T, root string, f *fakeRuntime, opts ...Option) *Tuner {
+	t.Helper()
+	opts = append([]Option{WithRoot(root), WithLogger(func(format string, args ...any) {
+		f.mu.Lock()
+		f.logs = append(f.logs, fmt.Sprintf(format, args...))
+		f.mu.Unlock()
+	})}, opts...)
+
+	tu, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.getenv = func(k string) string { return f.env[k] }
+	tu.numCPU = 8
+	tu.origProcs, tu.origMemLimit = f.procs, f.memLimit
+	tu.setMaxProcs = func(n int) int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		prev := f.procs
+		if n > 0 {
+			f.procs = n
+		}
+		return prev
+	}
+	tu.setMemLimit = func(n int64) int64 {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		prev := f.memLimit
+		if n >= 0 {
+			f.memLimit = n
+		}
+		return prev
+	}
+	return tu
+}
+
+func v2Files(cpuMax, memMax string) map[string]string {
+	return map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"cpu.max":            cpuMax + "\n",
+		"cpu.stat":           "usage_usec 0\n",
+		"memory.max":         memMax + "\n",
+		"memory.current":     "0\n",
+		"memory.stat":        "anon 0\nfile 0\n",
+	}
+}
+
+func Test_Tune(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		opts      []Option
+		env       map[string]string
+		wantProcs int
+		wantMem   int64
+	}{
+		{
+			name:      "v2 quota rounds down",
+			files:     v2Files("250000 100000", fmt.Sprint(1024*mb)),
+			wantProcs: 2,
+			wantMem:   1024*mb - 1024*mb/10,
+		},
+		{
+			name: "v1",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "50000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"cpu/cpu.stat":                 "nr_periods 0\n",
+				"cpuacct/cpuacct.usage":        "0\n",
+				"cpuacct/cpuacct.stat":         "user 0\nsystem 0\n",
+				"memory/memory.limit_in_bytes": fmt.Sprint(512 * mb),
+				"memory/memory.usage_in_bytes": "0\n",
+				"memory/memory.stat":           "cache 0\n",
+			},
+			wantProcs: 1, // 半个核也至少给1
+			wantMem:   512*mb - 512*mb/10,
+		},
+		{
+			name:      "quota bigger than machine",
+			files:     v2Files("3200000 100000", fmt.Sprint(1024*mb)),
+			wantProcs: 8,
+			wantMem:   1024*mb - 1024*mb/10,
+		},
+		{
+			name:      "headroom bytes",
+			files:     v2Files("200000 100000", fmt.Sprint(256*mb)),
+			opts:      []Option{WithHeadroomBytes(64 * mb)},
+			wantProcs: 2,
+			wantMem:   192 * mb,
+		},
+		{
+			name:      "headroom capped at half",
+			files:     v2Files("200000 100000", fmt.Sprint(100*mb)),
+			opts:      []Option{WithHeadroom(0.9)},
+			wantProcs: 2,
+			wantMem:   50 * mb,
+		},
+		{
+			name:      "unlimited keeps original",
+			files:     v2Files("max 100000", "max"),
+			wantProcs: 8,
+			wantMem:   math.MaxInt64,
+		},
+		{
+			name:      "env wins",
+			files:     v2Files("200000 100000", fmt.Sprint(1024*mb)),
+			env:       map[string]string{"GOMAXPROCS": "6", "GOMEMLIMIT": "300MiB"},
+			wantProcs: 8,
+			wantMem:   math.MaxInt64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tt.files)
+			f := &fakeRuntime{procs: 8, memLimit: math.MaxInt64, env: tt.env}
+			tu := newTestTuner(t, root, f, tt.opts...)
+
+			if err := tu.Tune(); err != nil {
+				t.Fatal(err)
+			}
+			procs, mem := f.get()
+			if procs != tt.wantProcs || mem != tt.wantMem {
+				t.Fatalf("GOMAXPROCS=%d memLimit=%d, want %d %d", procs, mem, tt.wantProcs, tt.wantMem)
+			}
+		})
+	}
+}
+
+// Test_Tune_Log 只有值变了才打日志
+func Test_Tune_Log(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, v2Files("200000 100000", fmt.Sprint(1024*mb)))
+	f := &fakeRuntime{procs: 8, memLimit: math.MaxInt64}
+	tu := newTestTuner(t, root, f)
+
+	for i := 0; i < 3; i++ {
+		if err := tu.Tune(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logs := f.logged()
+	if len(logs) != 2 {
+		t.Fatalf("logs = %q, want 2 lines", logs)
+	}
+	if want := "autotune: GOMAXPROCS 8 -> 2 (cpu limit 2.00)"; logs[0] != want {
+		t.Fatalf("logs[0] = %q, want %q", logs[0], want)
+	}
+	if want := "autotune: memory limit unlimited -> 921.6MiB (cgroup limit 1.0GiB)"; logs[1] != want {
+		t.Fatalf("logs[1] = %q, want %q", logs[1], want)
+	}
+}
+
+// Test_Run 运行中改了限制(docker update), 下一次定时调整生效
+func Test_Run(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, v2Files("400000 100000", fmt.Sprint(1024*mb)))
+	clk := second.NewFakeClock(epoch)
+	f := &fakeRuntime{procs: 8, memLimit: math.MaxInt64}
+	tu := newTestTuner(t, root, f, WithClock(clk), WithInterval(time.Second))
+
+	if err := tu.Tune(); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		tu.Run(stop)
+		close(done)
+	}()
+	clk.BlockUntil(1)
+
+	writeFiles(t, root, v2Files("100000 100000", "max"))
+	waitFor(t, func() bool {
+		clk.Advance(time.Second)
+		procs, mem := f.get()
+		return procs == 1 && mem == math.MaxInt64
+	})
+
+	close(stop)
+	<-done
+}
+
+// Test_Run_Error 读失败只打日志, 接着跑
+func Test_Run_Error(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, v2Files("200000 100000", "max"))
+	clk := second.NewFakeClock(epoch)
+	f := &fakeRuntime{procs: 8, memLimit: math.MaxInt64}
+	tu := newTestTuner(t, root, f, WithClock(clk), WithInterval(time.Second))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go tu.Run(stop)
+	clk.BlockUntil(1)
+
+	if err := os.Remove(filepath.Join(root, "cpu.max")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		clk.Advance(time.Second)
+		for _, l := range f.logged() {
+			if strings.Contains(l, "cpu.max") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func Test_formatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		512:           "512B",
+		1536:          "1.5KiB",
+		100 * mb:      "100.0MiB",
+		math.MaxInt64: "unlimited",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Fatalf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Test_New_Self 不传WithRoot时读当前进程所在的cgroup, 不在cgroup里的环境跳过
+func Test_New_Self(t *testing.T) {
+	if _, err := New(); err != nil {
+		t.Skip(err)
+	}
+	f := &fakeRuntime{procs: 8, memLimit: math.MaxInt64}
+	tu := newTestTuner(t, "", f)
+	if err := tu.Tune(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,231 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/go-zero/breaker/breaker.md
+
+// ErrServiceUnavailable 熔断器拒绝请求时返回的错误
+var ErrServiceUnavailable = errors.New("circuit breaker is open")
+
+type (
+	// Acceptable 判断err算不算成功, 比如业务上的not found不应该触发熔断
+	Acceptable func(err error) bool
+
+	// Fallback 请求被熔断器拒绝时调用, 返回值作为请求的结果
+	Fallback func(err error) error
+
+	// Breaker 熔断器
+	Breaker interface {
+		// Name 熔断器的名字
+		Name() string
+
+		// Allow 检查请求是否允许, 允许时返回Promise
+		// 调用者在成功时调用promise.Accept(), 失败时调用promise.Reject()
+		Allow() (Promise, error)
+		// AllowCtx 和Allow一样, ctx结束时直接返回ctx.Err()
+		AllowCtx(ctx context.Context) (Promise, error)
+
+		// Do 熔断器允许时执行req, 否则直接返回错误
+		// req里panic算失败, panic会继续往上抛
+		Do(req func() error) error
+		// DoCtx 和Do一样, 带ctx
+		DoCtx(ctx context.Context, req func() error) error
+
+		// DoWithAcceptable 和Do一样, acceptable判断req的结果算不算成功
+		DoWithAcceptable(req func() error, acceptable Acceptable) error
+		// DoWithAcceptableCtx 和DoWithAcceptable一样, 带ctx
+		DoWithAcceptableCtx(ctx context.Context, req func() error, acceptable Acceptable) error
+
+		// DoWithFallback 和Do一样, 被拒绝时调用fallback
+		DoWithFallback(req func() error, fallback Fallback) error
+		// DoWithFallbackCtx 和DoWithFallback一样, 带ctx
+		DoWithFallbackCtx(ctx context.Context, req func() error, fallback Fallback) error
+
+		// DoWithFallbackAcceptable 同时带fallback和acceptable
+		DoWithFallbackAcceptable(req func() error, fallback Fallback, acceptable Acceptable) error
+		// DoWithFallbackAcceptableCtx 和DoWithFallbackAcceptable一样, 带ctx
+		DoWithFallbackAcceptableCtx(ctx context.Context, req func() error, fallback Fallback,
+			acceptable Acceptable) error
+	}
+
+	// Promise Allow的回调, 告诉熔断器请求的结果
+	// 每个Promise都要调用Accept或者Reject, 经典熔断器半开时没有回音的探测请求要等OpenTimeout才会作废
+	Promise interface {
+		// Accept 请求成功
+		Accept()
+		// Reject 请求失败
+		Reject()
+	}
+
+	// StateReporter WithClassic创建的熔断器实现了它, 用来看当前状态
+	// 谷歌的自适应熔断没有三态, 没有实现
+	StateReporter interface {
+		State() State
+	}
+
+	// Option 定制熔断器
+	Option func(o *options)
+
+	// throttle 熔断算法, 谷歌的自适应熔断和经典的三态熔断都实现了它
+	throttle interface {
+		allow() (Promise, error)
+		doReq(req func() error, fallback Fallback, acceptable Acceptable) error
+	}
+
+	options struct {
+		name    string
+		clock   second.Clock
+		random  func() float64
+		classic *ClassicConfig
+	}
+
+	circuitBreaker struct {
+		name string
+		throttle
+	}
+
+	// classicCircuitBreaker 多了State方法
+	classicCircuitBreaker struct {
+		*circuitBreaker
+		classic *classicBreaker
+	}
+)
+
+var breakerID uint64
+
+// WithName 熔断器的名字, 不设置时自动生成一个
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithClock 指定时钟, 测试时用second.FakeClock
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithRandom 指定[0,1)的随机数来源, 谷歌熔断按概率丢弃请求, 测试时可以固定下来
+func WithRandom(random func() float64) Option {
+	return func(o *options) {
+		o.random = random
+	}
+}
+
+// WithClassic 使用经典的closed/open/half-open三态熔断, 默认是谷歌的自适应熔断
+func WithClassic(c ClassicConfig) Option {
+	return func(o *options) {
+		o.classic = &c
+	}
+}
+
+// NewBreaker 创建熔断器
+func NewBreaker(opts ...Option) Breaker {
+	o := options{clock: second.RealClock, random: rand.Float64}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.name == "" {
+		o.name = "breaker-" + strconv.FormatUint(atomic.AddUint64(&breakerID, 1), 10)
+	}
+
+	b := &circuitBreaker{name: o.name}
+	if o.classic != nil {
+		classic := newClassicBreaker(o.name, *o.classic, o.clock)
+		b.throttle = classic
+		return classicCircuitBreaker{circuitBreaker: b, classic: classic}
+	}
+	b.throttle = newGoogleBreaker(o.clock, o.random)
+	return b
+}
+
+func (cb classicCircuitBreaker) State() State {
+	return cb.classic.State()
+}
+
+func (cb *circuitBreaker) Name() string {
+	return cb.name
+}
+
+func (cb *circuitBreaker) Allow() (Promise, error) {
+	return cb.throttle.allow()
+}
+
+func (cb *circuitBreaker) AllowCtx(ctx context.Context) (Promise, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return cb.Allow()
+	}
+}
+
+func (cb *circuitBreaker) Do(req func() error) error {
+	return cb.throttle.doReq(req, nil, defaultAcceptable)
+}
+
+func (cb *circuitBreaker) DoCtx(ctx context.Context, req func() error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return cb.Do(req)
+	}
+}
+
+func (cb *circuitBreaker) DoWithAcceptable(req func() error, acceptable Acceptable) error {
+	return cb.throttle.doReq(req, nil, acceptable)
+}
+
+func (cb *circuitBreaker) DoWithAcceptableCtx(ctx context.Context, req func() error,
+	acceptable Acceptable) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return cb.DoWithAcceptable(req, acceptable)
+	}
+}
+
+func (cb *circuitBreaker) DoWithFallback(req func() error, fallback Fallback) error {
+	return cb.throttle.doReq(req, fallback, defaultAcceptable)
+}
+
+func (cb *circuitBreaker) DoWithFallbackCtx(ctx context.Context, req func() error,
+	fallback Fallback) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return cb.DoWithFallback(req, fallback)
+	}
+}
+
+func (cb *circuitBreaker) DoWithFallbackAcceptable(req func() error, fallback Fallback,
+	acceptable Acceptable) error {
+	return cb.throttle.doReq(req, fallback, acceptable)
+}
+
+func (cb *circuitBreaker) DoWithFallbackAcceptableCtx(ctx context.Context, req func() error,
+	fallback Fallback, acceptable Acceptable) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return cb.DoWithFallbackAcceptable(req, fallback, acceptable)
+	}
+}
+
+func defaultAcceptable(err error) bool {
+	return err == nil
+}
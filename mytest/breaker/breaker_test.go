@@ -0,0 +1,149 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var (
+	epoch   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	errBoom = errors.New("boom")
+)
+
+func failing() error { return errBoom }
+
+func succeeding() error { return nil }
+
+func Test_GoogleBreaker(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	random := 0.0
+	b := NewBreaker(WithClock(clk), WithRandom(func() float64 { return random }))
+
+	// 请求量很小时不熔断
+	for i := 0; i < protection; i++ {
+		if err := b.Do(failing); err != errBoom {
+			t.Fatalf("err %v", err)
+		}
+	}
+
+	dropped := 0
+	for i := 0; i < 100; i++ {
+		if errors.Is(b.Do(failing), ErrServiceUnavailable) {
+			dropped++
+		}
+	}
+	// 全部失败, 丢弃概率趋近于1
+	if dropped < 90 {
+		t.Fatalf("dropped %d", dropped)
+	}
+
+	fallback := b.DoWithFallback(succeeding, func(err error) error {
+		if !errors.Is(err, ErrServiceUnavailable) {
+			t.Fatalf("fallback err %v", err)
+		}
+		return nil
+	})
+	if fallback != nil {
+		t.Fatalf("fallback result %v", fallback)
+	}
+
+	// 窗口滑过去之后恢复
+	clk.Advance(window)
+	if err := b.Do(succeeding); err != nil {
+		t.Fatalf("err %v after the window", err)
+	}
+}
+
+// 一直被丢弃时每隔forcePassDuration放行一个请求探测下游
+func Test_GoogleBreaker_ForcePass(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	random := 0.0
+	b := NewBreaker(WithClock(clk), WithRandom(func() float64 { return random }))
+	for i := 0; i < 50; i++ {
+		b.Do(failing)
+	}
+
+	// 运气好放行了一个, 记下lastPass
+	random = 0.999
+	if err := b.Do(failing); err != errBoom {
+		t.Fatalf("err %v", err)
+	}
+	random = 0
+	if err := b.Do(succeeding); !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("err %v", err)
+	}
+
+	clk.Advance(forcePassDuration + time.Millisecond)
+	if err := b.Do(succeeding); err != nil {
+		t.Fatalf("force pass err %v", err)
+	}
+	if err := b.Do(succeeding); !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("only one request should be forced through, err %v", err)
+	}
+}
+
+func Test_GoogleBreaker_Acceptable(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	b := NewBreaker(WithClock(clk), WithRandom(func() float64 { return 0 }))
+	errNotFound := errors.New("not found")
+	acceptable := func(err error) bool {
+		return err == nil || errors.Is(err, errNotFound)
+	}
+
+	for i := 0; i < 100; i++ {
+		err := b.DoWithAcceptable(func() error { return errNotFound }, acceptable)
+		if err != errNotFound {
+			t.Fatalf("request %d: err %v", i, err)
+		}
+	}
+}
+
+func Test_GoogleBreaker_Promise(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	b := NewBreaker(WithClock(clk), WithRandom(func() float64 { return 0 }))
+	for i := 0; i < 50; i++ {
+		p, err := b.Allow()
+		if err != nil {
+			if !errors.Is(err, ErrServiceUnavailable) || i < protection {
+				t.Fatalf("request %d: err %v", i, err)
+			}
+			return
+		}
+		p.Reject()
+	}
+	t.Fatal("rejected promises should open the breaker")
+}
+
+func Test_Breaker_Panic(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	b := NewBreaker(WithClock(clk), WithClassic(ClassicConfig{MaxFailures: 1}))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic should be rethrown")
+			}
+		}()
+		b.Do(func() error { panic("boom") })
+	}()
+	// panic算失败
+	if err := b.Do(succeeding); !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_Breaker_Ctx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, b := range []Breaker{NewBreaker(), NewBreaker(WithClassic(ClassicConfig{}))} {
+		if err := b.DoCtx(ctx, succeeding); err != context.Canceled {
+			t.Fatalf("err %v", err)
+		}
+		if _, err := b.AllowCtx(ctx); err != context.Canceled {
+			t.Fatalf("err %v", err)
+		}
+	}
+}
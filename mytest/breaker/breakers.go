@@ -0,0 +1,110 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+)
+
+// 见 read-source-code/go-zero/breaker/breakers.md
+
+var (
+	lock     sync.RWMutex
+	breakers = make(map[string]Breaker)
+)
+
+// Do 用名字是name的熔断器执行req
+func Do(name string, req func() error) error {
+	return do(name, func(b Breaker) error {
+		return b.Do(req)
+	})
+}
+
+// DoCtx 和Do一样, 带ctx
+func DoCtx(ctx context.Context, name string, req func() error) error {
+	return do(name, func(b Breaker) error {
+		return b.DoCtx(ctx, req)
+	})
+}
+
+// DoWithAcceptable 用名字是name的熔断器执行req, acceptable判断结果算不算成功
+func DoWithAcceptable(name string, req func() error, acceptable Acceptable) error {
+	return do(name, func(b Breaker) error {
+		return b.DoWithAcceptable(req, acceptable)
+	})
+}
+
+// DoWithAcceptableCtx 和DoWithAcceptable一样, 带ctx
+func DoWithAcceptableCtx(ctx context.Context, name string, req func() error,
+	acceptable Acceptable) error {
+	return do(name, func(b Breaker) error {
+		return b.DoWithAcceptableCtx(ctx, req, acceptable)
+	})
+}
+
+// DoWithFallback 用名字是name的熔断器执行req, 被拒绝时调用fallback
+func DoWithFallback(name string, req func() error, fallback Fallback) error {
+	return do(name, func(b Breaker) error {
+		return b.DoWithFallback(req, fallback)
+	})
+}
+
+// DoWithFallbackCtx 和DoWithFallback一样, 带ctx
+func DoWithFallbackCtx(ctx context.Context, name string, req func() error, fallback Fallback) error {
+	return do(name, func(b Breaker) error {
+		return b.DoWithFallbackCtx(ctx, req, fallback)
+	})
+}
+
+// DoWithFallbackAcceptable 同时带fallback和acceptable
+func DoWithFallbackAcceptable(name string, req func() error, fallback Fallback,
+	acceptable Acceptable) error {
+	return do(name, func(b Breaker) error {
+		return b.DoWithFallbackAcceptable(req, fallback, acceptable)
+	})
+}
+
+// DoWithFallbackAcceptableCtx 和DoWithFallbackAcceptable一样, 带ctx
+func DoWithFallbackAcceptableCtx(ctx context.Context, name string, req func() error,
+	fallback Fallback, acceptable Acceptable) error {
+	return do(name, func(b Breaker) error {
+		return b.DoWithFallbackAcceptableCtx(ctx, req, fallback, acceptable)
+	})
+}
+
+// GetBreaker 返回名字是name的熔断器, 没有就创建一个谷歌自适应熔断器
+func GetBreaker(name string) Breaker {
+	lock.RLock()
+	b, ok := breakers[name]
+	lock.RUnlock()
+	if ok {
+		return b
+	}
+
+	lock.Lock()
+	b, ok = breakers[name]
+	if !ok {
+		b = NewBreaker(WithName(name))
+		breakers[name] = b
+	}
+	lock.Unlock()
+
+	return b
+}
+
+// SetBreaker 按b.Name()注册熔断器, 比如换成经典熔断器或者用FakeClock的
+func SetBreaker(b Breaker) {
+	lock.Lock()
+	breakers[b.Name()] = b
+	lock.Unlock()
+}
+
+// NoBreakerFor 名字是name的调用不熔断
+func NoBreakerFor(name string) {
+	lock.Lock()
+	breakers[name] = NopBreaker()
+	lock.Unlock()
+}
+
+func do(name string, execute func(b Breaker) error) error {
+	return execute(GetBreaker(name))
+}
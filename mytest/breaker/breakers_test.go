@@ -0,0 +1,37 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+func Test_Breakers(t *testing.T) {
+	if GetBreaker("users") != GetBreaker("users") {
+		t.Fatal("same name should return the same breaker")
+	}
+	if err := Do("users", succeeding); err != nil {
+		t.Fatal(err)
+	}
+
+	clk := second.NewFakeClock(epoch)
+	SetBreaker(NewBreaker(WithName("orders"), WithClock(clk),
+		WithClassic(ClassicConfig{MaxFailures: 1, OpenTimeout: time.Second})))
+	if err := DoWithAcceptable("orders", failing, func(err error) bool { return true }); err != errBoom {
+		t.Fatalf("err %v", err)
+	}
+	Do("orders", failing)
+	err := DoWithFallbackAcceptable("orders", succeeding, func(err error) error {
+		return errors.New("fallback")
+	}, defaultAcceptable)
+	if err == nil || err.Error() != "fallback" {
+		t.Fatalf("err %v", err)
+	}
+
+	NoBreakerFor("orders")
+	if err := Do("orders", succeeding); err != nil {
+		t.Fatalf("nop breaker err %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package breaker
+
+// 见 read-source-code/go-zero/breaker/bucket.md
+
+const (
+	success = iota
+	fail
+	drop
+)
+
+// bucket 一个时间片内的请求统计
+type bucket struct {
+	Sum     int64
+	Success int64
+	Failure int64
+	Drop    int64
+}
+
+func (b *bucket) Add(v int64) {
+	b.Sum++
+	switch v {
+	case fail:
+		b.Failure++
+	case drop:
+		b.Drop++
+	default:
+		b.Success++
+	}
+}
+
+func (b *bucket) Reset() {
+	*b = bucket{}
+}
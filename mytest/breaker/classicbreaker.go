@@ -0,0 +1,208 @@
+package breaker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// State 经典熔断器的状态
+type State int
+
+const (
+	// StateClosed 正常放行, 统计连续失败
+	StateClosed State = iota
+	// StateOpen 全部拒绝, 等OpenTimeout之后进入半开
+	StateOpen
+	// StateHalfOpen 放行少量探测请求, 都成功就关闭, 有一个失败就重新打开
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// ClassicConfig 经典熔断器的配置, 零值字段用默认值
+type ClassicConfig struct {
+	// MaxFailures 连续失败多少次打开, 默认5
+	MaxFailures int
+	// OpenTimeout 打开多久之后进入半开, 默认5秒
+	OpenTimeout time.Duration
+	// HalfOpenRequests 半开时放行多少个探测请求, 默认1
+	HalfOpenRequests int
+	// OnStateChange 状态变化时调用, 在锁里调用, 不要阻塞
+	OnStateChange func(name string, from, to State)
+}
+
+const (
+	defaultMaxFailures      = 5
+	defaultOpenTimeout      = 5 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+// classicBreaker 经典的三态熔断器
+type classicBreaker struct {
+	name  string
+	cfg   ClassicConfig
+	clock second.Clock
+
+	mu         sync.Mutex
+	state      State
+	generation uint64 // 每次状态变化加1, 旧状态下放行的请求结果不再计数
+	failures   int    // closed时连续失败的次数
+	inflight   int    // half-open时放行了多少个探测请求
+	successes  int    // half-open时成功了多少个
+	openedAt   time.Time
+	halfOpenAt time.Time // 这一轮探测开始的时间
+}
+
+func newClassicBreaker(name string, cfg ClassicConfig, clock second.Clock) *classicBreaker {
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = defaultMaxFailures
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultOpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	return &classicBreaker{name: name, cfg: cfg, clock: clock}
+}
+
+// before 请求之前检查, 返回放行时的generation
+func (b *classicBreaker) before() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.clock.Now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.setStateLocked(StateHalfOpen)
+	}
+
+	switch b.state {
+	case StateOpen:
+		return 0, ErrServiceUnavailable
+	case StateHalfOpen:
+		if b.inflight >= b.cfg.HalfOpenRequests {
+			// 探测请求的Promise一直没有Accept/Reject, 超过OpenTimeout就当它们丢了,
+			// 换一个generation重新放行一轮探测, 否则会一直半开并拒绝所有请求
+			if b.clock.Now().Sub(b.halfOpenAt) < b.cfg.OpenTimeout {
+				return 0, ErrServiceUnavailable
+			}
+			log.Printf("breaker %s: half-open probes didn't report back in %s, probing again", b.name, b.cfg.OpenTimeout)
+			b.generation++
+			b.inflight, b.successes = 0, 0
+			b.halfOpenAt = b.clock.Now()
+		}
+		b.inflight++
+	}
+	return b.generation, nil
+}
+
+// after 请求之后记录结果
+func (b *classicBreaker) after(generation uint64, succ bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		if succ {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.MaxFailures {
+			b.setStateLocked(StateOpen)
+		}
+	case StateHalfOpen:
+		if !succ {
+			b.setStateLocked(StateOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setStateLocked(StateClosed)
+		}
+	}
+}
+
+func (b *classicBreaker) setStateLocked(to State) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.failures, b.inflight, b.successes = 0, 0, 0
+	switch to {
+	case StateOpen:
+		b.openedAt = b.clock.Now()
+		log.Printf("breaker %s is open", b.name)
+	case StateHalfOpen:
+		b.halfOpenAt = b.clock.Now()
+	}
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(b.name, from, to)
+	}
+}
+
+// State 当前状态, 打开超过OpenTimeout时返回半开
+func (b *classicBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && b.clock.Now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+func (b *classicBreaker) allow() (Promise, error) {
+	generation, err := b.before()
+	if err != nil {
+		return nil, err
+	}
+	return &classicPromise{b: b, generation: generation}, nil
+}
+
+func (b *classicBreaker) doReq(req func() error, fallback Fallback, acceptable Acceptable) error {
+	generation, err := b.before()
+	if err != nil {
+		if fallback != nil {
+			return fallback(err)
+		}
+		return err
+	}
+
+	var succ bool
+	defer func() {
+		b.after(generation, succ)
+	}()
+
+	err = req()
+	succ = acceptable(err)
+	return err
+}
+
+type classicPromise struct {
+	b          *classicBreaker
+	generation uint64
+	once       sync.Once
+}
+
+func (p *classicPromise) Accept() {
+	p.once.Do(func() { p.b.after(p.generation, true) })
+}
+
+func (p *classicPromise) Reject() {
+	p.once.Do(func() { p.b.after(p.generation, false) })
+}
@@ -0,0 +1,143 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+func Test_ClassicBreaker(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	var changes []string
+	b := NewBreaker(WithName("classic"), WithClock(clk), WithClassic(ClassicConfig{
+		MaxFailures:      3,
+		OpenTimeout:      time.Second,
+		HalfOpenRequests: 2,
+		OnStateChange: func(name string, from, to State) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	}))
+	cb := b.(StateReporter)
+
+	// 成功会清零连续失败的次数
+	b.Do(failing)
+	b.Do(failing)
+	b.Do(succeeding)
+	b.Do(failing)
+	b.Do(failing)
+	if s := cb.State(); s != StateClosed {
+		t.Fatalf("state %s", s)
+	}
+	b.Do(failing)
+	if s := cb.State(); s != StateOpen {
+		t.Fatalf("state %s", s)
+	}
+
+	called := false
+	err := b.DoWithFallback(succeeding, func(err error) error {
+		called = true
+		return err
+	})
+	if !called || !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("fallback called %v, err %v", called, err)
+	}
+
+	// 半开只放行2个探测请求
+	clk.Advance(time.Second)
+	p1, err1 := b.Allow()
+	p2, err2 := b.Allow()
+	_, err3 := b.Allow()
+	if err1 != nil || err2 != nil || !errors.Is(err3, ErrServiceUnavailable) {
+		t.Fatalf("half-open errs %v %v %v", err1, err2, err3)
+	}
+	p1.Accept()
+	p1.Reject() // 重复调用不算
+	if s := cb.State(); s != StateHalfOpen {
+		t.Fatalf("state %s", s)
+	}
+	p2.Accept()
+	if s := cb.State(); s != StateClosed {
+		t.Fatalf("state %s", s)
+	}
+
+	// 半开时失败重新打开
+	for i := 0; i < 3; i++ {
+		b.Do(failing)
+	}
+	clk.Advance(time.Second)
+	b.Do(failing)
+	if s := cb.State(); s != StateOpen {
+		t.Fatalf("state %s", s)
+	}
+
+	want := []string{
+		"closed->open", "open->half-open", "half-open->closed",
+		"closed->open", "open->half-open", "half-open->open",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes %v", changes)
+		}
+	}
+}
+
+// 打开之前放行的请求, 结果回来时不能影响新的状态
+func Test_ClassicBreaker_StaleResult(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	b := NewBreaker(WithClock(clk), WithClassic(ClassicConfig{MaxFailures: 1, OpenTimeout: time.Second}))
+	cb := b.(StateReporter)
+
+	slow, _ := b.Allow()
+	b.Do(failing)
+	clk.Advance(time.Second)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow.Reject()
+	if s := cb.State(); s != StateHalfOpen {
+		t.Fatalf("stale result changed the state to %s", s)
+	}
+	probe.Accept()
+	if s := cb.State(); s != StateClosed {
+		t.Fatalf("state %s", s)
+	}
+}
+
+// 半开时拿到Promise却不回报结果, OpenTimeout之后重新探测, 不会一直半开
+func Test_ClassicBreaker_LostProbe(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	b := NewBreaker(WithClock(clk), WithClassic(ClassicConfig{MaxFailures: 1, OpenTimeout: time.Second}))
+	cb := b.(StateReporter)
+
+	b.Do(failing)
+	clk.Advance(time.Second)
+	lost, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("second probe err %v", err)
+	}
+
+	clk.Advance(time.Second)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatalf("probe after OpenTimeout: %v", err)
+	}
+	// 丢了的探测请求后来回报的结果不算
+	lost.Reject()
+	if s := cb.State(); s != StateHalfOpen {
+		t.Fatalf("state %s", s)
+	}
+	probe.Accept()
+	if s := cb.State(); s != StateClosed {
+		t.Fatalf("state %s", s)
+	}
+}
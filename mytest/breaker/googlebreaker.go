@@ -0,0 +1,154 @@
+package breaker
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/collection"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/go-zero/breaker/googlebreaker.md
+// https://sre.google/sre-book/handling-overload/ Client-Side Throttling
+
+const (
+	window            = time.Second * 10 // 统计窗口
+	buckets           = 40               // 每个桶250ms
+	forcePassDuration = time.Second      // 超过这么久没放行过, 强制放行一个探测请求
+	k                 = 1.5
+	minK              = 1.1
+	protection        = 5 // 请求量太小时不熔断
+)
+
+// googleBreaker 自适应熔断, 丢弃概率 max(0, (requests - K*accepts) / (requests + 1))
+// 连续失败的桶越多K越小, 熔断越激进
+type googleBreaker struct {
+	k        float64
+	stat     *collection.RollingWindow[int64, *bucket]
+	clock    second.Clock
+	random   func() float64
+	lastPass int64 // 上次放行的时间, UnixNano
+}
+
+type windowResult struct {
+	accepts        int64
+	total          int64
+	failingBuckets int64 // 末尾连续只有失败的桶
+	workingBuckets int64 // 末尾连续只有成功的桶
+}
+
+func newGoogleBreaker(clock second.Clock, random func() float64) *googleBreaker {
+	bucketDuration := window / buckets
+	return &googleBreaker{
+		k: k,
+		stat: collection.NewRollingWindow[int64, *bucket](func() *bucket {
+			return new(bucket)
+		}, buckets, bucketDuration, collection.WithClock[int64, *bucket](clock)),
+		clock:  clock,
+		random: random,
+	}
+}
+
+func (b *googleBreaker) accept() error {
+	history := b.history()
+	w := b.k - (b.k-minK)*float64(history.failingBuckets)/buckets
+	weightedAccepts := math.Max(w, minK) * float64(history.accepts)
+	dropRatio := (float64(history.total-protection) - weightedAccepts) / float64(history.total+1)
+	if dropRatio <= 0 {
+		return nil
+	}
+
+	now := b.clock.Now().UnixNano()
+	lastPass := atomic.LoadInt64(&b.lastPass)
+	if lastPass > 0 && time.Duration(now-lastPass) > forcePassDuration {
+		atomic.StoreInt64(&b.lastPass, now)
+		return nil
+	}
+
+	// 最近一直成功的话, 说明下游恢复了, 少丢一些
+	dropRatio *= float64(buckets-history.workingBuckets) / buckets
+	if b.random() < dropRatio {
+		return ErrServiceUnavailable
+	}
+
+	atomic.StoreInt64(&b.lastPass, now)
+	return nil
+}
+
+func (b *googleBreaker) allow() (Promise, error) {
+	if err := b.accept(); err != nil {
+		b.markDrop()
+		return nil, err
+	}
+	return googlePromise{b: b}, nil
+}
+
+func (b *googleBreaker) doReq(req func() error, fallback Fallback, acceptable Acceptable) error {
+	if err := b.accept(); err != nil {
+		b.markDrop()
+		if fallback != nil {
+			return fallback(err)
+		}
+		return err
+	}
+
+	var succ bool
+	defer func() {
+		// req panic时succ是false, 算失败
+		if succ {
+			b.markSuccess()
+		} else {
+			b.markFailure()
+		}
+	}()
+
+	err := req()
+	if acceptable(err) {
+		succ = true
+	}
+	return err
+}
+
+func (b *googleBreaker) markSuccess() {
+	b.stat.Add(success)
+}
+
+func (b *googleBreaker) markFailure() {
+	b.stat.Add(fail)
+}
+
+func (b *googleBreaker) markDrop() {
+	b.stat.Add(drop)
+}
+
+func (b *googleBreaker) history() windowResult {
+	var result windowResult
+	b.stat.Reduce(func(b *bucket) {
+		result.accepts += b.Success
+		result.total += b.Sum
+		if b.Failure > 0 {
+			result.workingBuckets = 0
+		} else if b.Success > 0 {
+			result.workingBuckets++
+		}
+		if b.Success > 0 {
+			result.failingBuckets = 0
+		} else if b.Failure > 0 {
+			result.failingBuckets++
+		}
+	})
+	return result
+}
+
+type googlePromise struct {
+	b *googleBreaker
+}
+
+func (p googlePromise) Accept() {
+	p.b.markSuccess()
+}
+
+func (p googlePromise) Reject() {
+	p.b.markFailure()
+}
@@ -0,0 +1,65 @@
+package breaker
+
+import "context"
+
+// 见 read-source-code/go-zero/breaker/nopbreaker.md
+
+const nopBreakerName = "nopBreaker"
+
+type nopBreaker struct{}
+
+// NopBreaker 永远不熔断的熔断器
+func NopBreaker() Breaker {
+	return nopBreaker{}
+}
+
+func (b nopBreaker) Name() string {
+	return nopBreakerName
+}
+
+func (b nopBreaker) Allow() (Promise, error) {
+	return nopPromise{}, nil
+}
+
+func (b nopBreaker) AllowCtx(_ context.Context) (Promise, error) {
+	return nopPromise{}, nil
+}
+
+func (b nopBreaker) Do(req func() error) error {
+	return req()
+}
+
+func (b nopBreaker) DoCtx(_ context.Context, req func() error) error {
+	return req()
+}
+
+func (b nopBreaker) DoWithAcceptable(req func() error, _ Acceptable) error {
+	return req()
+}
+
+func (b nopBreaker) DoWithAcceptableCtx(_ context.Context, req func() error, _ Acceptable) error {
+	return req()
+}
+
+func (b nopBreaker) DoWithFallback(req func() error, _ Fallback) error {
+	return req()
+}
+
+func (b nopBreaker) DoWithFallbackCtx(_ context.Context, req func() error, _ Fallback) error {
+	return req()
+}
+
+func (b nopBreaker) DoWithFallbackAcceptable(req func() error, _ Fallback, _ Acceptable) error {
+	return req()
+}
+
+func (b nopBreaker) DoWithFallbackAcceptableCtx(_ context.Context, req func() error,
+	_ Fallback, _ Acceptable) error {
+	return req()
+}
+
+type nopPromise struct{}
+
+func (p nopPromise) Accept() {}
+
+func (p nopPromise) Reject() {}
@@ -0,0 +1,198 @@
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 读容器的资源限制和用量, 代替read-book/docker/test里分配内存, 调free -h, 死循环这些探测方法
+// v1和v2的文件名不一样, 这里统一成MemoryStats/CPUStats/PidsStats, 数值里0表示没有限制
+
+// DefaultRoot cgroup的挂载点
+const DefaultRoot = "/sys/fs/cgroup"
+
+// Version cgroup的版本
+type Version int
+
+const (
+	V1 Version = 1
+	V2 Version = 2
+)
+
+// ErrNotFound root下面不是cgroup
+var ErrNotFound = errors.New("cgroup: not found")
+
+// unlimitedThreshold v1没有限制时写的是一个接近int64最大值的数
+const unlimitedThreshold = 1 << 62
+
+// Cgroup 一个cgroup, v2是一个目录, v1是每个controller一个目录
+type Cgroup struct {
+	version Version
+	dirs    map[string]string // controller -> 目录, v2时都一样
+}
+
+// Open 打开root下的cgroup, 自动识别v1和v2
+// 容器里root一般就是/sys/fs/cgroup, 测试时指向fixture目录
+func Open(root string) (*Cgroup, error) {
+	return open(root, nil)
+}
+
+// Self 打开当前进程所在的cgroup, 读/proc/self/cgroup找到相对路径
+// 找不到对应的目录时(比如在容器里, 路径已经被namespace隐藏)就用root本身
+func Self() (*Cgroup, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths, err := parseProcCgroup(f)
+	if err != nil {
+		return nil, err
+	}
+	return open(DefaultRoot, paths)
+}
+
+// v1的controller可能和别的挂在一起, 比如cpu,cpuacct
+var v1Controllers = map[string][]string{
+	"memory":  {"memory"},
+	"cpu":     {"cpu", "cpu,cpuacct", "cpuacct,cpu"},
+	"cpuacct": {"cpuacct", "cpu,cpuacct", "cpuacct,cpu"},
+	"pids":    {"pids"},
+}
+
+// open paths是/proc/self/cgroup里每个controller的路径, v2的key是空字符串
+func open(root string, paths map[string]string) (*Cgroup, error) {
+	if exists(filepath.Join(root, "cgroup.controllers")) {
+		dir := root
+		if p, ok := paths[""]; ok && exists(filepath.Join(root, p, "cgroup.controllers")) {
+			dir = filepath.Join(root, p)
+		}
+		c := &Cgroup{version: V2, dirs: make(map[string]string)}
+		for name := range v1Controllers {
+			c.dirs[name] = dir
+		}
+		return c, nil
+	}
+
+	c := &Cgroup{version: V1, dirs: make(map[string]string)}
+	for name, mounts := range v1Controllers {
+		for _, mount := range mounts {
+			dir := filepath.Join(root, mount)
+			if !exists(dir) {
+				continue
+			}
+			if p, ok := paths[name]; ok && exists(filepath.Join(dir, p)) {
+				dir = filepath.Join(dir, p)
+			}
+			c.dirs[name] = dir
+			break
+		}
+	}
+	if len(c.dirs) == 0 {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// parseProcCgroup 解析/proc/self/cgroup, 每行是 id:controllers:path
+func parseProcCgroup(r io.Reader) (map[string]string, error) {
+	paths := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, name := range strings.Split(parts[1], ",") {
+			paths[name] = parts[2]
+		}
+	}
+	return paths, s.Err()
+}
+
+// Version cgroup的版本
+func (c *Cgroup) Version() Version {
+	return c.version
+}
+
+// path controller下的文件, controller没有挂载时返回ErrNotFound
+func (c *Cgroup) path(controller, file string) (string, error) {
+	dir, ok := c.dirs[controller]
+	if !ok {
+		return "", fmt.Errorf("cgroup: %s controller: %w", controller, ErrNotFound)
+	}
+	return filepath.Join(dir, file), nil
+}
+
+func (c *Cgroup) readFile(controller, file string) (string, error) {
+	path, err := c.path(controller, file)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readUint 读一个数, max和超大的数都当作没有限制, 返回0
+func (c *Cgroup) readUint(controller, file string) (uint64, error) {
+	s, err := c.readFile(controller, file)
+	if err != nil {
+		return 0, err
+	}
+	return parseLimit(s)
+}
+
+func parseLimit(s string) (uint64, error) {
+	if s == "max" || s == "-1" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cgroup: parse %q: %w", s, err)
+	}
+	if v >= unlimitedThreshold {
+		return 0, nil
+	}
+	return v, nil
+}
+
+// readKV 读memory.stat, cpu.stat这种每行 key value 的文件
+func (c *Cgroup) readKV(controller, file string) (map[string]uint64, error) {
+	s, err := c.readFile(controller, file)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := make(map[string]uint64)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cgroup: parse %s: %w", file, err)
+		}
+		kv[fields[0]] = v
+	}
+	return kv, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,268 @@
+package cgroup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mb = 1 << 20
+
+// writeFiles 在dir下生成cgroup文件, testdata里没有的情况用它
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_Open(t *testing.T) {
+	for root, want := range map[string]Version{"testdata/v1": V1, "testdata/v2": V2} {
+		c, err := Open(root)
+		if err != nil {
+			t.Fatalf("%s: %v", root, err)
+		}
+		if c.Version() != want {
+			t.Fatalf("%s: version = %d, want %d", root, c.Version(), want)
+		}
+	}
+
+	if _, err := Open(t.TempDir()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("empty dir: err = %v, want ErrNotFound", err)
+	}
+}
+
+func Test_Memory(t *testing.T) {
+	tests := []struct {
+		root       string
+		want       MemoryStats
+		workingSet uint64
+		events     MemoryEvents
+	}{
+		{
+			root: "testdata/v1",
+			want: MemoryStats{
+				Limit: 512 * mb, Usage: 300 * mb,
+				Cache: 100 * mb, RSS: 200 * mb, InactiveFile: 70 * mb,
+			},
+			workingSet: 230 * mb,
+			events:     MemoryEvents{Max: 3, OOMKill: 1},
+		},
+		{
+			root: "testdata/v2",
+			want: MemoryStats{
+				Limit: 1024 * mb, Usage: 600 * mb,
+				Cache: 200 * mb, RSS: 400 * mb, InactiveFile: 150 * mb,
+			},
+			workingSet: 450 * mb,
+			events:     MemoryEvents{High: 7, Max: 2, OOM: 1, OOMKill: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := Open(tt.root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.Memory()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.root, err)
+		}
+		if len(got.Stat) == 0 {
+			t.Fatalf("%s: Stat is empty", tt.root)
+		}
+		got.Stat = nil
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: Memory() = %+v, want %+v", tt.root, got, tt.want)
+		}
+		if ws := got.WorkingSet(); ws != tt.workingSet {
+			t.Fatalf("%s: WorkingSet() = %d, want %d", tt.root, ws, tt.workingSet)
+		}
+
+		events, err := c.MemoryEvents()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.root, err)
+		}
+		if events != tt.events {
+			t.Fatalf("%s: MemoryEvents() = %+v, want %+v", tt.root, events, tt.events)
+		}
+	}
+}
+
+func Test_CPU(t *testing.T) {
+	tests := []struct {
+		root  string
+		want  CPUStats
+		limit float64
+	}{
+		{
+			root: "testdata/v1",
+			want: CPUStats{
+				Quota: 150 * time.Millisecond, Period: 100 * time.Millisecond,
+				Usage: 90 * time.Second, User: 60 * time.Second, System: 30 * time.Second,
+				Periods: 1200, ThrottledPeriods: 300, ThrottledTime: 45 * time.Second,
+			},
+			limit: 1.5,
+		},
+		{
+			root: "testdata/v2",
+			want: CPUStats{
+				Quota: 200 * time.Millisecond, Period: 100 * time.Millisecond,
+				Usage: 120 * time.Second, User: 80 * time.Second, System: 40 * time.Second,
+				Periods: 5000, ThrottledPeriods: 250, ThrottledTime: 30 * time.Second,
+			},
+			limit: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := Open(tt.root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.CPU()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.root, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: CPU() = %+v, want %+v", tt.root, got, tt.want)
+		}
+		if got.Limit() != tt.limit {
+			t.Fatalf("%s: Limit() = %v, want %v", tt.root, got.Limit(), tt.limit)
+		}
+	}
+}
+
+func Test_Pids(t *testing.T) {
+	for root, want := range map[string]PidsStats{
+		"testdata/v1": {Current: 12, Limit: 1024},
+		"testdata/v2": {Current: 7}, // pids.max是max
+	} {
+		c, err := Open(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.Pids()
+		if err != nil {
+			t.Fatalf("%s: %v", root, err)
+		}
+		if got != want {
+			t.Fatalf("%s: Pids() = %+v, want %+v", root, got, want)
+		}
+	}
+}
+
+// Test_Unlimited 没有限制时v1写-1或者一个很大的数, v2写max, 都读成0
+func Test_Unlimited(t *testing.T) {
+	v1 := t.TempDir()
+	writeFiles(t, v1, map[string]string{
+		"memory/memory.limit_in_bytes": "9223372036854771712\n",
+		"memory/memory.usage_in_bytes": "1024\n",
+		"memory/memory.stat":           "cache 0\nrss 1024\n",
+		"cpu/cpu.cfs_quota_us":         "-1\n",
+		"cpu/cpu.cfs_period_us":        "100000\n",
+		"cpu/cpu.stat":                 "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n",
+		"cpuacct/cpuacct.usage":        "0\n",
+		"cpuacct/cpuacct.stat":         "user 0\nsystem 0\n",
+	})
+	v2 := t.TempDir()
+	writeFiles(t, v2, map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"memory.max":         "max\n",
+		"memory.current":     "1024\n",
+		"memory.stat":        "anon 1024\nfile 0\n",
+		"cpu.max":            "max 100000\n",
+		"cpu.stat":           "usage_usec 0\n",
+	})
+
+	for _, root := range []string{v1, v2} {
+		c, err := Open(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := c.Memory()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Limit != 0 || m.Usage != 1024 {
+			t.Fatalf("v%d: Memory() = %+v, want unlimited", c.Version(), m)
+		}
+		s, err := c.CPU()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Quota != 0 || s.Limit() != 0 {
+			t.Fatalf("v%d: CPU() = %+v, want unlimited", c.Version(), s)
+		}
+	}
+}
+
+// Test_MissingController v1没有挂载pids时返回ErrNotFound
+func Test_MissingController(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"memory/memory.limit_in_bytes": "1024\n",
+	})
+	c, err := Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Pids(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Pids() err = %v, want ErrNotFound", err)
+	}
+}
+
+func Test_parseProcCgroup(t *testing.T) {
+	input := strings.Join([]string{
+		"12:pids:/docker/abc",
+		"4:cpu,cpuacct:/docker/abc",
+		"3:memory:/docker/abc",
+		"0::/system.slice/docker-abc.scope",
+	}, "\n")
+	got, err := parseProcCgroup(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"pids":    "/docker/abc",
+		"cpu":     "/docker/abc",
+		"cpuacct": "/docker/abc",
+		"memory":  "/docker/abc",
+		"":        "/system.slice/docker-abc.scope",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseProcCgroup() = %v, want %v", got, want)
+	}
+}
+
+// Test_open_Nested /proc/self/cgroup里的路径存在时进到子目录
+func Test_open_Nested(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"cgroup.controllers":     "cpu memory pids\n",
+		"app/cgroup.controllers": "cpu memory pids\n",
+		"app/pids.current":       "3\n",
+		"app/pids.max":           "100\n",
+	})
+	c, err := open(root, map[string]string{"": "/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Pids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != (PidsStats{Current: 3, Limit: 100}) {
+		t.Fatalf("Pids() = %+v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package cgroup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ cpuacct.stat的单位是USER_HZ, linux上基本都是100
+const userHZ = 100
+
+// CPUStats CPU的限制, 用量和被限流的情况
+type CPUStats struct {
+	// Quota 每个Period能用的CPU时间, 0表示没有限制
+	Quota  time.Duration
+	Period time.Duration
+	// Usage 累计用掉的CPU时间
+	Usage  time.Duration
+	User   time.Duration
+	System time.Duration
+	// Periods 经过了多少个Period, ThrottledPeriods是其中用完Quota被限流的个数
+	Periods          uint64
+	ThrottledPeriods uint64
+	// ThrottledTime 累计被限流的时间
+	ThrottledTime time.Duration
+}
+
+// Limit 能用几个核, 0表示没有限制
+func (s CPUStats) Limit() float64 {
+	if s.Quota <= 0 || s.Period <= 0 {
+		return 0
+	}
+	return float64(s.Quota) / float64(s.Period)
+}
+
+// CPU 读CPU的限制和用量
+func (c *Cgroup) CPU() (CPUStats, error) {
+	if c.version == V2 {
+		return c.cpuV2()
+	}
+	return c.cpuV1()
+}
+
+// cpuV2 cpu.max是 "quota period", cpu.stat里的时间都是微秒
+func (c *Cgroup) cpuV2() (CPUStats, error) {
+	var s CPUStats
+	max, err := c.readFile("cpu", "cpu.max")
+	if err != nil {
+		return s, err
+	}
+	fields := strings.Fields(max)
+	if len(fields) != 2 {
+		return s, fmt.Errorf("cgroup: bad cpu.max %q", max)
+	}
+	quota, err := parseLimit(fields[0])
+	if err != nil {
+		return s, err
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return s, fmt.Errorf("cgroup: bad cpu.max %q", max)
+	}
+	s.Quota = time.Duration(quota) * time.Microsecond
+	s.Period = time.Duration(period) * time.Microsecond
+
+	kv, err := c.readKV("cpu", "cpu.stat")
+	if err != nil {
+		return s, err
+	}
+	s.Usage = time.Duration(kv["usage_usec"]) * time.Microsecond
+	s.User = time.Duration(kv["user_usec"]) * time.Microsecond
+	s.System = time.Duration(kv["system_usec"]) * time.Microsecond
+	s.Periods = kv["nr_periods"]
+	s.ThrottledPeriods = kv["nr_throttled"]
+	s.ThrottledTime = time.Duration(kv["throttled_usec"]) * time.Microsecond
+	return s, nil
+}
+
+// cpuV1 限制和限流在cpu里, 用量在cpuacct里
+func (c *Cgroup) cpuV1() (CPUStats, error) {
+	var s CPUStats
+	quota, err := c.readUint("cpu", "cpu.cfs_quota_us")
+	if err != nil {
+		return s, err
+	}
+	period, err := c.readUint("cpu", "cpu.cfs_period_us")
+	if err != nil {
+		return s, err
+	}
+	s.Quota = time.Duration(quota) * time.Microsecond
+	s.Period = time.Duration(period) * time.Microsecond
+
+	kv, err := c.readKV("cpu", "cpu.stat")
+	if err != nil {
+		return s, err
+	}
+	s.Periods = kv["nr_periods"]
+	s.ThrottledPeriods = kv["nr_throttled"]
+	s.ThrottledTime = time.Duration(kv["throttled_time"]) // 纳秒
+
+	usage, err := c.readUint("cpuacct", "cpuacct.usage")
+	if err != nil {
+		return s, err
+	}
+	s.Usage = time.Duration(usage)
+
+	acct, err := c.readKV("cpuacct", "cpuacct.stat")
+	if err != nil {
+		return s, err
+	}
+	s.User = time.Duration(acct["user"]) * time.Second / userHZ
+	s.System = time.Duration(acct["system"]) * time.Second / userHZ
+	return s, nil
+}
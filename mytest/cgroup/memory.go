@@ -0,0 +1,102 @@
+package cgroup
+
+// MemoryStats 内存的限制和用量, 单位是字节
+type MemoryStats struct {
+	// Limit 0表示没有限制
+	Limit uint64
+	// Usage 包括page cache
+	Usage uint64
+	// Cache page cache, v1是memory.stat的cache, v2是file
+	Cache uint64
+	// RSS 匿名内存, v1是rss, v2是anon
+	RSS uint64
+	// InactiveFile 可以回收的page cache
+	InactiveFile uint64
+	// Stat memory.stat的原始内容
+	Stat map[string]uint64
+}
+
+// WorkingSet 和kubelet一样, Usage减去可以回收的page cache, 接近OOM时看这个
+func (m MemoryStats) WorkingSet() uint64 {
+	if m.InactiveFile > m.Usage {
+		return 0
+	}
+	return m.Usage - m.InactiveFile
+}
+
+// MemoryEvents v2 memory.events里的计数, v1只有OOMKill(来自memory.oom_control)
+type MemoryEvents struct {
+	Low     uint64
+	High    uint64 // 超过memory.high被限流的次数
+	Max     uint64 // 碰到memory.max的次数
+	OOM     uint64
+	OOMKill uint64
+}
+
+// Memory 读内存的限制和用量
+func (c *Cgroup) Memory() (MemoryStats, error) {
+	var m MemoryStats
+	var err error
+	if c.version == V2 {
+		if m.Limit, err = c.readUint("memory", "memory.max"); err != nil {
+			return m, err
+		}
+		if m.Usage, err = c.readUint("memory", "memory.current"); err != nil {
+			return m, err
+		}
+		if m.Stat, err = c.readKV("memory", "memory.stat"); err != nil {
+			return m, err
+		}
+		m.Cache, m.RSS = m.Stat["file"], m.Stat["anon"]
+		m.InactiveFile = m.Stat["inactive_file"]
+		return m, nil
+	}
+
+	if m.Limit, err = c.readUint("memory", "memory.limit_in_bytes"); err != nil {
+		return m, err
+	}
+	if m.Usage, err = c.readUint("memory", "memory.usage_in_bytes"); err != nil {
+		return m, err
+	}
+	if m.Stat, err = c.readKV("memory", "memory.stat"); err != nil {
+		return m, err
+	}
+	// 有子cgroup时total_xxx才包括子cgroup的用量
+	m.Cache = pick(m.Stat, "total_cache", "cache")
+	m.RSS = pick(m.Stat, "total_rss", "rss")
+	m.InactiveFile = pick(m.Stat, "total_inactive_file", "inactive_file")
+	return m, nil
+}
+
+// MemoryEvents 读内存事件计数
+func (c *Cgroup) MemoryEvents() (MemoryEvents, error) {
+	if c.version == V2 {
+		kv, err := c.readKV("memory", "memory.events")
+		if err != nil {
+			return MemoryEvents{}, err
+		}
+		return MemoryEvents{
+			Low: kv["low"], High: kv["high"], Max: kv["max"],
+			OOM: kv["oom"], OOMKill: kv["oom_kill"],
+		}, nil
+	}
+
+	// memory.oom_control的格式也是 key value, 老内核没有oom_kill这一行
+	kv, err := c.readKV("memory", "memory.oom_control")
+	if err != nil {
+		return MemoryEvents{}, err
+	}
+	events := MemoryEvents{OOMKill: kv["oom_kill"]}
+	// failcnt是碰到limit_in_bytes的次数, 对应v2的max, 读不到就算了
+	events.Max, _ = c.readUint("memory", "memory.failcnt")
+	return events, nil
+}
+
+func pick(kv map[string]uint64, keys ...string) uint64 {
+	for _, k := range keys {
+		if v, ok := kv[k]; ok {
+			return v
+		}
+	}
+	return 0
+}
@@ -0,0 +1,21 @@
+package cgroup
+
+// PidsStats 进程数的限制和当前值
+type PidsStats struct {
+	Current uint64
+	// Limit 0表示没有限制
+	Limit uint64
+}
+
+// Pids 读进程数, v1和v2的文件名一样
+func (c *Cgroup) Pids() (PidsStats, error) {
+	var s PidsStats
+	var err error
+	if s.Current, err = c.readUint("pids", "pids.current"); err != nil {
+		return s, err
+	}
+	if s.Limit, err = c.readUint("pids", "pids.max"); err != nil {
+		return s, err
+	}
+	return s, nil
+}
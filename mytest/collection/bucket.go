@@ -0,0 +1,145 @@
+package collection
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Bucket 求和和计数
+type Bucket[T Numerical] struct {
+	Sum   T
+	Count int64
+}
+
+func (b *Bucket[T]) Add(v T) {
+	b.Sum += v
+	b.Count++
+}
+
+func (b *Bucket[T]) Reset() {
+	b.Sum = 0
+	b.Count = 0
+}
+
+func (b *Bucket[T]) Merge(other *Bucket[T]) {
+	b.Sum += other.Sum
+	b.Count += other.Count
+}
+
+// MinMaxBucket 最小值和最大值, Count为0时Min和Max没有意义
+type MinMaxBucket[T Numerical] struct {
+	Min   T
+	Max   T
+	Count int64
+}
+
+func (b *MinMaxBucket[T]) Add(v T) {
+	if b.Count == 0 || v < b.Min {
+		b.Min = v
+	}
+	if b.Count == 0 || v > b.Max {
+		b.Max = v
+	}
+	b.Count++
+}
+
+func (b *MinMaxBucket[T]) Reset() {
+	*b = MinMaxBucket[T]{}
+}
+
+func (b *MinMaxBucket[T]) Merge(other *MinMaxBucket[T]) {
+	if other.Count == 0 {
+		return
+	}
+	if b.Count == 0 || other.Min < b.Min {
+		b.Min = other.Min
+	}
+	if b.Count == 0 || other.Max > b.Max {
+		b.Max = other.Max
+	}
+	b.Count += other.Count
+}
+
+const (
+	subBucketBits = 2 // 每个2的幂区间再分成4份, 误差不超过25%
+	subBuckets    = 1 << subBucketBits
+	histBuckets   = (64 - subBucketBits + 1) * subBuckets
+)
+
+// HistogramBucket 对数刻度的直方图, 用来算延迟的分位数
+// 小于0的值算作0, 小数部分会被截掉, 延迟一般用微秒或者time.Duration
+type HistogramBucket[T Numerical] struct {
+	Counts [histBuckets]int64
+	Count  int64
+	Sum    T
+}
+
+// histIndex v落在哪个格子里, [0, subBuckets)原样放, 之后每个2的幂区间分subBuckets份
+func histIndex(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1 - subBucketBits
+	return (exp+1)*subBuckets + int(v>>exp) - subBuckets
+}
+
+// histUpper 格子i里最大的值
+func histUpper(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	exp := i/subBuckets - 1
+	mantissa := uint64(i%subBuckets + subBuckets)
+	return (mantissa+1)<<exp - 1
+}
+
+func (b *HistogramBucket[T]) Add(v T) {
+	var u uint64
+	if v > 0 {
+		u = uint64(v)
+	}
+	b.Counts[histIndex(u)]++
+	b.Count++
+	b.Sum += v
+}
+
+func (b *HistogramBucket[T]) Reset() {
+	*b = HistogramBucket[T]{}
+}
+
+func (b *HistogramBucket[T]) Merge(other *HistogramBucket[T]) {
+	if other.Count == 0 {
+		return
+	}
+	for i, c := range other.Counts {
+		b.Counts[i] += c
+	}
+	b.Count += other.Count
+	b.Sum += other.Sum
+}
+
+// Percentile p在[0, 100]之间, 返回所在格子的上界, 没有数据时返回0
+func (b *HistogramBucket[T]) Percentile(p float64) T {
+	if b.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(b.Count)))
+	rank = min(max(rank, 1), b.Count)
+
+	var seen int64
+	for i, c := range b.Counts {
+		seen += c
+		if seen >= rank {
+			return T(histUpper(i))
+		}
+	}
+	return T(histUpper(histBuckets - 1))
+}
+
+// Mean 平均值
+func (b *HistogramBucket[T]) Mean() float64 {
+	if b.Count == 0 {
+		return 0
+	}
+	return float64(b.Sum) / float64(b.Count)
+}
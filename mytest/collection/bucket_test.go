@@ -0,0 +1,90 @@
+package collection
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+func Test_MinMaxBucket(t *testing.T) {
+	var a, b MinMaxBucket[int]
+	for _, v := range []int{5, -3, 9} {
+		a.Add(v)
+	}
+	b.Add(12)
+	a.Merge(&b)
+	a.Merge(&MinMaxBucket[int]{})
+	if a.Min != -3 || a.Max != 12 || a.Count != 4 {
+		t.Fatalf("bucket %+v", a)
+	}
+	a.Reset()
+	a.Add(7)
+	if a.Min != 7 || a.Max != 7 {
+		t.Fatalf("bucket %+v after reset", a)
+	}
+}
+
+func Test_HistIndex(t *testing.T) {
+	// 每个值都落在上界不小于它的格子里, 格子是连续的
+	prev := -1
+	for _, v := range []uint64{0, 1, 3, 4, 5, 7, 8, 9, 10, 100, 1 << 20, 1<<20 + 1, math.MaxUint64} {
+		i := histIndex(v)
+		if i < prev || i >= histBuckets || histUpper(i) < v || (i > 0 && histUpper(i-1) >= v) {
+			t.Fatalf("value %d in bucket %d, upper %d", v, i, histUpper(i))
+		}
+		prev = i
+	}
+}
+
+func Test_HistogramBucket(t *testing.T) {
+	var h HistogramBucket[time.Duration]
+	if h.Percentile(99) != 0 {
+		t.Fatal("empty histogram")
+	}
+
+	values := make([]time.Duration, 10000)
+	for i := range values {
+		values[i] = time.Duration(rand.N(100*time.Millisecond)) + time.Millisecond
+		h.Add(values[i])
+	}
+	slices.Sort(values)
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		want := values[int(math.Ceil(p/100*float64(len(values))))-1]
+		got := h.Percentile(p)
+		// 格子的宽度是下界的1/4
+		if got < want || float64(got) > float64(want)*1.25 {
+			t.Fatalf("p%v: got %v, want about %v", p, got, want)
+		}
+	}
+}
+
+// 分片的直方图按桶合并后再算分位数
+func Test_HistogramBucket_Window(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	newHist := func() *HistogramBucket[int64] { return new(HistogramBucket[int64]) }
+	r := NewShardedRollingWindow[int64, *HistogramBucket[int64]](newHist, 10, time.Second, 4,
+		WithClock[int64, *HistogramBucket[int64]](clk))
+
+	for i := int64(1); i <= 100; i++ {
+		r.Add(i)
+		if i%10 == 0 {
+			clk.Advance(time.Second)
+		}
+	}
+
+	var all HistogramBucket[int64]
+	r.Reduce(func(b *HistogramBucket[int64]) {
+		all.Merge(b)
+	})
+	// 最早的一秒(1~10)已经滑出窗口
+	if all.Count != 90 || all.Mean() != 55.5 {
+		t.Fatalf("count %d, mean %v", all.Count, all.Mean())
+	}
+	if p := all.Percentile(100); p != 111 { // 100落在[96, 111]
+		t.Fatalf("p100 %d", p)
+	}
+}
@@ -0,0 +1,171 @@
+package collection
+
+import (
+	"sync"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/go-zero/collection/rollingwindow.md
+
+type (
+	// Numerical 桶里能放的数值类型
+	Numerical interface {
+		~int | ~int8 | ~int16 | ~int32 | ~int64 |
+			~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+			~float32 | ~float64
+	}
+
+	// BucketInterface 桶, 统计一个时间片内的数据
+	BucketInterface[T Numerical] interface {
+		Add(v T)
+		Reset()
+	}
+
+	// RollingWindowOption 定制RollingWindow
+	RollingWindowOption[T Numerical, B BucketInterface[T]] func(rollingWindow *RollingWindow[T, B])
+
+	// RollingWindow 滑动窗口, size个桶, 每个桶interval长
+	// 所有操作都在一把锁里, 高并发Add用ShardedRollingWindow
+	RollingWindow[T Numerical, B BucketInterface[T]] struct {
+		lock          sync.RWMutex
+		size          int
+		win           *window[T, B]
+		interval      time.Duration
+		offset        int
+		ignoreCurrent bool
+		clock         second.Clock
+		lastTime      time.Time // 当前桶的开始时间
+	}
+)
+
+// NewRollingWindow 创建滑动窗口
+func NewRollingWindow[T Numerical, B BucketInterface[T]](newBucket func() B, size int,
+	interval time.Duration, opts ...RollingWindowOption[T, B]) *RollingWindow[T, B] {
+	w := new(RollingWindow[T, B])
+	w.init(newBucket, size, interval, opts)
+	return w
+}
+
+func (rw *RollingWindow[T, B]) init(newBucket func() B, size int, interval time.Duration,
+	opts []RollingWindowOption[T, B]) {
+	if size < 1 {
+		panic("size must be greater than 0")
+	}
+
+	rw.size = size
+	rw.win = newWindow[T, B](newBucket, size)
+	rw.interval = interval
+	rw.clock = second.RealClock
+	for _, opt := range opts {
+		opt(rw)
+	}
+	rw.lastTime = rw.clock.Now()
+}
+
+// Add 把v加到当前桶
+func (rw *RollingWindow[T, B]) Add(v T) {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	rw.updateOffset(rw.clock.Now())
+	rw.win.add(rw.offset, v)
+}
+
+// Reduce 按时间从旧到新遍历没过期的桶, 设置了IgnoreCurrentBucket时跳过当前桶
+func (rw *RollingWindow[T, B]) Reduce(fn func(b B)) {
+	rw.reduceAt(rw.clock.Now(), func(_ int, b B) {
+		fn(b)
+	})
+}
+
+// reduceAt 和Reduce一样, i是桶在窗口里的位置, 0是最旧的那个
+// 同一个时间点不同窗口的i是对齐的, ShardedRollingWindow靠它合并
+func (rw *RollingWindow[T, B]) reduceAt(now time.Time, fn func(i int, b B)) {
+	rw.lock.RLock()
+	defer rw.lock.RUnlock()
+
+	var diff int
+	span := rw.span(now)
+	// 当前桶的数据还不完整
+	if span == 0 && rw.ignoreCurrent {
+		diff = rw.size - 1
+	} else {
+		diff = rw.size - span
+	}
+	if diff > 0 {
+		offset := (rw.offset + span + 1) % rw.size
+		rw.win.reduce(offset, diff, fn)
+	}
+}
+
+// span 从当前桶开始过去了几个桶
+func (rw *RollingWindow[T, B]) span(now time.Time) int {
+	offset := int(now.Sub(rw.lastTime) / rw.interval)
+	if 0 <= offset && offset < rw.size {
+		return offset
+	}
+
+	return rw.size
+}
+
+// updateOffset 清空过期的桶, 移动到now所在的桶
+func (rw *RollingWindow[T, B]) updateOffset(now time.Time) {
+	span := rw.span(now)
+	if span <= 0 {
+		return
+	}
+
+	offset := rw.offset
+	for i := 0; i < span; i++ {
+		rw.win.resetBucket((offset + i + 1) % rw.size)
+	}
+
+	rw.offset = (offset + span) % rw.size
+	// 对齐到桶的边界
+	rw.lastTime = now.Add(-(now.Sub(rw.lastTime) % rw.interval))
+}
+
+type window[T Numerical, B BucketInterface[T]] struct {
+	buckets []B
+	size    int
+}
+
+func newWindow[T Numerical, B BucketInterface[T]](newBucket func() B, size int) *window[T, B] {
+	buckets := make([]B, size)
+	for i := 0; i < size; i++ {
+		buckets[i] = newBucket()
+	}
+	return &window[T, B]{
+		buckets: buckets,
+		size:    size,
+	}
+}
+
+func (w *window[T, B]) add(offset int, v T) {
+	w.buckets[offset%w.size].Add(v)
+}
+
+func (w *window[T, B]) reduce(start, count int, fn func(i int, b B)) {
+	for i := 0; i < count; i++ {
+		fn(i, w.buckets[(start+i)%w.size])
+	}
+}
+
+func (w *window[T, B]) resetBucket(offset int) {
+	w.buckets[offset%w.size].Reset()
+}
+
+// IgnoreCurrentBucket Reduce时跳过当前桶
+func IgnoreCurrentBucket[T Numerical, B BucketInterface[T]]() RollingWindowOption[T, B] {
+	return func(w *RollingWindow[T, B]) {
+		w.ignoreCurrent = true
+	}
+}
+
+// WithClock 指定时钟, 测试时用second.FakeClock
+func WithClock[T Numerical, B BucketInterface[T]](c second.Clock) RollingWindowOption[T, B] {
+	return func(w *RollingWindow[T, B]) {
+		w.clock = c
+	}
+}
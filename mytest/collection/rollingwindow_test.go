@@ -0,0 +1,102 @@
+package collection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const duration = time.Millisecond * 50
+
+func sums(reduce func(fn func(b *Bucket[float64]))) []float64 {
+	var got []float64
+	reduce(func(b *Bucket[float64]) {
+		got = append(got, b.Sum)
+	})
+	return got
+}
+
+func equal(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newBucket() *Bucket[float64] {
+	return new(Bucket[float64])
+}
+
+func Test_RollingWindow_Add(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	r := NewRollingWindow[float64, *Bucket[float64]](newBucket, 3, duration,
+		WithClock[float64, *Bucket[float64]](clk))
+
+	steps := []struct {
+		adds []float64
+		want []float64
+	}{
+		{[]float64{1}, []float64{0, 0, 1}},
+		{[]float64{2, 3}, []float64{0, 1, 5}},
+		{[]float64{4, 5, 6}, []float64{1, 5, 15}},
+		{[]float64{7}, []float64{5, 15, 7}},
+	}
+	for i, s := range steps {
+		if i > 0 {
+			clk.Advance(duration)
+		}
+		for _, v := range s.adds {
+			r.Add(v)
+		}
+		if got := sums(r.Reduce); !equal(got, s.want) {
+			t.Fatalf("step %d: got %v, want %v", i, got, s.want)
+		}
+	}
+
+	// 整个窗口都过期了
+	clk.Advance(3 * duration)
+	if got := sums(r.Reduce); len(got) != 0 {
+		t.Fatalf("expired window %v", got)
+	}
+}
+
+func Test_RollingWindow_IgnoreCurrent(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	r := NewRollingWindow[float64, *Bucket[float64]](newBucket, 4, duration,
+		WithClock[float64, *Bucket[float64]](clk), IgnoreCurrentBucket[float64, *Bucket[float64]]())
+
+	r.Add(1)
+	clk.Advance(duration)
+	r.Add(2)
+	if got := sums(r.Reduce); !equal(got, []float64{0, 0, 1}) {
+		t.Fatalf("got %v", got)
+	}
+	// 当前桶还没写, 上一个桶就是完整的
+	clk.Advance(duration)
+	if got := sums(r.Reduce); !equal(got, []float64{0, 1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// 不在桶的边界上Add, lastTime也要对齐
+func Test_RollingWindow_Align(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	r := NewRollingWindow[float64, *Bucket[float64]](newBucket, 3, duration,
+		WithClock[float64, *Bucket[float64]](clk))
+
+	clk.Advance(duration + duration/2)
+	r.Add(1)
+	clk.Advance(duration / 2)
+	r.Add(2)
+	if got := sums(r.Reduce); !equal(got, []float64{0, 1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+}
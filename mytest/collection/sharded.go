@@ -0,0 +1,86 @@
+package collection
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// MergeableBucket 可以合并的桶, 分片之后Reduce要把各个分片的同一个桶合起来
+type MergeableBucket[T Numerical, B any] interface {
+	BucketInterface[T]
+	Merge(other B)
+}
+
+// ShardedRollingWindow 分片的滑动窗口, Add随机选一个分片, 分片之间不抢锁
+// Reduce要锁住所有分片再合并, 适合写多读少, 比如熔断和降载的统计
+type ShardedRollingWindow[T Numerical, B MergeableBucket[T, B]] struct {
+	shards []*shard[T, B]
+	clock  second.Clock
+
+	reduceLock sync.Mutex
+	merged     []B // Reduce时合并用的桶, 复用避免分配
+}
+
+// shard 补齐到独占缓存行, 避免相邻分片的锁互相影响
+type shard[T Numerical, B BucketInterface[T]] struct {
+	RollingWindow[T, B]
+	_ [64]byte
+}
+
+// NewShardedRollingWindow 创建分片的滑动窗口, shards<=0时用GOMAXPROCS个分片
+// opts对每个分片都生效
+func NewShardedRollingWindow[T Numerical, B MergeableBucket[T, B]](newBucket func() B, size int,
+	interval time.Duration, shards int, opts ...RollingWindowOption[T, B]) *ShardedRollingWindow[T, B] {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+
+	sw := &ShardedRollingWindow[T, B]{
+		shards: make([]*shard[T, B], shards),
+		merged: make([]B, size),
+	}
+	for i := range sw.shards {
+		sw.shards[i] = new(shard[T, B])
+		sw.shards[i].init(newBucket, size, interval, opts)
+	}
+	for i := range sw.merged {
+		sw.merged[i] = newBucket()
+	}
+	// 所有分片的起始时间要一样, 桶才能对齐
+	sw.clock = sw.shards[0].clock
+	start := sw.shards[0].lastTime
+	for _, s := range sw.shards {
+		s.lastTime = start
+	}
+	return sw
+}
+
+// Add 把v加到随机一个分片的当前桶
+func (sw *ShardedRollingWindow[T, B]) Add(v T) {
+	sw.shards[rand.N(len(sw.shards))].Add(v)
+}
+
+// Reduce 合并所有分片后按时间从旧到新遍历, fn拿到的桶在Reduce返回后会被复用
+func (sw *ShardedRollingWindow[T, B]) Reduce(fn func(b B)) {
+	sw.reduceLock.Lock()
+	defer sw.reduceLock.Unlock()
+
+	for _, b := range sw.merged {
+		b.Reset()
+	}
+	n := 0
+	now := sw.clock.Now()
+	for _, s := range sw.shards {
+		s.reduceAt(now, func(i int, b B) {
+			sw.merged[i].Merge(b)
+			n = max(n, i+1)
+		})
+	}
+	for _, b := range sw.merged[:n] {
+		fn(b)
+	}
+}
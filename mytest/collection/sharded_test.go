@@ -0,0 +1,94 @@
+package collection
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 分片的结果要和不分片的一样
+func Test_ShardedRollingWindow(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	opt := WithClock[float64, *Bucket[float64]](clk)
+	plain := NewRollingWindow[float64, *Bucket[float64]](newBucket, 5, duration, opt)
+	sharded := NewShardedRollingWindow[float64, *Bucket[float64]](newBucket, 5, duration, 4, opt)
+
+	for step := 0; step < 12; step++ {
+		for i := 0; i <= step%4; i++ {
+			v := float64(step*10 + i)
+			plain.Add(v)
+			sharded.Add(v)
+		}
+		if want, got := sums(plain.Reduce), sums(sharded.Reduce); !equal(want, got) {
+			t.Fatalf("step %d: sharded %v, want %v", step, got, want)
+		}
+		// 有时跳过几个桶
+		clk.Advance(time.Duration(1+step%3) * duration)
+	}
+}
+
+func Test_ShardedRollingWindow_Concurrent(t *testing.T) {
+	sharded := NewShardedRollingWindow[int64, *Bucket[int64]](func() *Bucket[int64] {
+		return new(Bucket[int64])
+	}, 10, time.Hour, 0)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				sharded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var count int64
+	sharded.Reduce(func(b *Bucket[int64]) {
+		count += b.Count
+	})
+	if count != 8000 {
+		t.Fatalf("count %d", count)
+	}
+}
+
+func benchmarkAdd(b *testing.B, add func(v int64)) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			add(1)
+		}
+	})
+}
+
+func newInt64Bucket() *Bucket[int64] {
+	return new(Bucket[int64])
+}
+
+func Benchmark_RollingWindow_Add(b *testing.B) {
+	r := NewRollingWindow[int64, *Bucket[int64]](newInt64Bucket, 40, 250*time.Millisecond)
+	benchmarkAdd(b, r.Add)
+}
+
+func Benchmark_ShardedRollingWindow_Add(b *testing.B) {
+	r := NewShardedRollingWindow[int64, *Bucket[int64]](newInt64Bucket, 40, 250*time.Millisecond, 0)
+	benchmarkAdd(b, r.Add)
+}
+
+func Benchmark_RollingWindow_Reduce(b *testing.B) {
+	r := NewRollingWindow[int64, *Bucket[int64]](newInt64Bucket, 40, 250*time.Millisecond)
+	r.Add(1)
+	for i := 0; i < b.N; i++ {
+		r.Reduce(func(b *Bucket[int64]) {})
+	}
+}
+
+func Benchmark_ShardedRollingWindow_Reduce(b *testing.B) {
+	r := NewShardedRollingWindow[int64, *Bucket[int64]](newInt64Bucket, 40, 250*time.Millisecond, 0)
+	r.Add(1)
+	for i := 0; i < b.N; i++ {
+		r.Reduce(func(b *Bucket[int64]) {})
+	}
+}
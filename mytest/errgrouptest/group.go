@@ -0,0 +1,191 @@
+package errgrouptest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Result 是一个任务的执行结果, Index是调用Go时的顺序
+type Result[T any] struct {
+	Index int
+	Value T
+	Err   error
+}
+
+// Handle 对应一次Go调用, 可以单独等待这个任务的结果
+type Handle[T any] struct {
+	index int
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Index 返回任务的提交顺序, 从0开始
+func (h *Handle[T]) Index() int { return h.index }
+
+// Done 任务结束后关闭
+func (h *Handle[T]) Done() <-chan struct{} { return h.done }
+
+// Wait 阻塞到任务结束, 返回任务的值和错误
+func (h *Handle[T]) Wait() (T, error) {
+	<-h.done
+	return h.value, h.err
+}
+
+func (h *Handle[T]) result() Result[T] {
+	return Result[T]{Index: h.index, Value: h.value, Err: h.err}
+}
+
+// Group 是带返回值的errgroup
+// 每个任务返回(T, error), Wait按提交顺序返回结果, Stream按完成顺序返回结果
+// 和SafeGroup一样, 任务里的panic会转成*PanicError
+// 零值可用, 和errgroup.Group一样出错时没有ctx可以取消, 需要取消用NewGroup
+type Group[T any] struct {
+	initOnce sync.Once
+	ctx      context.Context
+	cancel   func(error)
+
+	wg  sync.WaitGroup
+	sem chan token
+
+	timeout time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	handles  []*Handle[T]
+	finished []*Handle[T]
+
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroup 返回Group和派生出来的ctx
+// 第一个任务出错或者Wait返回时, ctx被取消
+func NewGroup[T any](ctx context.Context) (*Group[T], context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	g := &Group[T]{ctx: ctx, cancel: cancel}
+	g.init()
+	return g, ctx
+}
+
+// init 补上零值里没有的字段
+func (g *Group[T]) init() {
+	g.initOnce.Do(func() {
+		if g.ctx == nil {
+			g.ctx, g.cancel = context.Background(), func(error) {}
+		}
+		g.cond = sync.NewCond(&g.mu)
+	})
+}
+
+// SetLimit 限制同时运行的任务数, 负数表示不限制
+func (g *Group[T]) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic("errgroup: modify limit while goroutines in the group are still active")
+	}
+	g.sem = make(chan token, n)
+}
+
+// SetTimeout 设置每个任务的超时时间, 从任务开始运行时计时, 0表示不超时
+func (g *Group[T]) SetTimeout(d time.Duration) {
+	g.timeout = d
+}
+
+// Go 在新的go程里运行f, 达到并发上限时会阻塞
+// f收到的ctx在组被取消或者单个任务超时时取消
+func (g *Group[T]) Go(f func(ctx context.Context) (T, error)) *Handle[T] {
+	g.init()
+	if g.sem != nil {
+		g.sem <- token{}
+	}
+
+	g.mu.Lock()
+	h := &Handle[T]{index: len(g.handles), done: make(chan struct{})}
+	g.handles = append(g.handles, h)
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go g.run(h, f)
+	return h
+}
+
+func (g *Group[T]) run(h *Handle[T], f func(ctx context.Context) (T, error)) {
+	defer func() {
+		if g.sem != nil {
+			<-g.sem
+		}
+		g.wg.Done()
+	}()
+
+	ctx, cancel := g.ctx, context.CancelFunc(func() {})
+	if g.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+	}
+
+	h.err = safeCall(func() (err error) {
+		h.value, err = f(ctx)
+		return err
+	})
+	cancel()
+
+	if h.err != nil {
+		g.errOnce.Do(func() {
+			g.err = h.err
+			g.cancel(g.err)
+		})
+	}
+
+	close(h.done)
+	g.mu.Lock()
+	g.finished = append(g.finished, h)
+	g.cond.Broadcast()
+	g.mu.Unlock()
+}
+
+// Wait 等待所有任务结束, 按提交顺序返回结果和第一个错误
+// 出错任务的位置上是T的零值
+func (g *Group[T]) Wait() ([]T, error) {
+	g.init()
+	g.wg.Wait()
+	g.cancel(g.err)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	values := make([]T, len(g.handles))
+	for i, h := range g.handles {
+		if h.err == nil {
+			values[i] = h.value
+		}
+	}
+	return values, g.err
+}
+
+// Stream 按完成顺序返回结果, 所有任务结束后关闭chan
+// 和Wait一样, 要在所有Go调用之后调用, 调用方需要把chan读完
+func (g *Group[T]) Stream() <-chan Result[T] {
+	g.init()
+	out := make(chan Result[T])
+	go func() {
+		defer close(out)
+		for i := 0; ; i++ {
+			g.mu.Lock()
+			for i >= len(g.finished) && i < len(g.handles) {
+				g.cond.Wait()
+			}
+			if i >= len(g.handles) {
+				g.mu.Unlock()
+				return
+			}
+			h := g.finished[i]
+			g.mu.Unlock()
+
+			out <- h.result()
+		}
+	}()
+	return out
+}
@@ -0,0 +1,139 @@
+package errgrouptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test_Limit的带返回值版本, 不用再手写共享的slice
+func Test_Group_Order(t *testing.T) {
+	g, _ := NewGroup[string](context.TODO())
+	g.SetLimit(3)
+
+	var running, maxRunning int32
+	urls := []string{"url1", "url2", "url3", "url4", "url5", "url6"}
+	for i, u := range urls {
+		u := u
+		delay := time.Duration(len(urls)-i) * 5 * time.Millisecond
+		g.Go(func(ctx context.Context) (string, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(delay)
+			atomic.AddInt32(&running, -1)
+			return "body of " + u, nil
+		})
+	}
+
+	values, err := g.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, u := range urls {
+		if values[i] != "body of "+u {
+			t.Fatalf("values[%d] = %q", i, values[i])
+		}
+	}
+	if maxRunning > 3 {
+		t.Fatalf("limit exceeded: %d", maxRunning)
+	}
+}
+
+func Test_Group_Stream(t *testing.T) {
+	g, _ := NewGroup[int](context.TODO())
+
+	delays := []time.Duration{50, 10, 30}
+	for i, d := range delays {
+		i, d := i, d
+		g.Go(func(ctx context.Context) (int, error) {
+			time.Sleep(d * time.Millisecond)
+			return i, nil
+		})
+	}
+
+	var got []int
+	for r := range g.Stream() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if r.Value != r.Index {
+			t.Fatalf("value %d at index %d", r.Value, r.Index)
+		}
+		got = append(got, r.Index)
+	}
+	if fmt.Sprint(got) != "[1 2 0]" {
+		t.Fatalf("completion order: %v", got)
+	}
+}
+
+func Test_Group_Timeout(t *testing.T) {
+	g, ctx := NewGroup[int](context.TODO())
+	g.SetTimeout(10 * time.Millisecond)
+
+	slow := g.Go(func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+
+	if _, err := slow.Wait(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	if _, err := g.Wait(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("group ctx should be canceled")
+	}
+}
+
+func Test_Group_Panic(t *testing.T) {
+	g, _ := NewGroup[int](context.TODO())
+	g.Go(func(ctx context.Context) (int, error) { return 1, nil })
+	g.Go(func(ctx context.Context) (int, error) { panic("boom") })
+
+	values, err := g.Wait()
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("want *PanicError, got %v", err)
+	}
+	if values[0] != 1 || values[1] != 0 {
+		t.Fatalf("values: %v", values)
+	}
+}
+
+// Test_Group_ZeroValue 零值和SafeGroup一样可以直接用
+func Test_Group_ZeroValue(t *testing.T) {
+	var g Group[int]
+	for i := 0; i < 3; i++ {
+		i := i
+		g.Go(func(ctx context.Context) (int, error) {
+			if i == 1 {
+				return 0, errors.New("boom")
+			}
+			return i * 10, nil
+		})
+	}
+
+	n := 0
+	for range g.Stream() {
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("stream got %d results, want 3", n)
+	}
+	values, err := g.Wait()
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	if values[0] != 0 || values[1] != 0 || values[2] != 20 {
+		t.Fatalf("values = %v", values)
+	}
+}
@@ -0,0 +1,169 @@
+package errgrouptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/guonaihong/question/mytest/adaptive"
+)
+
+// PanicError 记录go程里panic的值和当时的调用栈
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("errgroup: recovered panic: %v\n%s", p.Value, p.Stack)
+}
+
+// Unwrap 如果panic的值本身是error, 可以用errors.Is/As判断
+func (p *PanicError) Unwrap() error {
+	if err, ok := p.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+type token struct{}
+
+// SafeGroup 和errgroup.Group用法一样, 区别是go程里的panic会被recover
+// 转成*PanicError, 和返回错误一样会取消WithContext派生出来的ctx
+//
+// 零值可用, 没有并发限制, 出错也不取消
+type SafeGroup struct {
+	cancel func(error)
+
+	wg sync.WaitGroup
+
+	sem     chan token
+	limiter *adaptive.Limiter
+
+	errOnce sync.Once
+	err     error
+}
+
+// SafeWithContext 返回SafeGroup和派生出来的ctx
+// 第一个出错(包括panic)的go程或者Wait返回时, ctx被取消
+func SafeWithContext(ctx context.Context) (*SafeGroup, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &SafeGroup{cancel: cancel}, ctx
+}
+
+func (g *SafeGroup) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
+
+// Wait 等待所有go程结束, 返回第一个错误
+func (g *SafeGroup) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel(g.err)
+	}
+	return g.err
+}
+
+// Go 在新的go程里运行f, 达到并发上限时会阻塞
+func (g *SafeGroup) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- token{}
+	}
+
+	var tok *adaptive.Token
+	if g.limiter != nil {
+		// 和sem一样, 没有名额就一直等
+		tok, _ = g.limiter.Acquire(context.Background())
+	}
+
+	g.wg.Add(1)
+	go g.run(f, tok)
+}
+
+// TryGo 只有在没有达到并发上限时才启动go程, 返回值表示是否启动
+func (g *SafeGroup) TryGo(f func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- token{}:
+		default:
+			return false
+		}
+	}
+
+	var tok *adaptive.Token
+	if g.limiter != nil {
+		var ok bool
+		if tok, ok = g.limiter.TryAcquire(); !ok {
+			if g.sem != nil {
+				<-g.sem
+			}
+			return false
+		}
+	}
+
+	g.wg.Add(1)
+	go g.run(f, tok)
+	return true
+}
+
+// SetLimit 限制同时运行的go程数, 负数表示不限制
+// 和errgroup一样, 有go程在运行时不能修改
+func (g *SafeGroup) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("errgroup: modify limit while %v goroutines in the group are still active", len(g.sem)))
+	}
+	g.sem = make(chan token, n)
+}
+
+// SetLimiter 用自适应限流器控制并发数, 上限会跟着后端的健康状况变化
+// 任务返回nil算成功, 返回ctx取消的错误不计入, 其它错误和panic算失败
+// 和SetLimit一样, 有go程在运行时不能修改
+func (g *SafeGroup) SetLimiter(l *adaptive.Limiter) {
+	g.limiter = l
+}
+
+func (g *SafeGroup) run(f func() error, tok *adaptive.Token) {
+	defer g.done()
+
+	err := safeCall(f)
+	if tok != nil {
+		tok.Release(outcome(err))
+	}
+	if err != nil {
+		g.errOnce.Do(func() {
+			g.err = err
+			if g.cancel != nil {
+				g.cancel(g.err)
+			}
+		})
+	}
+}
+
+func outcome(err error) adaptive.Outcome {
+	switch {
+	case err == nil:
+		return adaptive.Success
+	case errors.Is(err, context.Canceled):
+		return adaptive.Ignored
+	}
+	return adaptive.Dropped
+}
+
+// safeCall 调用f, 把panic转成*PanicError
+func safeCall(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,97 @@
+package errgrouptest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/adaptive"
+)
+
+// 和Test_AllExit一样, 只是g3由返回错误换成了panic
+func Test_SafeGroup_PanicCancel(t *testing.T) {
+	g, ctx := SafeWithContext(context.TODO())
+
+	for i := 0; i < 2; i++ {
+		g.Go(func() error {
+			<-ctx.Done()
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		time.Sleep(10 * time.Millisecond)
+		panic("g3 panic")
+	})
+
+	err := g.Wait()
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("want *PanicError, got %v", err)
+	}
+	if pe.Value != "g3 panic" {
+		t.Fatalf("panic value: %v", pe.Value)
+	}
+	if !strings.Contains(string(pe.Stack), "safegroup_test.go") {
+		t.Fatalf("stack does not contain the panic site:\n%s", pe.Stack)
+	}
+	if !errors.Is(context.Cause(ctx), err) {
+		t.Fatalf("ctx cause: %v", context.Cause(ctx))
+	}
+}
+
+func Test_SafeGroup_PanicWithError(t *testing.T) {
+	var g SafeGroup
+	g.Go(func() error {
+		panic(io.EOF)
+	})
+
+	if err := g.Wait(); !errors.Is(err, io.EOF) {
+		t.Fatalf("want io.EOF, got %v", err)
+	}
+}
+
+func Test_SafeGroup_Limit(t *testing.T) {
+	var g SafeGroup
+	g.SetLimit(1)
+
+	block := make(chan struct{})
+	g.Go(func() error {
+		<-block
+		return nil
+	})
+	if g.TryGo(func() error { return nil }) {
+		t.Fatal("TryGo should fail when the limit is reached")
+	}
+	close(block)
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 后端一直出错时, 并发上限会被压下来
+func Test_SafeGroup_Limiter(t *testing.T) {
+	l := adaptive.NewLimiter(adaptive.NewAIMD(8))
+
+	var g SafeGroup
+	g.SetLimiter(l)
+	for i := 0; i < 20; i++ {
+		g.Go(func() error {
+			time.Sleep(time.Millisecond)
+			return errors.New("backend unavailable")
+		})
+	}
+	g.Wait()
+
+	m := l.Metrics()
+	if m.Limit >= 8 {
+		t.Fatalf("limit should shrink, got %d", m.Limit)
+	}
+	if m.Dropped != 20 || m.InFlight != 0 {
+		t.Fatalf("metrics: %+v", m)
+	}
+}
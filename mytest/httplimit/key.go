@@ -0,0 +1,61 @@
+package httplimit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// KeyFunc 从请求里取限流的key, 返回空字符串时按客户端IP限流
+// 和kong rate-limiting插件的limit_by一样
+type KeyFunc func(r *http.Request) string
+
+// ByIP 按客户端IP限流, 用的是RemoteAddr
+// 前面有代理时请先把真实IP写进RemoteAddr, 或者用ByHeader("X-Real-IP")
+func ByIP() KeyFunc {
+	return clientIP
+}
+
+// ByHeader 按请求头限流, 比如API key
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// ByJWTSubject 按JWT的sub限流
+// 这里只解析不验签, 要放在认证中间件后面
+func ByJWTSubject() KeyFunc {
+	return func(r *http.Request) string {
+		auth := r.Header.Get("Authorization")
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return ""
+		}
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return ""
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return ""
+		}
+		var claims struct {
+			Sub string `json:"sub"`
+		}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return ""
+		}
+		return claims.Sub
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,207 @@
+package httplimit
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/kong/proxy-wasm-rust-rate-limiting
+
+const (
+	headerLimit      = "RateLimit-Limit"
+	headerRemaining  = "RateLimit-Remaining"
+	headerReset      = "RateLimit-Reset"
+	headerRetryAfter = "Retry-After"
+
+	defaultMessage = "API rate limit exceeded"
+)
+
+// Provider 按key返回Limiter, *second.Keyed就是一个Provider
+type Provider interface {
+	Get(key string) second.Limiter
+}
+
+// Single 所有key共用一个Limiter, 用来限制整个路由
+func Single(l second.Limiter) Provider {
+	return single{l}
+}
+
+type single struct{ l second.Limiter }
+
+func (s single) Get(string) second.Limiter { return s.l }
+
+// Rule 一条限流规则, 匹配上的请求按Key分别限流
+type Rule struct {
+	// Method 为空表示所有方法
+	Method string
+	// Path 以/结尾时按前缀匹配, 否则要完全相等, 和http.ServeMux一样
+	Path string
+	// Key 为nil时按IP限流
+	Key KeyFunc
+	// Limiter 每个key的Limiter
+	Limiter Provider
+}
+
+func (r *Rule) match(req *http.Request) bool {
+	if r.Method != "" && r.Method != req.Method {
+		return false
+	}
+	if strings.HasSuffix(r.Path, "/") {
+		return strings.HasPrefix(req.URL.Path, r.Path)
+	}
+	return req.URL.Path == r.Path
+}
+
+// Option 定制Middleware
+type Option func(o *options)
+
+type options struct {
+	clock       second.Clock
+	dryRun      bool
+	hideHeaders bool
+	statusCode  int
+	message     string
+}
+
+// WithClock 指定时钟, 从Policy创建Limiter和监听文件时用
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithDryRun 影子模式, 超限只打日志不拒绝, 也不加响应头
+// 上线新规则之前先用它看看会拦掉多少请求
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// WithHideHeaders 不返回RateLimit-*响应头, 对应kong的hide_client_headers
+func WithHideHeaders() Option {
+	return func(o *options) {
+		o.hideHeaders = true
+	}
+}
+
+// WithError 超限时的状态码和响应体, 默认是429
+func WithError(code int, message string) Option {
+	return func(o *options) {
+		o.statusCode = code
+		o.message = message
+	}
+}
+
+// Middleware net/http的限流中间件
+// 规则可以在运行时整体替换, 见SetRules和ApplyPolicy
+type Middleware struct {
+	rules atomic.Pointer[[]Rule]
+	opts  options
+
+	mu       sync.Mutex
+	compiled map[string]compiledRule // ApplyPolicy用到的, 按规则名字
+}
+
+// New 创建限流中间件, 请求按顺序匹配rules, 只用第一条匹配上的
+func New(rules []Rule, opts ...Option) *Middleware {
+	o := options{clock: second.RealClock, statusCode: http.StatusTooManyRequests, message: defaultMessage}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &Middleware{opts: o}
+	m.SetRules(rules)
+	return m
+}
+
+// SetRules 替换全部规则, 正在处理的请求用的还是旧规则
+func (m *Middleware) SetRules(rules []Rule) {
+	m.rules.Store(&rules)
+}
+
+// Handler 包装next
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := m.find(r)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := ""
+		if rule.Key != nil {
+			key = rule.Key(r)
+		}
+		if key == "" {
+			key = clientIP(r)
+		}
+
+		l := rule.Limiter.Get(key)
+		res := l.Reserve()
+		allowed := res.OK && res.Delay == 0
+		if !allowed {
+			// 拒绝的请求不占名额
+			res.Cancel()
+		}
+
+		if m.opts.dryRun {
+			if !allowed {
+				log.Printf("httplimit: dry run, %s %s key %s over limit, retry after %v",
+					r.Method, r.URL.Path, key, res.Delay)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !m.opts.hideHeaders {
+			setHeaders(w.Header(), l, allowed, res.Delay)
+		}
+		if !allowed {
+			w.Header().Set(headerRetryAfter, seconds(res.Delay))
+			http.Error(w, m.opts.message, m.opts.statusCode)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) find(r *http.Request) *Rule {
+	rules := *m.rules.Load()
+	for i := range rules {
+		if rules[i].match(r) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// setHeaders Limiter实现了second.QuotaReporter才知道剩余额度
+// 否则只在拒绝时返回Remaining为0
+func setHeaders(h http.Header, l second.Limiter, allowed bool, delay time.Duration) {
+	qr, ok := l.(second.QuotaReporter)
+	if !ok {
+		if !allowed {
+			h.Set(headerRemaining, "0")
+			h.Set(headerReset, seconds(delay))
+		}
+		return
+	}
+
+	q := qr.Quota()
+	h.Set(headerLimit, strconv.Itoa(q.Limit))
+	h.Set(headerRemaining, strconv.Itoa(q.Remaining))
+	h.Set(headerReset, seconds(q.Reset))
+}
+
+// seconds 响应头里的时间是整数秒, 向上取整
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,167 @@
+package httplimit
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func keyed(t *testing.T, alg second.Algorithm, r second.Rate, clk second.Clock) *second.Keyed {
+	t.Helper()
+	k, err := second.NewKeyed(second.KeyedConfig{Algorithm: alg, Rate: r}, second.WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func do(h http.Handler, method, path, apiKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if apiKey != "" {
+		r.Header.Set("X-Api-Key", apiKey)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func Test_Middleware(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	m := New([]Rule{{
+		Path:    "/api/",
+		Key:     ByHeader("X-Api-Key"),
+		Limiter: keyed(t, second.AlgFixedWindow, second.Rate{Limit: 2, Per: time.Minute}, clk),
+	}})
+	h := m.Handler(ok)
+
+	w := do(h, "GET", "/api/users", "a")
+	if w.Code != http.StatusOK {
+		t.Fatalf("code %d", w.Code)
+	}
+	if w.Header().Get(headerLimit) != "2" || w.Header().Get(headerRemaining) != "1" ||
+		w.Header().Get(headerReset) != "60" {
+		t.Fatalf("headers %v", w.Header())
+	}
+
+	do(h, "GET", "/api/users", "a")
+	clk.Advance(15 * time.Second)
+	w = do(h, "POST", "/api/orders", "a")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("code %d, want 429", w.Code)
+	}
+	if w.Header().Get(headerRetryAfter) != "45" || w.Header().Get(headerRemaining) != "0" {
+		t.Fatalf("headers %v", w.Header())
+	}
+
+	// 不同的key各算各的
+	if w := do(h, "GET", "/api/users", "b"); w.Code != http.StatusOK {
+		t.Fatalf("another key: code %d", w.Code)
+	}
+	// 没匹配上规则的路由不限流
+	for i := 0; i < 5; i++ {
+		if w := do(h, "GET", "/health", "a"); w.Code != http.StatusOK || w.Header().Get(headerLimit) != "" {
+			t.Fatalf("unmatched route: code %d, headers %v", w.Code, w.Header())
+		}
+	}
+
+	clk.Advance(45 * time.Second)
+	if w := do(h, "GET", "/api/users", "a"); w.Code != http.StatusOK {
+		t.Fatalf("next window: code %d", w.Code)
+	}
+}
+
+// 每个路由单独限流, 按第一条匹配的规则
+func Test_Middleware_Routes(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	login, _ := second.New(second.AlgTokenBucket, second.Rate{Limit: 1, Per: time.Second, Burst: 1}, second.WithClock(clk))
+	m := New([]Rule{
+		{Method: "POST", Path: "/login", Limiter: Single(login)},
+		{Path: "/", Limiter: keyed(t, second.AlgSlidingLog, second.Rate{Limit: 3, Per: time.Second}, clk)},
+	})
+	h := m.Handler(ok)
+
+	codes := func(method, path string, n int) (got []int) {
+		for i := 0; i < n; i++ {
+			got = append(got, do(h, method, path, "").Code)
+		}
+		return got
+	}
+	if got := codes("POST", "/login", 2); got[0] != 200 || got[1] != 429 {
+		t.Fatalf("login %v", got)
+	}
+	// GET /login走的是第二条规则
+	if got := codes("GET", "/login", 4); got[2] != 200 || got[3] != 429 {
+		t.Fatalf("fallback rule %v", got)
+	}
+}
+
+// Uber没有额度信息, 只在拒绝时返回Remaining和Reset
+func Test_Middleware_NoQuota(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	h := New([]Rule{{
+		Path:    "/",
+		Limiter: keyed(t, second.AlgUber, second.Rate{Limit: 1, Per: time.Second}, clk),
+	}}).Handler(ok)
+
+	w := do(h, "GET", "/", "")
+	if w.Code != http.StatusOK || w.Header().Get(headerRemaining) != "" {
+		t.Fatalf("code %d, headers %v", w.Code, w.Header())
+	}
+	w = do(h, "GET", "/", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(headerRemaining) != "0" ||
+		w.Header().Get(headerRetryAfter) != "1" {
+		t.Fatalf("code %d, headers %v", w.Code, w.Header())
+	}
+}
+
+func Test_Middleware_DryRun(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	clk := second.NewFakeClock(epoch)
+	h := New([]Rule{{
+		Path:    "/",
+		Limiter: keyed(t, second.AlgFixedWindow, second.Rate{Limit: 1, Per: time.Second}, clk),
+	}}, WithDryRun()).Handler(ok)
+
+	for i := 0; i < 3; i++ {
+		w := do(h, "GET", "/", "")
+		if w.Code != http.StatusOK || w.Header().Get(headerLimit) != "" {
+			t.Fatalf("dry run: code %d, headers %v", w.Code, w.Header())
+		}
+	}
+	if n := strings.Count(buf.String(), "over limit"); n != 2 {
+		t.Fatalf("logged %d times: %s", n, buf.String())
+	}
+}
+
+func Test_Middleware_Options(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	h := New([]Rule{{
+		Path:    "/",
+		Limiter: keyed(t, second.AlgFixedWindow, second.Rate{Limit: 1, Per: time.Second}, clk),
+	}}, WithHideHeaders(), WithError(http.StatusServiceUnavailable, "slow down")).Handler(ok)
+
+	do(h, "GET", "/", "")
+	w := do(h, "GET", "/", "")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "slow down") {
+		t.Fatalf("code %d, body %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get(headerLimit) != "" || w.Header().Get(headerRetryAfter) != "1" {
+		t.Fatalf("headers %v", w.Header())
+	}
+}
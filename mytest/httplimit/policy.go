@@ -0,0 +1,304 @@
+package httplimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+	"gopkg.in/yaml.v3"
+)
+
+// 限流策略的配置文件, JSON或者YAML, 以{开头的按JSON解析, 其他的按YAML解析. JSON的例子:
+//
+//	{
+//	  "rules": [
+//	    {
+//	      "name": "login",
+//	      "method": "POST",
+//	      "path": "/login",
+//	      "key": "ip",
+//	      "algorithm": "token_bucket",
+//	      "windows": [
+//	        {"limit": 5, "per": "1s", "burst": 10},
+//	        {"limit": 100, "per": "1h"}
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// 同样的配置写成YAML:
+//
+//	rules:
+//	  - name: login
+//	    method: POST
+//	    path: /login
+//	    key: ip
+//	    algorithm: token_bucket
+//	    windows:
+//	      - {limit: 5, per: 1s, burst: 10}
+//	      - {limit: 100, per: 1h}
+//
+// key可以是 ip, header:<name>, jwt_sub, 不写就是ip
+
+// Policy 一组限流规则, 按顺序匹配
+type Policy struct {
+	Rules []RuleConfig `json:"rules" yaml:"rules"`
+}
+
+// RuleConfig 一条规则的配置, 对应Rule
+type RuleConfig struct {
+	Name      string           `json:"name" yaml:"name"`
+	Method    string           `json:"method,omitempty" yaml:"method,omitempty"`
+	Path      string           `json:"path" yaml:"path"`
+	Key       string           `json:"key,omitempty" yaml:"key,omitempty"`
+	Algorithm second.Algorithm `json:"algorithm" yaml:"algorithm"`
+	// Windows 多个窗口同时生效, 比如每秒5个并且每小时100个
+	Windows []Window `json:"windows" yaml:"windows"`
+	// MaxKeys 和IdleTimeout见second.KeyedConfig
+	MaxKeys     int      `json:"max_keys,omitempty" yaml:"max_keys,omitempty"`
+	IdleTimeout Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+}
+
+// Window 每Per时间最多Limit个请求, Burst只对桶类算法有用, 不写时和Limit一样
+type Window struct {
+	Limit int      `json:"limit" yaml:"limit"`
+	Per   Duration `json:"per" yaml:"per"`
+	Burst int      `json:"burst,omitempty" yaml:"burst,omitempty"`
+}
+
+// Duration 配置文件里写成 "1s", "500ms", "1h"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"1s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode || value.Tag != "!!str" {
+		return fmt.Errorf("line %d: duration should be a string like \"1s\"", value.Line)
+	}
+	v, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var (
+	// ErrEmptyPolicy 没有规则
+	ErrEmptyPolicy = errors.New("httplimit: policy has no rules")
+
+	methods = map[string]bool{
+		http.MethodGet: true, http.MethodHead: true, http.MethodPost: true,
+		http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+		http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+	}
+)
+
+// ParsePolicy 解析并检查配置, JSON和YAML都可以, 不认识的字段也算错
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	if err := decodePolicy(data, &p); err != nil {
+		return nil, fmt.Errorf("httplimit: parse policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func decodePolicy(data []byte, p *Policy) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(p)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(p)
+	if errors.Is(err, io.EOF) {
+		// 空文件
+		return nil
+	}
+	return err
+}
+
+// LoadPolicy 从文件读取配置
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// Validate 检查配置, 返回第一个错误
+func (p *Policy) Validate() error {
+	if len(p.Rules) == 0 {
+		return ErrEmptyPolicy
+	}
+
+	names := make(map[string]bool, len(p.Rules))
+	for i, r := range p.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("httplimit: rule %d (%s): %w", i, r.Name, err)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("httplimit: rule %d: duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+func (r *RuleConfig) validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q should start with /", r.Path)
+	}
+	if r.Method != "" && !methods[r.Method] {
+		return fmt.Errorf("unknown method %q", r.Method)
+	}
+	if _, err := keyFunc(r.Key); err != nil {
+		return err
+	}
+	if len(r.Windows) == 0 {
+		return errors.New("at least one window is required")
+	}
+	if r.MaxKeys < 0 || r.IdleTimeout < 0 {
+		return errors.New("max_keys and idle_timeout can't be negative")
+	}
+	for _, w := range r.Windows {
+		if w.Burst < 0 {
+			return fmt.Errorf("negative burst %d", w.Burst)
+		}
+		// 算法名和速率交给second.New检查
+		if _, err := second.New(r.Algorithm, w.rate()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w Window) rate() second.Rate {
+	burst := w.Burst
+	if burst == 0 {
+		burst = w.Limit
+	}
+	return second.Rate{Limit: w.Limit, Per: time.Duration(w.Per), Burst: burst}
+}
+
+// keyFunc 把配置里的key转成KeyFunc, ip返回nil
+func keyFunc(spec string) (KeyFunc, error) {
+	switch {
+	case spec == "" || spec == "ip":
+		return nil, nil
+	case spec == "jwt_sub":
+		return ByJWTSubject(), nil
+	case strings.HasPrefix(spec, "header:") && len(spec) > len("header:"):
+		return ByHeader(strings.TrimPrefix(spec, "header:")), nil
+	}
+	return nil, fmt.Errorf("unknown key %q, want ip, header:<name> or jwt_sub", spec)
+}
+
+// compiledRule 记住规则的配置, 重新加载时配置没变就复用Limiter
+type compiledRule struct {
+	cfg  RuleConfig
+	rule Rule
+}
+
+// ApplyPolicy 用Policy替换当前规则
+// 名字和配置都没变的规则继续用原来的Limiter, 已经用掉的名额不会清零
+func (m *Middleware) ApplyPolicy(p *Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	compiled := make(map[string]compiledRule, len(p.Rules))
+	rules := make([]Rule, 0, len(p.Rules))
+	for _, cfg := range p.Rules {
+		c, ok := m.compiled[cfg.Name]
+		if !ok || !reflect.DeepEqual(c.cfg, cfg) {
+			rule, err := m.compile(cfg)
+			if err != nil {
+				return err
+			}
+			c = compiledRule{cfg: cfg, rule: rule}
+		}
+		compiled[cfg.Name] = c
+		rules = append(rules, c.rule)
+	}
+
+	m.compiled = compiled
+	m.SetRules(rules)
+	return nil
+}
+
+func (m *Middleware) compile(cfg RuleConfig) (Rule, error) {
+	key, err := keyFunc(cfg.Key)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	windows := make([]*second.Keyed, 0, len(cfg.Windows))
+	for _, w := range cfg.Windows {
+		k, err := second.NewKeyed(second.KeyedConfig{
+			Algorithm:   cfg.Algorithm,
+			Rate:        w.rate(),
+			MaxKeys:     cfg.MaxKeys,
+			IdleTimeout: time.Duration(cfg.IdleTimeout),
+		}, second.WithClock(m.opts.clock))
+		if err != nil {
+			return Rule{}, err
+		}
+		windows = append(windows, k)
+	}
+
+	var provider Provider = windows[0]
+	if len(windows) > 1 {
+		provider = multiWindow{windows: windows, clock: m.opts.clock}
+	}
+	return Rule{Method: cfg.Method, Path: cfg.Path, Key: key, Limiter: provider}, nil
+}
+
+// multiWindow 一个key在每个窗口都有一个Limiter, 组合成second.Multi
+type multiWindow struct {
+	windows []*second.Keyed
+	clock   second.Clock
+}
+
+func (m multiWindow) Get(key string) second.Limiter {
+	ls := make([]second.Limiter, 0, len(m.windows))
+	for _, w := range m.windows {
+		ls = append(ls, w.Get(key))
+	}
+	return second.NewMulti(ls, second.WithClock(m.clock))
+}
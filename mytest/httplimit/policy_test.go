@@ -0,0 +1,153 @@
+package httplimit
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+const testPolicy = `{
+  "rules": [
+    {
+      "name": "login",
+      "method": "POST",
+      "path": "/login",
+      "algorithm": "token_bucket",
+      "windows": [
+        {"limit": 2, "per": "1s", "burst": 2},
+        {"limit": 3, "per": "1m"}
+      ]
+    },
+    {
+      "name": "api",
+      "path": "/api/",
+      "key": "header:X-Api-Key",
+      "algorithm": "fixed_window",
+      "windows": [{"limit": 1, "per": "1m"}],
+      "max_keys": 1000,
+      "idle_timeout": "10m"
+    }
+  ]
+}`
+
+func Test_ParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 2 || p.Rules[0].Windows[1].Per != Duration(time.Minute) ||
+		p.Rules[1].IdleTimeout != Duration(10*time.Minute) {
+		t.Fatalf("policy %+v", p)
+	}
+
+	bad := map[string]string{
+		"empty":          `{"rules": []}`,
+		"unknown field":  `{"rules": [{"name": "a", "path": "/", "algorithm": "uber", "windows": [{"limit": 1, "per": "1s"}], "hour": 1}]}`,
+		"no name":        `{"rules": [{"path": "/", "algorithm": "uber", "windows": [{"limit": 1, "per": "1s"}]}]}`,
+		"bad path":       `{"rules": [{"name": "a", "path": "api", "algorithm": "uber", "windows": [{"limit": 1, "per": "1s"}]}]}`,
+		"bad method":     `{"rules": [{"name": "a", "method": "get", "path": "/", "algorithm": "uber", "windows": [{"limit": 1, "per": "1s"}]}]}`,
+		"bad key":        `{"rules": [{"name": "a", "path": "/", "key": "cookie", "algorithm": "uber", "windows": [{"limit": 1, "per": "1s"}]}]}`,
+		"bad algorithm":  `{"rules": [{"name": "a", "path": "/", "algorithm": "gcra", "windows": [{"limit": 1, "per": "1s"}]}]}`,
+		"no windows":     `{"rules": [{"name": "a", "path": "/", "algorithm": "uber"}]}`,
+		"zero limit":     `{"rules": [{"name": "a", "path": "/", "algorithm": "uber", "windows": [{"limit": 0, "per": "1s"}]}]}`,
+		"bad duration":   `{"rules": [{"name": "a", "path": "/", "algorithm": "uber", "windows": [{"limit": 1, "per": 1}]}]}`,
+		"duplicate name": `{"rules": [{"name": "a", "path": "/", "algorithm": "uber", "windows": [{"limit": 1, "per": "1s"}]}, {"name": "a", "path": "/b", "algorithm": "uber", "windows": [{"limit": 1, "per": "1s"}]}]}`,
+	}
+	for name, data := range bad {
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("%s: should fail", name)
+		}
+	}
+}
+
+// testPolicy写成YAML
+const testPolicyYAML = `
+rules:
+  - name: login
+    method: POST
+    path: /login
+    algorithm: token_bucket
+    windows:
+      - {limit: 2, per: 1s, burst: 2}
+      - {limit: 3, per: 1m}
+  - name: api
+    path: /api/
+    key: header:X-Api-Key
+    algorithm: fixed_window
+    windows:
+      - limit: 1
+        per: 1m
+    max_keys: 1000
+    idle_timeout: 10m
+`
+
+func Test_ParsePolicy_YAML(t *testing.T) {
+	want, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParsePolicy([]byte(testPolicyYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("yaml policy %+v, want %+v", got, want)
+	}
+
+	bad := map[string]string{
+		"empty":         ``,
+		"unknown field": "rules:\n  - {name: a, path: /, algorithm: uber, windows: [{limit: 1, per: 1s}], hour: 1}\n",
+		"bad duration":  "rules:\n  - {name: a, path: /, algorithm: uber, windows: [{limit: 1, per: 1}]}\n",
+		"bad syntax":    "rules:\n  - name: a\n   path: /\n",
+	}
+	for name, data := range bad {
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("%s: should fail", name)
+		}
+	}
+}
+
+func Test_ApplyPolicy(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	m := New(nil, WithClock(clk))
+	p, _ := ParsePolicy([]byte(testPolicy))
+	if err := m.ApplyPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(ok)
+
+	// 每秒2个, 每分钟3个
+	codes := func(n int) (got string) {
+		for i := 0; i < n; i++ {
+			got += http.StatusText(do(h, "POST", "/login", "").Code)[:1]
+		}
+		return got
+	}
+	if got := codes(3); got != "OOT" {
+		t.Fatalf("first second %s", got)
+	}
+	clk.Advance(time.Second)
+	if got := codes(2); got != "OT" {
+		t.Fatalf("second second %s", got)
+	}
+	if w := do(h, "GET", "/api/x", "k"); w.Code != http.StatusOK {
+		t.Fatalf("api code %d", w.Code)
+	}
+
+	// login没变, 状态保留; api的速率改了, 重新计数
+	p2, _ := ParsePolicy([]byte(strings.Replace(testPolicy, `{"limit": 1, "per": "1m"}`, `{"limit": 2, "per": "1m"}`, 1)))
+	if err := m.ApplyPolicy(p2); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if got := codes(1); got != "T" {
+		t.Fatalf("login state was dropped: %s", got)
+	}
+	if w := do(h, "GET", "/api/x", "k"); w.Code != http.StatusOK || w.Header().Get(headerLimit) != "2" {
+		t.Fatalf("api code %d, headers %v", w.Code, w.Header())
+	}
+}
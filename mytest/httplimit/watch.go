@@ -0,0 +1,68 @@
+package httplimit
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultWatchInterval = time.Second
+
+// WatchPolicy 加载path里的策略, 然后定时检查文件, 内容变了就重新加载
+// 第一次加载失败返回错误, 之后加载失败只打日志, 继续用旧的策略
+// ctx结束时停止检查
+func (m *Middleware) WatchPolicy(ctx context.Context, path string, interval time.Duration) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := m.applyPolicyData(data); err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	go m.watch(ctx, path, interval, data)
+	return nil
+}
+
+func (m *Middleware) watch(ctx context.Context, path string, interval time.Duration, last []byte) {
+	ticker := m.opts.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		// 编辑器保存文件时可能短暂不存在, 下一轮再试
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("httplimit: read policy %s: %s", path, err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+
+		last = data
+		if err := m.applyPolicyData(data); err != nil {
+			log.Printf("httplimit: reload policy %s: %s, keep the old one", path, err)
+			continue
+		}
+		log.Printf("httplimit: reloaded policy %s", path)
+	}
+}
+
+func (m *Middleware) applyPolicyData(data []byte) error {
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return err
+	}
+	return m.ApplyPolicy(p)
+}
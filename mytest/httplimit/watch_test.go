@@ -0,0 +1,80 @@
+package httplimit
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+func Test_WatchPolicy(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(testPolicy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := New(nil, WithClock(clk))
+	if err := m.WatchPolicy(ctx, path, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(ok)
+
+	do(h, "GET", "/api/x", "k")
+	if w := do(h, "GET", "/api/x", "k"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("code %d", w.Code)
+	}
+
+	// 新增一条规则, api不变
+	updated := strings.Replace(testPolicy, `"rules": [`, `"rules": [
+    {"name": "health", "path": "/health", "algorithm": "fixed_window", "windows": [{"limit": 1, "per": "1m"}]},`, 1)
+	if err := os.WriteFile(path, []byte(updated), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	waitFor(t, func() bool {
+		do(h, "GET", "/health", "")
+		return do(h, "GET", "/health", "").Code == http.StatusTooManyRequests
+	})
+	if w := do(h, "GET", "/api/x", "k"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("api state was dropped: code %d", w.Code)
+	}
+
+	// 写坏了继续用旧的
+	if err := os.WriteFile(path, []byte(`{"rules": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if w := do(h, "GET", "/health", ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("bad file replaced the policy: code %d", w.Code)
+	}
+}
+
+func Test_WatchPolicy_BadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"rules": []}`), 0o644)
+	if err := New(nil).WatchPolicy(context.Background(), path, 0); err == nil {
+		t.Fatal("empty policy should fail")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
@@ -0,0 +1,93 @@
+//go:build linux || darwin
+
+package id
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// ErrNoFreeMachineID 所有的机器ID都被占了
+var ErrNoFreeMachineID = errors.New("id: no free machine id")
+
+// ErrLeaseReleased FileLease关闭之后不能再发ID
+var ErrLeaseReleased = errors.New("id: machine id lease released")
+
+// FileLease 在dir下给每个机器ID一个锁文件, 用flock抢第一个没被锁的
+// 同一台机器(或者共享同一个目录)的多个进程不会拿到同一个ID, 进程退出时内核自动释放锁
+// 不能跨机器, 跨机器用lease包
+type FileLease struct {
+	dir string
+	max uint64 // 最多试多少个ID, 0表示按位数
+
+	mu   sync.Mutex
+	file *os.File
+	id   uint64
+}
+
+// NewFileLease max为0时试遍所有ID, 位数多时可以限制一下
+func NewFileLease(dir string, max uint64) *FileLease {
+	return &FileLease{dir: dir, max: max}
+}
+
+// MachineID 拿到锁之后一直持有到Close
+func (l *FileLease) MachineID(bits int) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		return l.id, nil
+	}
+	if err := os.MkdirAll(l.dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	n := uint64(1) << bits
+	if l.max > 0 && l.max < n {
+		n = l.max
+	}
+	for id := uint64(0); id < n; id++ {
+		f, err := os.OpenFile(filepath.Join(l.dir, fmt.Sprintf("%d.lock", id)), os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return 0, err
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			// 写上pid方便排查是谁占着
+			f.Truncate(0)
+			f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+			l.file, l.id = f, id
+			return id, nil
+		}
+		f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return 0, err
+		}
+	}
+	return 0, ErrNoFreeMachineID
+}
+
+// Valid 实现Validator, Close之后返回错误
+func (l *FileLease) Valid() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return ErrLeaseReleased
+	}
+	return nil
+}
+
+// Close 释放锁, 之后用这个ID的Generator不再发ID
+func (l *FileLease) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
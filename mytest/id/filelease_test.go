@@ -0,0 +1,58 @@
+//go:build linux || darwin
+
+package id
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+func Test_FileLease(t *testing.T) {
+	dir := t.TempDir()
+	a := NewFileLease(dir, 2)
+	b := NewFileLease(dir, 2)
+	c := NewFileLease(dir, 2)
+
+	idA, err := a.MachineID(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idB, err := b.MachineID(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idA != 0 || idB != 1 {
+		t.Fatalf("ids = %d %d, want 0 1", idA, idB)
+	}
+	if _, err := c.MachineID(10); !errors.Is(err, ErrNoFreeMachineID) {
+		t.Fatalf("err = %v, want ErrNoFreeMachineID", err)
+	}
+
+	// a释放之后c能拿到0
+	a.Close()
+	if id, err := c.MachineID(10); err != nil || id != 0 {
+		t.Fatalf("after release: %d, %v", id, err)
+	}
+	b.Close()
+	c.Close()
+}
+
+// Test_FileLease_Generator 租约释放之后Generator不再发ID
+func Test_FileLease_Generator(t *testing.T) {
+	l := NewFileLease(t.TempDir(), 0)
+	g, err := New(WithLayout(smallLayout), WithStartTime(epoch), WithMachineID(l),
+		WithClock(second.NewFakeClock(epoch.Add(time.Second))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.NextID(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, err := g.NextID(); !errors.Is(err, ErrLeaseReleased) {
+		t.Fatalf("err = %v, want ErrLeaseReleased", err)
+	}
+}
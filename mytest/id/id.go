@@ -0,0 +1,338 @@
+package id
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/sonyflake/sonyflake.md
+// sonyflake的位数是写死的: 39位时间(10ms), 8位序列号, 16位机器ID, 2024年的ID已经59位了,
+// 放进redis的zset(score是double, 只有53位精度)会丢精度. 这里位数和时间单位都可以配置,
+// 机器ID的来源可以替换, 时钟回拨时可以选择等待, 报错或者借用未来的时间
+
+var (
+	// ErrBadLayout 位数加起来超过63或者有一项不大于0
+	ErrBadLayout = errors.New("id: bad layout")
+	// ErrStartTimeAhead 开始时间在当前时间之后
+	ErrStartTimeAhead = errors.New("id: start time is ahead of now")
+	// ErrOverTimeLimit 时间部分用完了
+	ErrOverTimeLimit = errors.New("id: over the time limit")
+	// ErrInvalidMachineID 机器ID超出了位数或者没通过检查
+	ErrInvalidMachineID = errors.New("id: invalid machine id")
+	// ErrClockRollback 时钟回拨, 并且按策略不能继续发ID
+	ErrClockRollback = errors.New("id: clock moved backwards")
+)
+
+// Layout ID的位数, 从高到低是 时间|序列号|机器ID, 最高位是0
+type Layout struct {
+	TimeUnit     time.Duration
+	TimeBits     int
+	SequenceBits int
+	MachineBits  int
+}
+
+// DefaultLayout 和sonyflake一样
+var DefaultLayout = Layout{TimeUnit: 10 * time.Millisecond, TimeBits: 39, SequenceBits: 8, MachineBits: 16}
+
+// Validate 检查位数
+func (l Layout) Validate() error {
+	if l.TimeUnit <= 0 || l.TimeBits <= 0 || l.SequenceBits <= 0 || l.MachineBits <= 0 ||
+		l.TimeBits+l.SequenceBits+l.MachineBits > 63 {
+		return fmt.Errorf("%w: %+v", ErrBadLayout, l)
+	}
+	return nil
+}
+
+// MaxMachineID 机器ID最大是多少
+func (l Layout) MaxMachineID() uint64 {
+	return 1<<l.MachineBits - 1
+}
+
+func (l Layout) maxSequence() uint64 {
+	return 1<<l.SequenceBits - 1
+}
+
+// Lifetime 从开始时间算起能用多久, 超过time.Duration的范围时返回最大值
+func (l Layout) Lifetime() time.Duration {
+	ticks := uint64(1) << l.TimeBits
+	if ticks > math.MaxInt64/uint64(l.TimeUnit) {
+		return math.MaxInt64
+	}
+	return time.Duration(ticks * uint64(l.TimeUnit))
+}
+
+// Parts ID拆开后的各部分
+type Parts struct {
+	ID       uint64
+	MSB      uint64
+	Elapsed  uint64 // 从开始时间算起经过了多少个TimeUnit
+	Sequence uint64
+	Machine  uint64
+}
+
+// Decompose 按位数拆开ID
+func (l Layout) Decompose(id uint64) Parts {
+	return Parts{
+		ID:       id,
+		MSB:      id >> 63,
+		Elapsed:  id >> (l.SequenceBits + l.MachineBits),
+		Sequence: id >> l.MachineBits & l.maxSequence(),
+		Machine:  id & l.MaxMachineID(),
+	}
+}
+
+func (l Layout) compose(elapsed, seq, machine uint64) uint64 {
+	return elapsed<<(l.SequenceBits+l.MachineBits) | seq<<l.MachineBits | machine
+}
+
+// RollbackPolicy 时钟回拨时怎么办
+type RollbackPolicy int
+
+const (
+	// RollbackWait 等时钟追上来, 回拨超过max时返回ErrClockRollback
+	RollbackWait RollbackPolicy = iota
+	// RollbackError 马上返回ErrClockRollback
+	RollbackError
+	// RollbackBorrow 接着用回拨前的时间, 序列号用完了就借下一个时间单位, 借得超过max时返回ErrClockRollback
+	RollbackBorrow
+)
+
+func (p RollbackPolicy) String() string {
+	switch p {
+	case RollbackWait:
+		return "wait"
+	case RollbackError:
+		return "error"
+	case RollbackBorrow:
+		return "borrow"
+	}
+	return "unknown"
+}
+
+type options struct {
+	layout       Layout
+	startTime    time.Time
+	provider     MachineIDProvider
+	checkMachine func(uint64) bool
+	rollback     RollbackPolicy
+	maxRollback  time.Duration
+	clock        second.Clock
+}
+
+// Option Generator的选项
+type Option func(*options)
+
+// WithLayout 位数, 默认DefaultLayout
+func WithLayout(l Layout) Option {
+	return func(o *options) {
+		o.layout = l
+	}
+}
+
+// WithStartTime 从什么时候开始算时间, 默认2014-09-01 UTC, 和sonyflake一样
+// 设置成离现在近的时间, ID会短很多
+func WithStartTime(t time.Time) Option {
+	return func(o *options) {
+		o.startTime = t
+	}
+}
+
+// WithMachineID 机器ID从哪里来, 默认FromIP()
+func WithMachineID(p MachineIDProvider) Option {
+	return func(o *options) {
+		o.provider = p
+	}
+}
+
+// WithCheckMachineID 和sonyflake的CheckMachineID一样, 返回false时New失败
+func WithCheckMachineID(check func(uint64) bool) Option {
+	return func(o *options) {
+		o.checkMachine = check
+	}
+}
+
+// WithRollback 时钟回拨的策略, max是最多等多久或者最多借多久, 默认等最多1秒
+func WithRollback(p RollbackPolicy, max time.Duration) Option {
+	return func(o *options) {
+		o.rollback, o.maxRollback = p, max
+	}
+}
+
+// WithClock 时钟, 测试时用FakeClock
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// Generator 分布式唯一ID生成器
+type Generator struct {
+	opts      options
+	layout    Layout
+	startTime int64 // 开始时间, 单位是TimeUnit
+	machine   uint64
+	validator Validator
+
+	mu       sync.Mutex
+	elapsed  uint64 // 最近一个ID的时间部分, 借时间时会比时钟快
+	lastSeen int64  // 见过的最大时钟, 用来发现回拨
+	sequence uint64
+}
+
+// New 创建Generator, 取不到机器ID或者机器ID超出位数时返回错误
+func New(opts ...Option) (*Generator, error) {
+	o := options{
+		layout:      DefaultLayout,
+		startTime:   time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC),
+		rollback:    RollbackWait,
+		maxRollback: time.Second,
+		clock:       second.RealClock,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.provider == nil {
+		o.provider = FromIP()
+	}
+	if err := o.layout.Validate(); err != nil {
+		return nil, err
+	}
+	if o.startTime.After(o.clock.Now()) {
+		return nil, ErrStartTimeAhead
+	}
+
+	g := &Generator{opts: o, layout: o.layout}
+	g.startTime = g.toTicks(o.startTime)
+	// 第一次NextID时序列号从0开始
+	g.sequence = o.layout.maxSequence()
+
+	machine, err := o.provider.MachineID(o.layout.MachineBits)
+	if err != nil {
+		return nil, err
+	}
+	if machine > o.layout.MaxMachineID() || (o.checkMachine != nil && !o.checkMachine(machine)) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidMachineID, machine)
+	}
+	g.machine = machine
+	g.validator, _ = o.provider.(Validator)
+	return g, nil
+}
+
+// MachineID 这个Generator的机器ID
+func (g *Generator) MachineID() uint64 {
+	return g.machine
+}
+
+func (g *Generator) toTicks(t time.Time) int64 {
+	return t.UnixNano() / int64(g.layout.TimeUnit)
+}
+
+func (g *Generator) now() int64 {
+	return g.toTicks(g.opts.clock.Now()) - g.startTime
+}
+
+// NextID 生成下一个ID
+// 同一个时间单位里序列号用完时, 和sonyflake一样睡到下一个时间单位
+func (g *Generator) NextID() (uint64, error) {
+	// 租约丢了的机器ID不能再用, 别的实例可能已经拿到了
+	if g.validator != nil {
+		if err := g.validator.Valid(); err != nil {
+			return 0, err
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	current, borrowing, err := g.current()
+	if err != nil {
+		return 0, err
+	}
+
+	// 先算出新的时间和序列号, 检查通过了再改g, 出错时下一次调用不受影响
+	elapsed, sequence := g.elapsed, g.sequence
+	if elapsed < uint64(current) {
+		elapsed = uint64(current)
+		sequence = 0
+	} else {
+		sequence = (sequence + 1) & g.layout.maxSequence()
+		if sequence == 0 {
+			elapsed++
+		}
+	}
+	// 借用时每次都检查, 不然借出去的时间只有序列号用完时才检查
+	if borrowing {
+		if err := g.checkBorrow(elapsed); err != nil {
+			return 0, err
+		}
+	} else if sequence == 0 && elapsed > uint64(current) {
+		g.waitFor(elapsed, current)
+	}
+	g.elapsed, g.sequence = elapsed, sequence
+
+	if g.elapsed >= 1<<g.layout.TimeBits {
+		return 0, ErrOverTimeLimit
+	}
+	return g.layout.compose(g.elapsed, g.sequence, g.machine), nil
+}
+
+// current 当前时间, 处理时钟回拨, borrowing表示正在借用回拨前的时间
+func (g *Generator) current() (current int64, borrowing bool, err error) {
+	current = g.now()
+	if current >= g.lastSeen {
+		g.lastSeen = current
+		return current, false, nil
+	}
+
+	back := time.Duration(g.lastSeen-current) * g.layout.TimeUnit
+	switch g.opts.rollback {
+	case RollbackWait:
+		if back > g.opts.maxRollback {
+			return 0, false, fmt.Errorf("%w by %s", ErrClockRollback, back)
+		}
+		g.opts.clock.Sleep(back)
+		if current = g.now(); current < g.lastSeen {
+			return 0, false, fmt.Errorf("%w by %s", ErrClockRollback, back)
+		}
+		g.lastSeen = current
+		return current, false, nil
+	case RollbackBorrow:
+		if g.opts.maxRollback > 0 && back > g.opts.maxRollback {
+			return 0, false, fmt.Errorf("%w by %s", ErrClockRollback, back)
+		}
+		return g.lastSeen, true, nil
+	}
+	return 0, false, fmt.Errorf("%w by %s", ErrClockRollback, back)
+}
+
+// checkBorrow 借用回拨前的时间时, elapsed比现在超前多少不能超过maxRollback
+func (g *Generator) checkBorrow(elapsed uint64) error {
+	ahead := time.Duration(int64(elapsed)-g.now()) * g.layout.TimeUnit
+	if g.opts.maxRollback > 0 && ahead > g.opts.maxRollback {
+		return fmt.Errorf("%w: borrowed %s", ErrClockRollback, ahead)
+	}
+	return nil
+}
+
+// waitFor 序列号用完后等到elapsed这个时间单位
+func (g *Generator) waitFor(elapsed uint64, current int64) {
+	overtime := int64(elapsed) - current
+	unit := int64(g.layout.TimeUnit)
+	g.opts.clock.Sleep(time.Duration(overtime*unit - g.opts.clock.Now().UnixNano()%unit))
+}
+
+// Decompose 拆开ID
+func (g *Generator) Decompose(id uint64) Parts {
+	return g.layout.Decompose(id)
+}
+
+// Time ID是什么时候生成的, 精度是TimeUnit
+func (g *Generator) Time(id uint64) time.Time {
+	elapsed := int64(g.layout.Decompose(id).Elapsed)
+	return time.Unix(0, (g.startTime+elapsed)*int64(g.layout.TimeUnit)).UTC()
+}
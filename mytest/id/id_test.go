@@ -0,0 +1,260 @@
+package id
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// smallLayout 1ms, 4位序列号, 方便测序列号用完
+var smallLayout = Layout{TimeUnit: time.Millisecond, TimeBits: 41, SequenceBits: 4, MachineBits: 10}
+
+func newTestGenerator(t *testing.T, clk second.Clock, opts ...Option) *Generator {
+	t.Helper()
+	opts = append([]Option{WithLayout(smallLayout), WithStartTime(epoch), WithMachineID(Static(7)), WithClock(clk)}, opts...)
+	g, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func mustNext(t *testing.T, g *Generator) Parts {
+	t.Helper()
+	id, err := g.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.Decompose(id)
+}
+
+func Test_Layout(t *testing.T) {
+	if err := DefaultLayout.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []Layout{
+		{TimeUnit: time.Millisecond, TimeBits: 40, SequenceBits: 12, MachineBits: 12},
+		{TimeUnit: 0, TimeBits: 39, SequenceBits: 8, MachineBits: 16},
+		{TimeUnit: time.Millisecond, TimeBits: 39, SequenceBits: 0, MachineBits: 16},
+	} {
+		if err := l.Validate(); !errors.Is(err, ErrBadLayout) {
+			t.Fatalf("%+v: err = %v, want ErrBadLayout", l, err)
+		}
+	}
+
+	// 39位10ms大概174年
+	if years := DefaultLayout.Lifetime().Hours() / 24 / 365; years < 174 || years > 175 {
+		t.Fatalf("Lifetime = %v years", years)
+	}
+
+	// 53位以内, redis的score不丢精度: 1ms, 35位时间(约1年), 8位序列号, 10位机器
+	l := Layout{TimeUnit: time.Millisecond, TimeBits: 35, SequenceBits: 8, MachineBits: 10}
+	id := l.compose(1<<35-1, 255, 1023)
+	if id != 1<<53-1 {
+		t.Fatalf("compose = %d, want 2^53-1", id)
+	}
+	if p := l.Decompose(id); p != (Parts{ID: id, Elapsed: 1<<35 - 1, Sequence: 255, Machine: 1023}) {
+		t.Fatalf("Decompose = %+v", p)
+	}
+}
+
+func Test_NextID(t *testing.T) {
+	clk := second.NewFakeClock(epoch.Add(time.Second))
+	g := newTestGenerator(t, clk)
+
+	p := mustNext(t, g)
+	if p.Elapsed != 1000 || p.Sequence != 0 || p.Machine != 7 || p.MSB != 0 {
+		t.Fatalf("first = %+v", p)
+	}
+	if p := mustNext(t, g); p.Elapsed != 1000 || p.Sequence != 1 {
+		t.Fatalf("second = %+v", p)
+	}
+	clk.Advance(time.Millisecond)
+	if p := mustNext(t, g); p.Elapsed != 1001 || p.Sequence != 0 {
+		t.Fatalf("next tick = %+v", p)
+	}
+	if got := g.Time(p.ID); !got.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("Time = %v", got)
+	}
+}
+
+// Test_NextID_Overflow 序列号用完了睡到下一个时间单位
+func Test_NextID_Overflow(t *testing.T) {
+	clk := second.NewFakeClock(epoch.Add(time.Second))
+	g := newTestGenerator(t, clk)
+	for i := 0; i < 16; i++ {
+		mustNext(t, g)
+	}
+
+	got := make(chan Parts, 1)
+	go func() { got <- mustNext(t, g) }()
+	clk.BlockUntil(1)
+	clk.Advance(time.Millisecond)
+	if p := <-got; p.Elapsed != 1001 || p.Sequence != 0 {
+		t.Fatalf("after overflow = %+v", p)
+	}
+}
+
+func Test_NextID_Rollback(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		clk := second.NewFakeClock(epoch.Add(time.Second))
+		g := newTestGenerator(t, clk, WithRollback(RollbackError, 0))
+		mustNext(t, g)
+		g.opts.clock = second.NewFakeClock(epoch.Add(time.Second - 5*time.Millisecond))
+		if _, err := g.NextID(); !errors.Is(err, ErrClockRollback) {
+			t.Fatalf("err = %v, want ErrClockRollback", err)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		clk := second.NewFakeClock(epoch.Add(time.Second))
+		g := newTestGenerator(t, clk, WithRollback(RollbackWait, 10*time.Millisecond))
+		mustNext(t, g)
+
+		clk2 := second.NewFakeClock(epoch.Add(time.Second - 5*time.Millisecond))
+		g.opts.clock = clk2
+		got := make(chan Parts, 1)
+		go func() { got <- mustNext(t, g) }()
+		clk2.BlockUntil(1)
+		clk2.Advance(5 * time.Millisecond)
+		if p := <-got; p.Elapsed != 1000 || p.Sequence != 1 {
+			t.Fatalf("after wait = %+v", p)
+		}
+
+		// 超过max不等
+		g.opts.clock = second.NewFakeClock(epoch.Add(time.Second - 50*time.Millisecond))
+		if _, err := g.NextID(); !errors.Is(err, ErrClockRollback) {
+			t.Fatalf("err = %v, want ErrClockRollback", err)
+		}
+	})
+
+	t.Run("borrow", func(t *testing.T) {
+		clk := second.NewFakeClock(epoch.Add(time.Second))
+		g := newTestGenerator(t, clk, WithRollback(RollbackBorrow, 3*time.Millisecond))
+		last := mustNext(t, g)
+
+		clk2 := second.NewFakeClock(epoch.Add(time.Second - time.Millisecond))
+		g.opts.clock = clk2
+		// 接着用回拨前的时间, 序列号用完了借下一个, 不睡
+		for i := 0; i < 16; i++ {
+			p := mustNext(t, g)
+			if p.ID <= last.ID {
+				t.Fatalf("not monotonic: %+v after %+v", p, last)
+			}
+			last = p
+		}
+		if last.Elapsed != 1001 {
+			t.Fatalf("borrowed = %+v", last)
+		}
+		// 再借就超过3ms了(时钟在999, 要借到1003)
+		for i := 0; ; i++ {
+			p, err := g.NextID()
+			if err != nil {
+				if !errors.Is(err, ErrClockRollback) {
+					t.Fatal(err)
+				}
+				break
+			}
+			if i >= 16*2 {
+				t.Fatal("borrowed beyond max")
+			}
+			last = g.Decompose(p)
+		}
+		if last.Elapsed != 1002 {
+			t.Fatalf("last borrowed = %+v", last)
+		}
+		// 出错之后接着调也不能借, 直到时钟追上来
+		for i := 0; i < 200; i++ {
+			if _, err := g.NextID(); !errors.Is(err, ErrClockRollback) {
+				t.Fatalf("call %d after the limit: err = %v", i, err)
+			}
+		}
+		clk2.Advance(4 * time.Millisecond)
+		if p := mustNext(t, g); p.Elapsed != 1003 || p.Sequence != 0 || p.ID <= last.ID {
+			t.Fatalf("after the clock caught up = %+v, last %+v", p, last)
+		}
+	})
+}
+
+func Test_New_Errors(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	if _, err := New(WithClock(clk), WithStartTime(epoch.Add(time.Hour)), WithMachineID(Static(1))); !errors.Is(err, ErrStartTimeAhead) {
+		t.Fatalf("err = %v, want ErrStartTimeAhead", err)
+	}
+	if _, err := New(WithClock(clk), WithLayout(smallLayout), WithMachineID(Static(1024))); !errors.Is(err, ErrInvalidMachineID) {
+		t.Fatalf("err = %v, want ErrInvalidMachineID", err)
+	}
+	check := func(id uint64) bool { return id != 3 }
+	if _, err := New(WithClock(clk), WithMachineID(Static(3)), WithCheckMachineID(check)); !errors.Is(err, ErrInvalidMachineID) {
+		t.Fatalf("err = %v, want ErrInvalidMachineID", err)
+	}
+	boom := errors.New("boom")
+	if _, err := New(WithClock(clk), WithMachineID(ProviderFunc(func(int) (uint64, error) { return 0, boom }))); err != boom {
+		t.Fatalf("err = %v, want boom", err)
+	}
+}
+
+func Test_NextID_OverTimeLimit(t *testing.T) {
+	l := Layout{TimeUnit: time.Millisecond, TimeBits: 10, SequenceBits: 4, MachineBits: 4}
+	clk := second.NewFakeClock(epoch.Add(1024 * time.Millisecond))
+	g, err := New(WithLayout(l), WithStartTime(epoch), WithMachineID(Static(1)), WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.NextID(); !errors.Is(err, ErrOverTimeLimit) {
+		t.Fatalf("err = %v, want ErrOverTimeLimit", err)
+	}
+}
+
+// Test_NextID_Concurrent 多个go程同时取, 没有重复, 每个go程拿到的是递增的
+func Test_NextID_Concurrent(t *testing.T) {
+	g, err := New(WithLayout(smallLayout), WithStartTime(epoch), WithMachineID(Static(5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, perWorker = 8, 2000
+	results := make([][]uint64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ids := make([]uint64, 0, perWorker)
+			for i := 0; i < perWorker; i++ {
+				id, err := g.NextID()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids = append(ids, id)
+			}
+			results[w] = ids
+		}(w)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool, workers*perWorker)
+	for w, ids := range results {
+		for i, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate id %d: %+v", id, g.Decompose(id))
+			}
+			seen[id] = true
+			if i > 0 && id <= ids[i-1] {
+				t.Fatalf("worker %d: id %d after %d", w, id, ids[i-1])
+			}
+			if m := g.Decompose(id).Machine; m != 5 {
+				t.Fatalf("machine = %d", m)
+			}
+		}
+	}
+	if len(seen) != workers*perWorker {
+		t.Fatalf("got %d ids", len(seen))
+	}
+}
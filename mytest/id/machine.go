@@ -0,0 +1,76 @@
+package id
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrNoPrivateAddress 没有私有IPv4地址
+var ErrNoPrivateAddress = errors.New("id: no private ip address")
+
+// MachineIDProvider 机器ID的来源, bits是Layout.MachineBits
+type MachineIDProvider interface {
+	MachineID(bits int) (uint64, error)
+}
+
+// Validator MachineIDProvider可以实现, 每次NextID前检查机器ID还能不能用
+// 租约过期或者被别人拿走时返回错误, Generator就不再发ID
+type Validator interface {
+	Valid() error
+}
+
+// ProviderFunc 把函数当作MachineIDProvider
+type ProviderFunc func(bits int) (uint64, error)
+
+func (f ProviderFunc) MachineID(bits int) (uint64, error) {
+	return f(bits)
+}
+
+// Static 固定的机器ID, 比如从配置或者k8s StatefulSet的序号来
+func Static(id uint64) MachineIDProvider {
+	return ProviderFunc(func(int) (uint64, error) {
+		return id, nil
+	})
+}
+
+// FromIP 私有IPv4地址的低bits位, bits是16时和sonyflake的lower16BitPrivateIP一样
+// 两个容器IP的低位一样时会冲突, 见FileLease和lease包
+func FromIP() MachineIDProvider {
+	return FromIPAddrs(net.InterfaceAddrs)
+}
+
+// FromIPAddrs 测试时传假的网卡地址
+func FromIPAddrs(addrs func() ([]net.Addr, error)) MachineIDProvider {
+	return ProviderFunc(func(bits int) (uint64, error) {
+		ip, err := privateIPv4(addrs)
+		if err != nil {
+			return 0, err
+		}
+		v := uint64(ip[0])<<24 | uint64(ip[1])<<16 | uint64(ip[2])<<8 | uint64(ip[3])
+		return v & (1<<min(bits, 32) - 1), nil
+	})
+}
+
+func privateIPv4(addrs func() ([]net.Addr, error)) (net.IP, error) {
+	as, err := addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range as {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ip := ipnet.IP.To4(); isPrivateIPv4(ip) {
+			return ip, nil
+		}
+	}
+	return nil, ErrNoPrivateAddress
+}
+
+// isPrivateIPv4 RFC1918的私有地址和RFC3927的链路本地地址
+func isPrivateIPv4(ip net.IP) bool {
+	return ip != nil &&
+		(ip[0] == 10 || ip[0] == 172 && (ip[1] >= 16 && ip[1] < 32) || ip[0] == 192 && ip[1] == 168 || ip[0] == 169 && ip[1] == 254)
+}
@@ -0,0 +1,52 @@
+package id
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func addrs(cidrs ...string) func() ([]net.Addr, error) {
+	return func() ([]net.Addr, error) {
+		var as []net.Addr
+		for _, c := range cidrs {
+			ip, ipnet, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, err
+			}
+			ipnet.IP = ip
+			as = append(as, ipnet)
+		}
+		return as, nil
+	}
+}
+
+func Test_FromIPAddrs(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		bits  int
+		want  uint64
+		err   error
+	}{
+		{[]string{"127.0.0.1/8", "10.1.2.3/8"}, 16, 2<<8 | 3, nil},
+		{[]string{"192.168.10.20/24"}, 12, (10<<8 | 20) & 0xfff, nil},
+		{[]string{"172.16.0.9/12"}, 40, 172<<24 | 16<<16 | 9, nil},
+		// 公网地址和172.32不是私有地址
+		{[]string{"8.8.8.8/32", "172.32.0.1/16"}, 16, 0, ErrNoPrivateAddress},
+	}
+	for _, tt := range tests {
+		got, err := FromIPAddrs(addrs(tt.addrs...)).MachineID(tt.bits)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Fatalf("%v bits %d: got %d, %v, want %d, %v", tt.addrs, tt.bits, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// Test_FromIPAddrs_Collision 两个容器IP的低16位一样时机器ID相同, 这是lease包要解决的
+func Test_FromIPAddrs_Collision(t *testing.T) {
+	a, _ := FromIPAddrs(addrs("10.1.5.6/16")).MachineID(16)
+	b, _ := FromIPAddrs(addrs("10.2.5.6/16")).MachineID(16)
+	if a != b {
+		t.Fatalf("%d != %d", a, b)
+	}
+}
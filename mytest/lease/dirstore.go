@@ -0,0 +1,149 @@
+//go:build linux || darwin
+
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// lockPollInterval 目录锁被占着时多久再试一次
+const lockPollInterval = 10 * time.Millisecond
+
+// DirStore 租约存成目录下的文件, 内容是owner和过期时间, 改之前先flock整个目录
+// 和id.FileLease不一样, 进程卡住(而不是退出)时租约也会过期, 目录可以是多个容器挂载的同一个卷
+// 过期时间用的是各自的时钟, 共享目录的机器时钟要同步
+type DirStore struct {
+	dir   string
+	clock second.Clock
+}
+
+// NewDirStore clock为nil时用真实时间
+func NewDirStore(dir string, clock second.Clock) *DirStore {
+	if clock == nil {
+		clock = second.RealClock
+	}
+	return &DirStore{dir: dir, clock: clock}
+}
+
+func (d *DirStore) path(key string) string {
+	return filepath.Join(d.dir, url.PathEscape(key)+".lease")
+}
+
+// lock 持有目录锁执行fn, 同一个目录下的所有key共用一把锁
+// flock阻塞时没法取消, 所以用LOCK_NB轮询, 别的进程卡住不放锁时ctx到期就返回
+func (d *DirStore) lock(ctx context.Context, fn func() error) error {
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(d.dir, ".lock"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+		// 锁是别的进程拿着的, 和d.clock没关系, 用真实时间等
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+	// Close的时候内核会释放flock
+	return fn()
+}
+
+// read 读租约, 文件不存在或者已经过期时返回空
+func (d *DirStore) read(key string) (entry, error) {
+	b, err := os.ReadFile(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return entry{}, nil
+	}
+	if err != nil {
+		return entry{}, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		return entry{}, fmt.Errorf("lease: malformed lease file %s", d.path(key))
+	}
+	ns, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return entry{}, fmt.Errorf("lease: malformed lease file %s: %w", d.path(key), err)
+	}
+	e := entry{owner: lines[0], expireAt: time.Unix(0, ns)}
+	if !d.clock.Now().Before(e.expireAt) {
+		return entry{}, nil
+	}
+	return e, nil
+}
+
+// write 先写临时文件再rename, 进程中途挂了也不会留下写了一半的文件
+func (d *DirStore) write(key, owner string, ttl time.Duration) error {
+	path := d.path(key)
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%s\n%d\n", owner, d.clock.Now().Add(ttl).UnixNano())
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *DirStore) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := d.lock(ctx, func() error {
+		e, err := d.read(key)
+		if err != nil || e.owner != "" {
+			return err
+		}
+		if err := d.write(key, owner, ttl); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return ok, err
+}
+
+func (d *DirStore) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := d.lock(ctx, func() error {
+		e, err := d.read(key)
+		if err != nil || e.owner != owner {
+			return err
+		}
+		if err := d.write(key, owner, ttl); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	return ok, err
+}
+
+func (d *DirStore) Release(ctx context.Context, key, owner string) error {
+	return d.lock(ctx, func() error {
+		e, err := d.read(key)
+		if err != nil || e.owner != owner {
+			return err
+		}
+		return os.Remove(d.path(key))
+	})
+}
@@ -0,0 +1,286 @@
+package lease
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/sonyflake/sonyflake.md
+// sonyflake默认用私有IP的低16位当机器ID, k8s里不同网段的两个pod低16位一样时ID就会重复.
+// Coordinator从Store里租一个机器ID, 后台定时续期, 不需要单独的发号服务.
+// 续不上超过ttl或者被别人拿走之后Valid返回错误, 用它的id.Generator就不再发ID.
+
+var (
+	// ErrNoFreeMachineID 所有的机器ID都被租出去了
+	ErrNoFreeMachineID = errors.New("lease: no free machine id")
+	// ErrLeaseLost 租约过期了或者被别人拿走了, 这个机器ID不能再用
+	ErrLeaseLost = errors.New("lease: machine id lease lost")
+	// ErrNotAcquired 还没有调用过MachineID
+	ErrNotAcquired = errors.New("lease: machine id not acquired")
+	// ErrClosed Close之后不能再发ID
+	ErrClosed = errors.New("lease: coordinator closed")
+)
+
+type options struct {
+	prefix        string
+	owner         string
+	ttl           time.Duration
+	renewInterval time.Duration
+	maxID         uint64
+	timeout       time.Duration
+	clock         second.Clock
+}
+
+// Option Coordinator的选项
+type Option func(*options)
+
+// WithPrefix key的前缀, 默认"id:machine:", 不同的服务用不同的前缀就可以各自从0开始分
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithOwner 写进租约里的名字, 默认是 主机名-pid-随机数, 必须全局唯一
+func WithOwner(owner string) Option {
+	return func(o *options) {
+		o.owner = owner
+	}
+}
+
+// WithTTL 租约多久不续期就过期, 默认30秒
+// 进程挂掉之后要等这么久这个机器ID才能被别人用
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithRenewInterval 多久续期一次, 默认ttl的三分之一, 连着失败两次还有机会
+func WithRenewInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.renewInterval = d
+	}
+}
+
+// defaultMaxID 租机器ID是一个一个试的, 每个都要访问一次Store
+// 默认的16位试遍要65536次, 集群满了的时候要很久才返回ErrNoFreeMachineID
+const defaultMaxID = 1024
+
+// WithMaxID 最多试多少个机器ID, 默认1024, 0表示按位数试遍
+func WithMaxID(n uint64) Option {
+	return func(o *options) {
+		o.maxID = n
+	}
+}
+
+// WithTimeout 每次访问Store的超时, 默认2秒
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithClock 时钟, 测试时用FakeClock
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// Coordinator 从Store租机器ID, 实现了id.MachineIDProvider和id.Validator
+type Coordinator struct {
+	store Store
+	opts  options
+
+	mu       sync.Mutex
+	acquired bool
+	id       uint64
+	expireAt time.Time // 本地认为的过期时间, 从发请求之前算起, 比Store里的早一点
+	err      error     // 丢了或者关了的原因, 设置之后不会再变
+
+	done     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewCoordinator 创建Coordinator, 调用MachineID时才去Store里租
+func NewCoordinator(store Store, opts ...Option) *Coordinator {
+	o := options{
+		prefix:  "id:machine:",
+		ttl:     30 * time.Second,
+		maxID:   defaultMaxID,
+		timeout: 2 * time.Second,
+		clock:   second.RealClock,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.owner == "" {
+		o.owner = defaultOwner()
+	}
+	if o.renewInterval <= 0 {
+		o.renewInterval = o.ttl / 3
+	}
+	return &Coordinator{store: store, opts: o, done: make(chan struct{}), stop: make(chan struct{})}
+}
+
+func defaultOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+func (c *Coordinator) key(id uint64) string {
+	return c.opts.prefix + strconv.FormatUint(id, 10)
+}
+
+// Owner 写进租约里的名字
+func (c *Coordinator) Owner() string {
+	return c.opts.owner
+}
+
+// Done 租约丢了或者Close之后关闭, 可以用来让进程退出重启, 重启后会租到新的机器ID
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+// MachineID 实现id.MachineIDProvider, 从0开始租第一个空闲的机器ID, 租到之后开始续期
+// 再次调用返回同一个ID
+func (c *Coordinator) MachineID(bits int) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.acquired {
+		return c.id, nil
+	}
+
+	n := uint64(1) << bits
+	if c.opts.maxID > 0 && c.opts.maxID < n {
+		n = c.opts.maxID
+	}
+	for id := uint64(0); id < n; id++ {
+		start := c.opts.clock.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.timeout)
+		ok, err := c.store.Acquire(ctx, c.key(id), c.opts.owner, c.opts.ttl)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			c.acquired, c.id, c.expireAt = true, id, start.Add(c.opts.ttl)
+			c.wg.Add(1)
+			go c.heartbeat()
+			return id, nil
+		}
+	}
+	return 0, ErrNoFreeMachineID
+}
+
+// Valid 实现id.Validator, 租约过期, 被别人拿走或者Close之后返回错误
+// 过期是按本地时钟判断的, 续期的go程还没发现时这里也会拒绝
+func (c *Coordinator) Valid() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if !c.acquired {
+		return ErrNotAcquired
+	}
+	if !c.opts.clock.Now().Before(c.expireAt) {
+		c.loseLocked(fmt.Errorf("%w: expired at %s", ErrLeaseLost, c.expireAt.Format(time.RFC3339Nano)))
+		return c.err
+	}
+	return nil
+}
+
+// loseLocked 记下原因, 之后一直返回这个错误
+func (c *Coordinator) loseLocked(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
+
+func (c *Coordinator) heartbeat() {
+	defer c.wg.Done()
+	ticker := c.opts.clock.NewTicker(c.opts.renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C():
+		}
+		if !c.renew() {
+			return
+		}
+	}
+}
+
+// renew 续期一次, 租约丢了之后返回false
+func (c *Coordinator) renew() bool {
+	start := c.opts.clock.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.timeout)
+	ok, err := c.store.Renew(ctx, c.key(c.id), c.opts.owner, c.opts.ttl)
+	cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return false
+	}
+
+	switch {
+	case err != nil:
+		// 存储暂时不可用, 没过期之前接着试
+		log.Printf("lease: renew machine id %d: %v", c.id, err)
+		if !c.opts.clock.Now().Before(c.expireAt) {
+			c.loseLocked(fmt.Errorf("%w: %v", ErrLeaseLost, err))
+			return false
+		}
+		return true
+	case !ok:
+		log.Printf("lease: machine id %d is no longer owned by %s", c.id, c.opts.owner)
+		c.loseLocked(ErrLeaseLost)
+		return false
+	}
+	c.expireAt = start.Add(c.opts.ttl)
+	return true
+}
+
+// Close 停止续期并释放租约, 别人马上就可以用这个机器ID
+func (c *Coordinator) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.wg.Wait()
+
+	c.mu.Lock()
+	acquired := c.acquired
+	c.acquired = false
+	c.loseLocked(ErrClosed)
+	c.mu.Unlock()
+
+	if !acquired {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.timeout)
+	defer cancel()
+	return c.store.Release(ctx, c.key(c.id), c.opts.owner)
+}
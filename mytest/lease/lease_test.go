@@ -0,0 +1,237 @@
+//go:build linux || darwin
+
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/id"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// countingStore 记录续期了多少次, 用来等续期的go程跑完一轮
+type countingStore struct {
+	Store
+	renews int32
+}
+
+func (s *countingStore) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	defer atomic.AddInt32(&s.renews, 1)
+	return s.Store.Renew(ctx, key, owner, ttl)
+}
+
+func (s *countingStore) count() int {
+	return int(atomic.LoadInt32(&s.renews))
+}
+
+// tick 推进一个续期间隔, 等续期完成
+func tick(t *testing.T, clk *second.FakeClock, s *countingStore, d time.Duration) {
+	t.Helper()
+	n := s.count()
+	clk.BlockUntil(1)
+	clk.Advance(d)
+	waitFor(t, func() bool { return s.count() > n })
+}
+
+func newTestCoordinator(store Store, clk second.Clock, owner string) *Coordinator {
+	return NewCoordinator(store, WithOwner(owner), WithTTL(3*time.Second), WithRenewInterval(time.Second),
+		WithMaxID(2), WithClock(clk))
+}
+
+func Test_Coordinator_MachineID(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	for name, s := range stores(t, clk) {
+		t.Run(name, func(t *testing.T) {
+			a := newTestCoordinator(s, clk, "a")
+			b := newTestCoordinator(s, clk, "b")
+			c := newTestCoordinator(s, clk, "c")
+			defer c.Close()
+
+			if err := a.Valid(); !errors.Is(err, ErrNotAcquired) {
+				t.Fatalf("err = %v, want ErrNotAcquired", err)
+			}
+			idA, err := a.MachineID(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			idB, err := b.MachineID(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idA != 0 || idB != 1 {
+				t.Fatalf("ids = %d %d, want 0 1", idA, idB)
+			}
+			if again, _ := a.MachineID(10); again != idA {
+				t.Fatalf("second MachineID = %d, want %d", again, idA)
+			}
+			if _, err := c.MachineID(10); !errors.Is(err, ErrNoFreeMachineID) {
+				t.Fatalf("err = %v, want ErrNoFreeMachineID", err)
+			}
+
+			// Close之后马上释放, 不用等过期
+			if err := a.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Valid(); !errors.Is(err, ErrClosed) {
+				t.Fatalf("err = %v, want ErrClosed", err)
+			}
+			if id, err := c.MachineID(10); err != nil || id != 0 {
+				t.Fatalf("after release: %d, %v", id, err)
+			}
+			b.Close()
+		})
+	}
+}
+
+func Test_Coordinator_Renew(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	store := &countingStore{Store: NewMemoryStore(clk)}
+	c := newTestCoordinator(store, clk, "a")
+	defer c.Close()
+	if _, err := c.MachineID(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// 过了好几个ttl, 一直在续期, 租约还在
+	for i := 0; i < 10; i++ {
+		tick(t, clk, store, time.Second)
+	}
+	if err := c.Valid(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Acquire(context.Background(), "id:machine:0", "b", time.Second); ok {
+		t.Fatal("b acquired a renewed lease")
+	}
+}
+
+// Test_Coordinator_StoreFailing 存储不可用时没过期之前接着发ID, 过期之后不发
+func Test_Coordinator_StoreFailing(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	mem := NewMemoryStore(clk)
+	store := &countingStore{Store: mem}
+	c := newTestCoordinator(store, clk, "a")
+	defer c.Close()
+	g, err := id.New(id.WithStartTime(epoch.Add(-time.Hour)), id.WithMachineID(c), id.WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem.SetFailing(true)
+	tick(t, clk, store, time.Second)
+	tick(t, clk, store, time.Second)
+	if _, err := g.NextID(); err != nil {
+		t.Fatalf("NextID before expire: %v", err)
+	}
+
+	tick(t, clk, store, time.Second)
+	if _, err := g.NextID(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("err = %v, want ErrLeaseLost", err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("Done not closed")
+	}
+
+	// 存储恢复了也不能再用, 这段时间里别人可能已经拿到了
+	mem.SetFailing(false)
+	if _, err := g.NextID(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("err after recover = %v, want ErrLeaseLost", err)
+	}
+}
+
+// Test_Coordinator_ExpireBeforeRenew 续期的go程卡住时Valid自己按时间判断
+func Test_Coordinator_ExpireBeforeRenew(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	c := NewCoordinator(NewMemoryStore(clk), WithTTL(3*time.Second), WithRenewInterval(time.Hour), WithClock(clk))
+	defer c.Close()
+	if _, err := c.MachineID(10); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(2 * time.Second)
+	if err := c.Valid(); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	if err := c.Valid(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("err = %v, want ErrLeaseLost", err)
+	}
+}
+
+// Test_Coordinator_Stolen 租约被别人拿走之后下一次续期就发现
+func Test_Coordinator_Stolen(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	mem := NewMemoryStore(clk)
+	store := &countingStore{Store: mem}
+	c := newTestCoordinator(store, clk, "a")
+	defer c.Close()
+	g, err := id.New(id.WithStartTime(epoch.Add(-time.Hour)), id.WithMachineID(c), id.WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.NextID(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	mem.Release(ctx, "id:machine:0", "a")
+	if ok, _ := mem.Acquire(ctx, "id:machine:0", "b", time.Minute); !ok {
+		t.Fatal("b acquire failed")
+	}
+
+	tick(t, clk, store, time.Second)
+	if _, err := g.NextID(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("err = %v, want ErrLeaseLost", err)
+	}
+	// Close不会删掉b的租约
+	c.Close()
+	if owner := mem.Owner("id:machine:0"); owner != "b" {
+		t.Fatalf("owner = %q, want b", owner)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+// fullStore 所有的机器ID都被占着
+type fullStore struct {
+	Store
+	acquires int
+}
+
+func (s *fullStore) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	s.acquires++
+	return false, nil
+}
+
+// Test_Coordinator_DefaultMaxID 默认最多试1024个, 不会按16位试65536次
+func Test_Coordinator_DefaultMaxID(t *testing.T) {
+	s := &fullStore{}
+	c := NewCoordinator(s, WithClock(second.NewFakeClock(epoch)))
+	if _, err := c.MachineID(16); !errors.Is(err, ErrNoFreeMachineID) {
+		t.Fatalf("err = %v, want ErrNoFreeMachineID", err)
+	}
+	if s.acquires != defaultMaxID {
+		t.Fatalf("tried %d ids, want %d", s.acquires, defaultMaxID)
+	}
+
+	s.acquires = 0
+	c = NewCoordinator(s, WithClock(second.NewFakeClock(epoch)), WithMaxID(0))
+	c.MachineID(12)
+	if s.acquires != 1<<12 {
+		t.Fatalf("WithMaxID(0) tried %d ids, want %d", s.acquires, 1<<12)
+	}
+}
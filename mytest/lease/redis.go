@@ -0,0 +1,56 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/guonaihong/question/mytest/limit"
+)
+
+// 续期和释放都要先比较owner, 用lua脚本保证原子
+// 租约过期被别人拿走之后, 原来的owner不能把别人的租约续上或者删掉
+const (
+	renewScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`
+
+	releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0`
+)
+
+// RedisStore 租约存在redis(或者兼容redis协议的存储)里, 可以跨机器用
+type RedisStore struct {
+	client *limit.RedisStore
+}
+
+// NewRedisStore client可以和限流共用一个连接池
+func NewRedisStore(client *limit.RedisStore) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	_, err := s.client.Do(ctx, "SET", key, owner, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	// 被占着的时候SET NX回nil
+	if errors.Is(err, limit.ErrNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *RedisStore) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	resp, err := s.client.Do(ctx, "EVAL", renewScript, "1", key, owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	return resp == int64(1), nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key, owner string) error {
+	_, err := s.client.Do(ctx, "EVAL", releaseScript, "1", key, owner)
+	return err
+}
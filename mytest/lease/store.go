@@ -0,0 +1,114 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// ErrStoreFailing MemoryStore.SetFailing(true)之后所有操作都返回这个错误
+var ErrStoreFailing = errors.New("lease: store is failing")
+
+// Store 保存租约的地方, 每个方法都必须是原子的
+// 过期的租约和不存在一样, 谁都可以拿
+type Store interface {
+	// Acquire key没有被占用时写入owner, ttl后过期, 被别人占着时返回false
+	Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Renew key还是owner的时候把过期时间推到ttl之后, 已经过期或者被别人拿走时返回false
+	Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Release key还是owner的时候删掉, 不是的时候什么也不做
+	Release(ctx context.Context, key, owner string) error
+}
+
+type entry struct {
+	owner    string
+	expireAt time.Time
+}
+
+// MemoryStore 进程内的Store, 测试用, 也可以给同一个进程里的多个Generator分ID
+type MemoryStore struct {
+	mu      sync.Mutex
+	clock   second.Clock
+	entries map[string]entry
+	failing int32
+}
+
+// NewMemoryStore clock为nil时用真实时间
+func NewMemoryStore(clock second.Clock) *MemoryStore {
+	if clock == nil {
+		clock = second.RealClock
+	}
+	return &MemoryStore{clock: clock, entries: make(map[string]entry)}
+}
+
+// SetFailing 为true时所有操作都返回ErrStoreFailing, 模拟存储不可用
+func (m *MemoryStore) SetFailing(failing bool) {
+	v := int32(0)
+	if failing {
+		v = 1
+	}
+	atomic.StoreInt32(&m.failing, v)
+}
+
+// Owner 现在谁占着key, 没有或者过期了返回空
+func (m *MemoryStore) Owner(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, _ := m.getLocked(key)
+	return e.owner
+}
+
+func (m *MemoryStore) getLocked(key string) (entry, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return entry{}, false
+	}
+	if !m.clock.Now().Before(e.expireAt) {
+		delete(m.entries, key)
+		return entry{}, false
+	}
+	return e, true
+}
+
+func (m *MemoryStore) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	if atomic.LoadInt32(&m.failing) == 1 {
+		return false, ErrStoreFailing
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.getLocked(key); ok {
+		return false, nil
+	}
+	m.entries[key] = entry{owner: owner, expireAt: m.clock.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryStore) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	if atomic.LoadInt32(&m.failing) == 1 {
+		return false, ErrStoreFailing
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.getLocked(key)
+	if !ok || e.owner != owner {
+		return false, nil
+	}
+	m.entries[key] = entry{owner: owner, expireAt: m.clock.Now().Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key, owner string) error {
+	if atomic.LoadInt32(&m.failing) == 1 {
+		return ErrStoreFailing
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.getLocked(key); ok && e.owner == owner {
+		delete(m.entries, key)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+//go:build linux || darwin
+
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/limit"
+	"github.com/guonaihong/question/mytest/limit/limittest"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// registerFakeScripts 让FakeRedis认识redis.go里的lua脚本
+func registerFakeScripts(f *limittest.FakeRedis) {
+	f.RegisterScript(renewScript, func(call func(args ...string) any, keys, argv []string) any {
+		if call("GET", keys[0]) == argv[0] {
+			return call("PEXPIRE", keys[0], argv[1])
+		}
+		return int64(0)
+	})
+	f.RegisterScript(releaseScript, func(call func(args ...string) any, keys, argv []string) any {
+		if call("GET", keys[0]) == argv[0] {
+			return call("DEL", keys[0])
+		}
+		return int64(0)
+	})
+}
+
+// 三种存储跑同一组用例
+func stores(t *testing.T, clk second.Clock) map[string]Store {
+	t.Helper()
+	fake, err := limittest.NewFakeRedis(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerFakeScripts(fake)
+	client := limit.NewRedisStore(fake.Addr())
+	t.Cleanup(func() {
+		client.Close()
+		fake.Close()
+	})
+
+	return map[string]Store{
+		"memory": NewMemoryStore(clk),
+		"dir":    NewDirStore(t.TempDir(), clk),
+		"redis":  NewRedisStore(client),
+	}
+}
+
+func Test_Store(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	ctx := context.Background()
+	for name, s := range stores(t, clk) {
+		t.Run(name, func(t *testing.T) {
+			ttl := 3 * time.Second
+			if ok, err := s.Acquire(ctx, "k", "a", ttl); err != nil || !ok {
+				t.Fatalf("a acquire = %v, %v", ok, err)
+			}
+			if ok, err := s.Acquire(ctx, "k", "b", ttl); err != nil || ok {
+				t.Fatalf("b acquire while held = %v, %v", ok, err)
+			}
+			if ok, err := s.Renew(ctx, "k", "b", ttl); err != nil || ok {
+				t.Fatalf("b renew = %v, %v", ok, err)
+			}
+
+			// 续期之后按新的时间过期
+			clk.Advance(2 * time.Second)
+			if ok, err := s.Renew(ctx, "k", "a", ttl); err != nil || !ok {
+				t.Fatalf("a renew = %v, %v", ok, err)
+			}
+			clk.Advance(2 * time.Second)
+			if ok, _ := s.Acquire(ctx, "k", "b", ttl); ok {
+				t.Fatal("b acquired a renewed lease")
+			}
+
+			// 过期之后a续不上, b可以拿
+			clk.Advance(time.Second)
+			if ok, err := s.Renew(ctx, "k", "a", ttl); err != nil || ok {
+				t.Fatalf("a renew after expire = %v, %v", ok, err)
+			}
+			if ok, err := s.Acquire(ctx, "k", "b", ttl); err != nil || !ok {
+				t.Fatalf("b acquire after expire = %v, %v", ok, err)
+			}
+
+			// a不能释放b的租约
+			if err := s.Release(ctx, "k", "a"); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := s.Acquire(ctx, "k", "a", ttl); ok {
+				t.Fatal("a released b's lease")
+			}
+			if err := s.Release(ctx, "k", "b"); err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := s.Acquire(ctx, "k", "a", ttl); err != nil || !ok {
+				t.Fatalf("a acquire after release = %v, %v", ok, err)
+			}
+			s.Release(ctx, "k", "a")
+		})
+	}
+}
+
+// Test_DirStore_LockContext 别的进程一直拿着目录锁时, ctx到期就返回
+func Test_DirStore_LockContext(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.OpenFile(filepath.Join(dir, ".lock"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewDirStore(dir, second.NewFakeClock(epoch))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(ctx, "k", "a", time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire while locked err = %v, want DeadlineExceeded", err)
+	}
+
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	if ok, err := s.Acquire(context.Background(), "k", "a", time.Second); err != nil || !ok {
+		t.Fatalf("acquire after unlock = %v, %v", ok, err)
+	}
+}
+
+// Test_RedisStore_Scripts 上面的redis用例跑的是registerFakeScripts里的go代码, lua脚本本身要在真的redis上跑
+// 设置REDIS_ADDR=127.0.0.1:6379时运行, 不测过期(redis用的是真实时间)
+func Test_RedisStore_Scripts(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	client := limit.NewRedisStore(addr)
+	defer client.Close()
+	ctx := context.Background()
+	if !client.Ping(ctx) {
+		t.Fatalf("no redis at %s", addr)
+	}
+	s := NewRedisStore(client)
+	key := fmt.Sprintf("leasetest:%d", time.Now().UnixNano())
+	defer client.Do(ctx, "DEL", key)
+
+	pttl := func() int64 {
+		t.Helper()
+		v, err := client.Do(ctx, "PTTL", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.(int64)
+	}
+
+	if ok, err := s.Acquire(ctx, key, "a", time.Second); err != nil || !ok {
+		t.Fatalf("a acquire = %v, %v", ok, err)
+	}
+	if ok, err := s.Renew(ctx, key, "b", time.Hour); err != nil || ok {
+		t.Fatalf("b renew = %v, %v", ok, err)
+	}
+	if ms := pttl(); ms > 1000 {
+		t.Fatalf("b's renew changed the ttl to %dms", ms)
+	}
+	if ok, err := s.Renew(ctx, key, "a", time.Hour); err != nil || !ok {
+		t.Fatalf("a renew = %v, %v", ok, err)
+	}
+	if ms := pttl(); ms <= 1000 {
+		t.Fatalf("ttl after renew = %dms", ms)
+	}
+
+	if err := s.Release(ctx, key, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Acquire(ctx, key, "b", time.Second); ok {
+		t.Fatal("b released a's lease")
+	}
+	if err := s.Release(ctx, key, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Acquire(ctx, key, "b", time.Second); err != nil || !ok {
+		t.Fatalf("b acquire after release = %v, %v", ok, err)
+	}
+}
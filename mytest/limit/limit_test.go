@@ -0,0 +1,227 @@
+package limit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/limit/limittest"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newFakeRedis 让FakeRedis认识本包的lua脚本, 用MemoryStore执行同样的逻辑
+func newFakeRedis(t *testing.T, clk second.Clock) *limittest.FakeRedis {
+	t.Helper()
+	fake, err := limittest.NewFakeRedis(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+
+	m := NewMemoryStore(clk)
+	ctx := context.Background()
+	nums := func(argv []string) []int64 {
+		n := make([]int64, len(argv))
+		for i, a := range argv {
+			n[i], _ = strconv.ParseInt(a, 10, 64)
+		}
+		return n
+	}
+	fake.RegisterScript(tokenScript, func(_ func(args ...string) any, keys, argv []string) any {
+		n := nums(argv)
+		if ok, _ := m.TokenTake(ctx, keys[0], keys[1], int(n[0]), int(n[1]), n[2], int(n[3])); ok {
+			return int64(1)
+		}
+		// lua的false转成nil
+		return nil
+	})
+	fake.RegisterScript(periodScript, func(_ func(args ...string) any, keys, argv []string) any {
+		n := nums(argv)
+		code, _ := m.PeriodTake(ctx, keys[0], int(n[0]), int(n[1]))
+		return int64(code)
+	})
+	fake.RegisterScript(slidingWindowScript, func(_ func(args ...string) any, keys, argv []string) any {
+		n := nums(argv)
+		code, _ := m.SlidingWindowTake(ctx, keys[0], keys[1], int(n[0]),
+			time.Duration(n[1])*time.Millisecond, time.Duration(n[2])*time.Millisecond)
+		return int64(code)
+	})
+	fake.RegisterScript(slidingLogScript, func(_ func(args ...string) any, keys, argv []string) any {
+		n := nums(argv)
+		code, _ := m.SlidingLogTake(ctx, keys[0], int(n[0]),
+			time.Duration(n[1])*time.Millisecond, time.UnixMilli(n[2]))
+		return int64(code)
+	})
+	return fake
+}
+
+// 内存存储和redis存储跑同一组用例
+func stores(t *testing.T, clk second.Clock) map[string]Store {
+	t.Helper()
+	rs := NewRedisStore(newFakeRedis(t, clk).Addr())
+	t.Cleanup(func() { rs.Close() })
+
+	return map[string]Store{
+		"memory": NewMemoryStore(clk),
+		"redis":  rs,
+	}
+}
+
+func Test_TokenLimiter(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	for name, store := range stores(t, clk) {
+		t.Run(name, func(t *testing.T) {
+			l, err := NewTokenLimiter(2, 5, store, "tokenlimit", WithClock(clk))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			now := clk.Now()
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				if l.AllowN(now, 1) {
+					allowed++
+				}
+			}
+			// 桶一开始是满的
+			if allowed != 5 {
+				t.Fatalf("allowed %d", allowed)
+			}
+
+			// 一秒补2个
+			now = now.Add(time.Second)
+			if !l.AllowN(now, 2) {
+				t.Fatal("should allow 2 after one second")
+			}
+			if l.AllowN(now, 1) {
+				t.Fatal("bucket should be empty")
+			}
+			if !l.StoreAlive() {
+				t.Fatal("store should be alive")
+			}
+		})
+	}
+}
+
+func Test_TokenLimiter_Rescue(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	fake := newFakeRedis(t, clk)
+	rs := NewRedisStore(fake.Addr())
+	defer rs.Close()
+
+	l, err := NewTokenLimiter(1, 3, rs, "rescue", WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetFailing(true)
+
+	// redis挂了, 本地令牌桶兜底, 突发量还是3
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("rescue limiter allowed %d", allowed)
+	}
+	if l.StoreAlive() {
+		t.Fatal("store should be marked dead")
+	}
+
+	// 健康检查还是失败
+	clk.BlockUntil(1)
+	clk.Advance(pingInterval)
+	time.Sleep(10 * time.Millisecond)
+	if l.StoreAlive() {
+		t.Fatal("store is still failing")
+	}
+
+	fake.SetFailing(false)
+	clk.BlockUntil(1)
+	clk.Advance(pingInterval)
+	waitFor(t, l.StoreAlive)
+}
+
+func Test_PeriodLimit(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	for name, store := range stores(t, clk) {
+		t.Run(name, func(t *testing.T) {
+			l := NewPeriodLimit(1, 3, store, "periodlimit:", WithClock(clk))
+
+			want := []int{Allowed, Allowed, HitQuota, OverQuota, OverQuota}
+			for i, w := range want {
+				code, err := l.Take("first")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if code != w {
+					t.Fatalf("take %d: code %d, want %d", i, code, w)
+				}
+			}
+
+			// 窗口过期后重新计数
+			clk.Advance(time.Second)
+			if code, _ := l.Take("first"); code != Allowed {
+				t.Fatalf("code %d after the window expired", code)
+			}
+		})
+	}
+}
+
+func Test_PeriodLimit_Align(t *testing.T) {
+	clk := second.NewFakeClock(epoch.Add(40 * time.Second))
+	l := NewPeriodLimit(60, 1, NewMemoryStore(clk), "align:", WithClock(clk), Align())
+	if got := l.calcExpireSeconds(); got != 20 {
+		t.Fatalf("expire %d, want 20", got)
+	}
+
+	l.Take("sms")
+	if code, _ := l.Take("sms"); code != OverQuota {
+		t.Fatalf("code %d", code)
+	}
+	// 对齐之后20秒就到下一个窗口了
+	clk.Advance(20 * time.Second)
+	if code, _ := l.Take("sms"); code != HitQuota {
+		t.Fatalf("code %d in the next window", code)
+	}
+}
+
+func Test_PeriodLimit_Rescue(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	fake := newFakeRedis(t, clk)
+	rs := NewRedisStore(fake.Addr())
+	defer rs.Close()
+
+	l := NewPeriodLimit(1, 2, rs, "rescue:", WithClock(clk))
+	fake.SetFailing(true)
+
+	if code, err := l.Take("k"); err != nil || code != Allowed {
+		t.Fatalf("code %d, err %v", code, err)
+	}
+	if l.StoreAlive() {
+		t.Fatal("store should be marked dead")
+	}
+	if code, _ := l.Take("k"); code != HitQuota {
+		t.Fatalf("local fallback code %d", code)
+	}
+
+	fake.SetFailing(false)
+	clk.BlockUntil(1)
+	clk.Advance(pingInterval)
+	waitFor(t, l.StoreAlive)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
@@ -0,0 +1,267 @@
+// Package limittest 测试用的进程内redis, 给limit和用了limit.RedisStore的包写测试
+package limittest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// FakeRedis 进程内的RESP服务, 不需要真的redis
+// 支持PING, GET, SET(NX/XX/PX), DEL, PEXPIRE, 过期时间按clock算
+// EVAL只认识用RegisterScript注册过的脚本
+type FakeRedis struct {
+	ln      net.Listener
+	clock   second.Clock
+	failing int32
+	wg      sync.WaitGroup
+
+	// 和redis一样一条命令执行完再执行下一条, 脚本里的多条命令也是原子的
+	execMu  sync.Mutex
+	items   map[string]item
+	scripts map[string]ScriptFunc
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+type item struct {
+	value    string
+	expireAt time.Time // 零值表示不过期
+}
+
+// ScriptFunc 用go写的lua脚本, call相当于redis.call, 返回值和lua脚本的返回值一样:
+// nil, int64, string, []any, 或者RedisError
+type ScriptFunc func(call func(args ...string) any, keys, argv []string) any
+
+// NewFakeRedis 在127.0.0.1的随机端口上启动, clock为nil时用真实时间
+func NewFakeRedis(clock second.Clock) (*FakeRedis, error) {
+	if clock == nil {
+		clock = second.RealClock
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FakeRedis{
+		ln:      ln,
+		clock:   clock,
+		items:   make(map[string]item),
+		scripts: make(map[string]ScriptFunc),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	f.wg.Add(1)
+	go f.serve()
+	return f, nil
+}
+
+// Addr 监听地址
+func (f *FakeRedis) Addr() string {
+	return f.ln.Addr().String()
+}
+
+// SetFailing 为true时所有命令都返回错误, 模拟redis不可用
+func (f *FakeRedis) SetFailing(failing bool) {
+	v := int32(0)
+	if failing {
+		v = 1
+	}
+	atomic.StoreInt32(&f.failing, v)
+}
+
+// RegisterScript 让EVAL认识script, 执行时调用fn
+func (f *FakeRedis) RegisterScript(script string, fn ScriptFunc) {
+	f.execMu.Lock()
+	defer f.execMu.Unlock()
+	f.scripts[script] = fn
+}
+
+// Close 停止服务并断开所有连接
+func (f *FakeRedis) Close() error {
+	err := f.ln.Close()
+	f.mu.Lock()
+	for c := range f.conns {
+		c.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
+
+func (f *FakeRedis) serve() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns[conn] = struct{}{}
+		f.mu.Unlock()
+
+		f.wg.Add(1)
+		go f.handle(conn)
+	}
+}
+
+func (f *FakeRedis) handle(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+		f.wg.Done()
+	}()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if err := writeValue(w, f.exec(cmd)); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *FakeRedis) exec(cmd []string) any {
+	if atomic.LoadInt32(&f.failing) == 1 {
+		return RedisError("ERR fake redis is failing")
+	}
+
+	f.execMu.Lock()
+	defer f.execMu.Unlock()
+	return f.call(cmd)
+}
+
+// call 执行一条命令, 调用方持有execMu
+func (f *FakeRedis) call(cmd []string) any {
+	switch strings.ToUpper(cmd[0]) {
+	case "PING":
+		return statusReply("PONG")
+	case "GET":
+		if len(cmd) != 2 {
+			return wrongArgs(cmd[0])
+		}
+		it, ok := f.get(cmd[1])
+		if !ok {
+			return nil
+		}
+		return it.value
+	case "SET":
+		return f.set(cmd)
+	case "DEL":
+		if len(cmd) < 2 {
+			return wrongArgs(cmd[0])
+		}
+		var n int64
+		for _, key := range cmd[1:] {
+			if _, ok := f.get(key); ok {
+				delete(f.items, key)
+				n++
+			}
+		}
+		return n
+	case "PEXPIRE":
+		if len(cmd) != 3 {
+			return wrongArgs(cmd[0])
+		}
+		ms, err := strconv.ParseInt(cmd[2], 10, 64)
+		if err != nil {
+			return RedisError("ERR value is not an integer or out of range")
+		}
+		it, ok := f.get(cmd[1])
+		if !ok {
+			return int64(0)
+		}
+		it.expireAt = f.clock.Now().Add(time.Duration(ms) * time.Millisecond)
+		f.items[cmd[1]] = it
+		return int64(1)
+	case "EVAL":
+		if len(cmd) < 3 {
+			return wrongArgs(cmd[0])
+		}
+		numKeys, err := strconv.Atoi(cmd[2])
+		if err != nil || numKeys < 0 || len(cmd) < 3+numKeys {
+			return RedisError("ERR invalid number of keys")
+		}
+		fn, ok := f.scripts[cmd[1]]
+		if !ok {
+			return RedisError("NOSCRIPT fake redis does not know this script")
+		}
+		return fn(func(args ...string) any { return f.call(args) }, cmd[3:3+numKeys], cmd[3+numKeys:])
+	}
+	return RedisError(fmt.Sprintf("ERR unknown command '%s'", cmd[0]))
+}
+
+// get 过期的key顺便删掉
+func (f *FakeRedis) get(key string) (item, bool) {
+	it, ok := f.items[key]
+	if !ok {
+		return it, false
+	}
+	if !it.expireAt.IsZero() && !f.clock.Now().Before(it.expireAt) {
+		delete(f.items, key)
+		return it, false
+	}
+	return it, true
+}
+
+// set SET key value [NX|XX] [PX ms]
+func (f *FakeRedis) set(cmd []string) any {
+	if len(cmd) < 3 {
+		return wrongArgs(cmd[0])
+	}
+	var nx, xx bool
+	var ttl time.Duration
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToUpper(cmd[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "PX":
+			if i+1 >= len(cmd) {
+				return RedisError("ERR syntax error")
+			}
+			ms, err := strconv.ParseInt(cmd[i+1], 10, 64)
+			if err != nil || ms <= 0 {
+				return RedisError("ERR invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(ms) * time.Millisecond
+			i++
+		default:
+			return RedisError("ERR syntax error")
+		}
+	}
+	if nx && xx {
+		return RedisError("ERR syntax error")
+	}
+
+	_, exists := f.get(cmd[1])
+	if nx && exists || xx && !exists {
+		return nil
+	}
+	it := item{value: cmd[2]}
+	if ttl > 0 {
+		it.expireAt = f.clock.Now().Add(ttl)
+	}
+	f.items[cmd[1]] = it
+	return statusReply("OK")
+}
+
+func wrongArgs(cmd string) RedisError {
+	return RedisError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
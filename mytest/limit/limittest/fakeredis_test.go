@@ -0,0 +1,170 @@
+package limittest
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// client 测试里直接用RESP发命令, 不能引用limit包, 不然limit的测试会循环引用
+type client struct {
+	t *testing.T
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newClient(t *testing.T, f *FakeRedis) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", f.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+func (c *client) do(args ...string) any {
+	c.t.Helper()
+	cmd := make([]any, len(args))
+	for i, a := range args {
+		cmd[i] = a
+	}
+	if err := writeValue(c.w, cmd); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.t.Fatal(err)
+	}
+	v, err := readValue(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return v
+}
+
+func newTestFake(t *testing.T, clk second.Clock) *FakeRedis {
+	t.Helper()
+	fake, err := NewFakeRedis(clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	return fake
+}
+
+func Test_FakeRedis_Commands(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	c := newClient(t, newTestFake(t, clk))
+
+	if v := c.do("PING"); v != "PONG" {
+		t.Fatalf("PING = %v", v)
+	}
+	if v := c.do("SET", "k", "a", "NX", "PX", "1000"); v != "OK" {
+		t.Fatalf("SET NX = %v", v)
+	}
+	if v := c.do("SET", "k", "b", "NX"); v != nil {
+		t.Fatalf("second SET NX = %v, want nil", v)
+	}
+	if v := c.do("GET", "k"); v != "a" {
+		t.Fatalf("GET = %v, want a", v)
+	}
+
+	// PEXPIRE续期之后原来的过期时间不算了
+	clk.Advance(800 * time.Millisecond)
+	if v := c.do("PEXPIRE", "k", "1000"); v != int64(1) {
+		t.Fatalf("PEXPIRE = %v, want 1", v)
+	}
+	clk.Advance(800 * time.Millisecond)
+	if v := c.do("GET", "k"); v != "a" {
+		t.Fatalf("GET after renew = %v, want a", v)
+	}
+	clk.Advance(200 * time.Millisecond)
+	if v := c.do("GET", "k"); v != nil {
+		t.Fatalf("GET after expire = %v, want nil", v)
+	}
+	if v := c.do("PEXPIRE", "k", "1000"); v != int64(0) {
+		t.Fatalf("PEXPIRE on missing key = %v, want 0", v)
+	}
+
+	if v := c.do("SET", "k", "b", "XX"); v != nil {
+		t.Fatalf("SET XX on missing key = %v, want nil", v)
+	}
+	c.do("SET", "k", "b")
+	if v := c.do("DEL", "k", "missing"); v != int64(1) {
+		t.Fatalf("DEL = %v, want 1", v)
+	}
+
+	for _, cmd := range [][]string{
+		{"SET", "k", "b", "PX", "0"},
+		{"SET", "k", "b", "NX", "XX"},
+		{"GET"},
+		{"NOPE"},
+	} {
+		if _, ok := c.do(cmd...).(RedisError); !ok {
+			t.Fatalf("%v should fail", cmd)
+		}
+	}
+}
+
+func Test_FakeRedis_RegisterScript(t *testing.T) {
+	fake := newTestFake(t, second.NewFakeClock(epoch))
+	c := newClient(t, fake)
+
+	const script = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+	fake.RegisterScript(script, func(call func(args ...string) any, keys, argv []string) any {
+		if call("GET", keys[0]) == argv[0] {
+			return call("DEL", keys[0])
+		}
+		return int64(0)
+	})
+
+	c.do("SET", "k", "a")
+	if v := c.do("EVAL", script, "1", "k", "b"); v != int64(0) {
+		t.Fatalf("EVAL with wrong value = %v, want 0", v)
+	}
+	if v := c.do("EVAL", script, "1", "k", "a"); v != int64(1) {
+		t.Fatalf("EVAL = %v, want 1", v)
+	}
+	if _, ok := c.do("EVAL", "return 1", "0").(RedisError); !ok {
+		t.Fatal("unknown script should fail")
+	}
+}
+
+// Test_FakeRedis_ArrayError 脚本返回的数组里有错误时, 后面的元素照样读出来
+func Test_FakeRedis_ArrayError(t *testing.T) {
+	fake := newTestFake(t, second.NewFakeClock(epoch))
+	c := newClient(t, fake)
+
+	fake.RegisterScript("multi", func(call func(args ...string) any, keys, argv []string) any {
+		return []any{call("NOPE"), nil, "x", int64(1)}
+	})
+	arr, ok := c.do("EVAL", "multi", "0").([]any)
+	if !ok || len(arr) != 4 {
+		t.Fatalf("EVAL = %v", arr)
+	}
+	if _, ok := arr[0].(RedisError); !ok || arr[1] != nil || arr[2] != "x" || arr[3] != int64(1) {
+		t.Fatalf("EVAL = %#v", arr)
+	}
+	if v := c.do("PING"); v != "PONG" {
+		t.Fatalf("PING after array = %v", v)
+	}
+}
+
+func Test_FakeRedis_Failing(t *testing.T) {
+	fake := newTestFake(t, second.NewFakeClock(epoch))
+	c := newClient(t, fake)
+
+	fake.SetFailing(true)
+	if _, ok := c.do("PING").(RedisError); !ok {
+		t.Fatal("PING should fail")
+	}
+	fake.SetFailing(false)
+	if v := c.do("PING"); v != "PONG" {
+		t.Fatalf("PING = %v", v)
+	}
+}
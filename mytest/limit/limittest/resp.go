@@ -0,0 +1,115 @@
+package limittest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// 服务端用的RESP2, 读命令和写回复, 不依赖limit包, limit自己的测试也能用
+
+// RedisError 错误回复, ScriptFunc返回它时客户端收到 -ERR ...
+type RedisError string
+
+func (e RedisError) Error() string { return string(e) }
+
+type statusReply string
+
+// readValue 读一个值, 数组里的元素也用它读
+// 简单字符串和bulk string返回string, 整数返回int64, 数组返回[]any
+// 错误回复返回RedisError, nil回复返回nil, 这两个都是值不是error
+func readValue(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("fake redis: malformed line %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return RedisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = readValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("fake redis: unexpected line %q", line)
+}
+
+// readCommand 命令是bulk string的数组
+func readCommand(r *bufio.Reader) ([]string, error) {
+	v, err := readValue(r)
+	if err != nil {
+		return nil, err
+	}
+	args, ok := v.([]any)
+	if !ok || len(args) == 0 {
+		return nil, errors.New("fake redis: command is not an array")
+	}
+	cmd := make([]string, len(args))
+	for i, a := range args {
+		if cmd[i], ok = a.(string); !ok {
+			return nil, fmt.Errorf("fake redis: argument %d is %T", i, a)
+		}
+	}
+	return cmd, nil
+}
+
+// writeValue 写一个回复, 不flush
+func writeValue(w *bufio.Writer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case statusReply:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case RedisError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			if err := writeValue(w, e); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("fake redis: unsupported reply %T", v)
+	}
+	return nil
+}
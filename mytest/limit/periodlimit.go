@@ -0,0 +1,115 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// 见 read-source-code/go-zero/limit/periodlimit.md
+
+const (
+	// Unknown 未知状态
+	Unknown = iota
+	// Allowed 允许
+	Allowed
+	// HitQuota 这个请求正好用完配额
+	HitQuota
+	// OverQuota 超过配额
+	OverQuota
+
+	internalOverQuota = 0
+	internalAllowed   = 1
+	internalHitQuota  = 2
+)
+
+// ErrUnknownCode 存储返回了不认识的状态码
+var ErrUnknownCode = errors.New("unknown status code")
+
+// Align 窗口按本地时区对齐, 比如每天最多发5条短信验证码, 要从零点开始算
+func Align() Option {
+	return func(o *options) {
+		o.align = true
+	}
+}
+
+// PeriodLimit 固定窗口限流, period秒内最多quota个请求
+// 存储不可用时退化成进程内的计数
+type PeriodLimit struct {
+	period    int
+	quota     int
+	store     Store
+	keyPrefix string
+	opts      options
+	rescue    *rescue
+	local     *MemoryStore
+}
+
+// NewPeriodLimit 创建固定窗口限流
+func NewPeriodLimit(period, quota int, store Store, keyPrefix string, opts ...Option) *PeriodLimit {
+	o := buildOptions(opts)
+	return &PeriodLimit{
+		period:    period,
+		quota:     quota,
+		store:     store,
+		keyPrefix: keyPrefix,
+		opts:      o,
+		rescue:    newRescue(store, o.clock),
+		local:     NewMemoryStore(o.clock),
+	}
+}
+
+// Take 请求一个许可, 返回状态
+func (h *PeriodLimit) Take(key string) (int, error) {
+	return h.TakeCtx(context.Background(), key)
+}
+
+// TakeCtx 和Take一样, 带ctx
+func (h *PeriodLimit) TakeCtx(ctx context.Context, key string) (int, error) {
+	store := Store(h.local)
+	if h.rescue.alive() {
+		store = h.store
+	}
+
+	code, err := store.PeriodTake(ctx, h.keyPrefix+key, h.quota, h.calcExpireSeconds())
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, ErrUnknownCode) {
+		log.Printf("fail to use period limiter: %s, use in-process limiter for rescue", err)
+		h.rescue.startMonitor()
+		code, err = h.local.PeriodTake(ctx, h.keyPrefix+key, h.quota, h.calcExpireSeconds())
+	}
+	if err != nil {
+		return Unknown, err
+	}
+	return toCode(code)
+}
+
+// toCode 把存储返回的状态码转成对外的状态
+func toCode(code int) (int, error) {
+	switch code {
+	case internalOverQuota:
+		return OverQuota, nil
+	case internalAllowed:
+		return Allowed, nil
+	case internalHitQuota:
+		return HitQuota, nil
+	default:
+		return Unknown, ErrUnknownCode
+	}
+}
+
+// StoreAlive 存储是否可用
+func (h *PeriodLimit) StoreAlive() bool {
+	return h.rescue.alive()
+}
+
+func (h *PeriodLimit) calcExpireSeconds() int {
+	if h.opts.align {
+		now := h.opts.clock.Now()
+		_, offset := now.Zone()
+		unix := now.Unix() + int64(offset)
+		return h.period - int(unix%int64(h.period))
+	}
+
+	return h.period
+}
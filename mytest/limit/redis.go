@@ -0,0 +1,260 @@
+package limit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// tokenScript 和go-zero的tokenscript.lua一样
+const tokenScript = `local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+local fill_time = capacity/rate
+local ttl = math.floor(fill_time*2)
+local last_tokens = tonumber(redis.call("get", KEYS[1]))
+if last_tokens == nil then
+    last_tokens = capacity
+end
+
+local last_refreshed = tonumber(redis.call("get", KEYS[2]))
+if last_refreshed == nil then
+    last_refreshed = 0
+end
+
+local delta = math.max(0, now-last_refreshed)
+local filled_tokens = math.min(capacity, last_tokens+(delta*rate))
+local allowed = filled_tokens >= requested
+local new_tokens = filled_tokens
+if allowed then
+    new_tokens = filled_tokens - requested
+end
+
+redis.call("setex", KEYS[1], ttl, new_tokens)
+redis.call("setex", KEYS[2], ttl, now)
+
+return allowed`
+
+// periodScript 和go-zero的periodscript.lua一样
+const periodScript = `local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local current = redis.call("INCRBY", KEYS[1], 1)
+if current == 1 then
+    redis.call("expire", KEYS[1], window)
+end
+if current < limit then
+    return 1
+elseif current == limit then
+    return 2
+else
+    return 0
+end`
+
+// slidingWindowScript 滑动窗口计数
+// KEYS[1]当前窗口的计数, KEYS[2]上一个窗口的计数
+// 上一个窗口的计数按还在滑动窗口里的比例算进来
+const slidingWindowScript = `local quota = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local curr = tonumber(redis.call("get", KEYS[1]) or "0")
+local prev = tonumber(redis.call("get", KEYS[2]) or "0")
+local count = math.floor(prev*(window-elapsed)/window) + curr + 1
+if count > quota then
+    return 0
+end
+redis.call("incr", KEYS[1])
+redis.call("pexpire", KEYS[1], window*2)
+if count == quota then
+    return 2
+end
+return 1`
+
+// slidingLogScript 滑动日志, 用zset记录每个请求的时间
+const slidingLogScript = `local quota = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+redis.call("zremrangebyscore", KEYS[1], "-inf", now-window)
+local count = redis.call("zcard", KEYS[1]) + 1
+if count > quota then
+    return 0
+end
+redis.call("zadd", KEYS[1], now, ARGV[4])
+redis.call("pexpire", KEYS[1], window)
+if count == quota then
+    return 2
+end
+return 1`
+
+const defaultRedisTimeout = time.Second
+
+// RedisStore 用lua脚本在redis里做限流, 多个进程共享一份状态
+type RedisStore struct {
+	addr    string
+	timeout time.Duration
+	pool    chan *redisConn
+	seq     uint64
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// NewRedisStore 创建redis存储, addr是host:port
+func NewRedisStore(addr string) *RedisStore {
+	return &RedisStore{
+		addr:    addr,
+		timeout: defaultRedisTimeout,
+		pool:    make(chan *redisConn, 8),
+	}
+}
+
+func (s *RedisStore) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-s.pool:
+		return c, nil
+	default:
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+func (s *RedisStore) put(c *redisConn) {
+	select {
+	case s.pool <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// Do 执行一条命令
+func (s *RedisStore) Do(ctx context.Context, args ...string) (any, error) {
+	c, err := s.get(ctx)
+	if err != nil {
+		return nil, ctxError(ctx, err, false)
+	}
+
+	deadline := time.Now().Add(s.timeout)
+	fromCtx := false
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline, fromCtx = d, true
+	}
+	c.conn.SetDeadline(deadline)
+
+	if err := writeCommand(c.w, args...); err != nil {
+		c.conn.Close()
+		return nil, ctxError(ctx, err, fromCtx)
+	}
+	reply, err := readReply(c.r)
+	var redisErr RedisError
+	if err != nil && !errors.Is(err, ErrNil) && !errors.As(err, &redisErr) {
+		// 网络错误, 连接不能再用了
+		c.conn.Close()
+		return nil, ctxError(ctx, err, fromCtx)
+	}
+	s.put(c)
+	return reply, err
+}
+
+// ctxError 调用方的ctx结束了就返回ctx.Err(), 限流器只把redis自己的超时当成不可用
+// socket的deadline来自ctx时, 超时可能比ctx的timer先触发, 也算ctx的超时
+func ctxError(ctx context.Context, err error, fromCtx bool) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var netErr net.Error
+	if fromCtx && errors.As(err, &netErr) && netErr.Timeout() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Close 关闭连接池里的连接
+func (s *RedisStore) Close() error {
+	for {
+		select {
+		case c := <-s.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (s *RedisStore) eval(ctx context.Context, script string, keys []string, args ...string) (any, error) {
+	cmd := append([]string{"EVAL", script, strconv.Itoa(len(keys))}, keys...)
+	return s.Do(ctx, append(cmd, args...)...)
+}
+
+func (s *RedisStore) TokenTake(ctx context.Context, tokenKey, timestampKey string, rate, burst int, now int64, n int) (bool, error) {
+	resp, err := s.eval(ctx, tokenScript, []string{tokenKey, timestampKey},
+		strconv.Itoa(rate),
+		strconv.Itoa(burst),
+		strconv.FormatInt(now, 10),
+		strconv.Itoa(n))
+	// lua的false转成redis的nil
+	if errors.Is(err, ErrNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	code, ok := resp.(int64)
+	if !ok {
+		return false, ErrUnknownCode
+	}
+	return code == 1, nil
+}
+
+func (s *RedisStore) PeriodTake(ctx context.Context, key string, quota, expireSeconds int) (int, error) {
+	resp, err := s.eval(ctx, periodScript, []string{key},
+		strconv.Itoa(quota),
+		strconv.Itoa(expireSeconds))
+	return codeReply(resp, err)
+}
+
+func (s *RedisStore) SlidingWindowTake(ctx context.Context, currKey, prevKey string, quota int, window, elapsed time.Duration) (int, error) {
+	resp, err := s.eval(ctx, slidingWindowScript, []string{currKey, prevKey},
+		strconv.Itoa(quota),
+		strconv.FormatInt(window.Milliseconds(), 10),
+		strconv.FormatInt(elapsed.Milliseconds(), 10))
+	return codeReply(resp, err)
+}
+
+func (s *RedisStore) SlidingLogTake(ctx context.Context, key string, quota int, window time.Duration, now time.Time) (int, error) {
+	ms := now.UnixMilli()
+	resp, err := s.eval(ctx, slidingLogScript, []string{key},
+		strconv.Itoa(quota),
+		strconv.FormatInt(window.Milliseconds(), 10),
+		strconv.FormatInt(ms, 10),
+		// zset的member要唯一, 同一毫秒可能有多个请求
+		strconv.FormatInt(ms, 10)+"-"+strconv.FormatUint(atomic.AddUint64(&s.seq, 1), 10))
+	return codeReply(resp, err)
+}
+
+func codeReply(resp any, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	code, ok := resp.(int64)
+	if !ok {
+		return 0, ErrUnknownCode
+	}
+	return int(code), nil
+}
+
+func (s *RedisStore) Ping(ctx context.Context) bool {
+	resp, err := s.Do(ctx, "PING")
+	return err == nil && resp == "PONG"
+}
@@ -0,0 +1,152 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/limit/limittest"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// newSlowRedis tokenScript要执行delay这么久, 模拟redis很慢
+func newSlowRedis(t *testing.T, delay time.Duration) *RedisStore {
+	t.Helper()
+	fake, err := limittest.NewFakeRedis(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.RegisterScript(tokenScript, func(_ func(args ...string) any, _, _ []string) any {
+		time.Sleep(delay)
+		return int64(1)
+	})
+	rs := NewRedisStore(fake.Addr())
+	t.Cleanup(func() {
+		rs.Close()
+		fake.Close()
+	})
+	return rs
+}
+
+// Test_RedisStore_CtxTimeout 调用方的ctx到期返回ctx的错误, 不算redis不可用
+func Test_RedisStore_CtxTimeout(t *testing.T) {
+	rs := newSlowRedis(t, 200*time.Millisecond)
+	l, err := NewTokenLimiter(1, 1, rs, "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rs.eval(ctx, tokenScript, []string{"a", "b"}, "1", "1", "0", "1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if l.AllowCtx(ctx) {
+		t.Fatal("timed out request should be denied")
+	}
+	if !l.StoreAlive() {
+		t.Fatal("caller's timeout should not switch to the local limiter")
+	}
+}
+
+// Test_RedisStore_Timeout redis自己超时才切到本地限流
+func Test_RedisStore_Timeout(t *testing.T) {
+	rs := newSlowRedis(t, 200*time.Millisecond)
+	rs.timeout = 20 * time.Millisecond
+	l, err := NewTokenLimiter(1, 1, rs, "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !l.Allow() {
+		t.Fatal("local limiter should allow the first request")
+	}
+	if l.StoreAlive() {
+		t.Fatal("store timeout should switch to the local limiter")
+	}
+}
+
+func Test_NewTokenLimiter_Invalid(t *testing.T) {
+	for _, rb := range [][2]int{{0, 1}, {-1, 1}, {1, 0}} {
+		if _, err := NewTokenLimiter(rb[0], rb[1], NewMemoryStore(nil), "k"); !errors.Is(err, ErrInvalidRate) {
+			t.Fatalf("rate %d burst %d: err = %v, want ErrInvalidRate", rb[0], rb[1], err)
+		}
+	}
+}
+
+// Test_Scripts_Redis FakeRedis里的脚本是用MemoryStore实现的, lua脚本本身只能在真的redis上跑
+// 设置REDIS_ADDR=127.0.0.1:6379时, 同一串请求在redis和MemoryStore上的结果要一样
+func Test_Scripts_Redis(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+	rs := NewRedisStore(addr)
+	defer rs.Close()
+	ctx := context.Background()
+	if !rs.Ping(ctx) {
+		t.Fatalf("no redis at %s", addr)
+	}
+
+	// 每次用不同的key, 结束时删掉
+	prefix := fmt.Sprintf("limittest:%d:", time.Now().UnixNano())
+	defer func() {
+		keys, _ := rs.Do(ctx, "KEYS", "*"+prefix+"*")
+		for _, k := range keys.([]any) {
+			rs.Do(ctx, "DEL", k.(string))
+		}
+	}()
+
+	clk := second.NewFakeClock(epoch)
+	type limiters struct {
+		token  *TokenLimiter
+		period *PeriodLimit
+		window *SlidingWindowLimit
+		log    *SlidingLogLimit
+	}
+	build := func(name string, store interface {
+		Store
+		SlidingStore
+	}) limiters {
+		token, err := NewTokenLimiter(2, 5, store, prefix+name+":token", WithClock(clk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return limiters{
+			token:  token,
+			period: NewPeriodLimit(60, 5, store, prefix+name+":period:", WithClock(clk)),
+			window: NewSlidingWindowLimit(time.Second, 3, store, prefix+name+":window:", WithClock(clk)),
+			log:    NewSlidingLogLimit(time.Second, 3, store, prefix+name+":log:", WithClock(clk)),
+		}
+	}
+	redis, local := build("redis", rs), build("memory", NewMemoryStore(clk))
+
+	take := func(l limiters) string {
+		var codes [3]int
+		var errs [3]error
+		codes[0], errs[0] = l.period.TakeCtx(ctx, "k")
+		codes[1], errs[1] = l.window.TakeCtx(ctx, "k")
+		codes[2], errs[2] = l.log.TakeCtx(ctx, "k")
+		for _, err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return fmt.Sprint(l.token.AllowCtx(ctx), codes)
+	}
+	for i := 0; i < 30; i++ {
+		if got, want := take(redis), take(local); got != want {
+			t.Fatalf("step %d: redis %s, memory %s", i, got, want)
+		}
+		if !redis.token.StoreAlive() {
+			t.Fatal("redis store marked dead")
+		}
+		clk.Advance(150 * time.Millisecond)
+	}
+}
@@ -0,0 +1,69 @@
+package limit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+const pingInterval = time.Millisecond * 100 // 存储健康检查的间隔
+
+// rescue 对应go-zero的startMonitor/waitForRedis
+// 存储出错后标记为不可用, 后台定时Ping, 恢复后再切回去
+type rescue struct {
+	store          pinger
+	clock          second.Clock
+	lock           sync.Mutex
+	storeAlive     uint32
+	monitorStarted bool
+}
+
+type pinger interface {
+	Ping(ctx context.Context) bool
+}
+
+func newRescue(store pinger, clock second.Clock) *rescue {
+	return &rescue{store: store, clock: clock, storeAlive: 1}
+}
+
+func (r *rescue) alive() bool {
+	return atomic.LoadUint32(&r.storeAlive) == 1
+}
+
+// startMonitor 标记存储不可用, 启动健康检查
+func (r *rescue) startMonitor() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.monitorStarted {
+		return
+	}
+
+	r.monitorStarted = true
+	atomic.StoreUint32(&r.storeAlive, 0)
+
+	go r.waitForStore()
+}
+
+func (r *rescue) waitForStore() {
+	ticker := r.clock.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		r.lock.Lock()
+		r.monitorStarted = false
+		r.lock.Unlock()
+	}()
+
+	for range ticker.C() {
+		ctx, cancel := context.WithTimeout(context.Background(), pingInterval)
+		ok := r.store.Ping(ctx)
+		cancel()
+		if ok {
+			atomic.StoreUint32(&r.storeAlive, 1)
+			return
+		}
+	}
+}
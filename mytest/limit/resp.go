@@ -0,0 +1,100 @@
+package limit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// 最小的RESP2协议实现, 只够跑限流脚本用, 不依赖第三方redis库
+
+// ErrNil 对应redis的nil回复, lua脚本返回false时也是nil
+var ErrNil = errors.New("redis: nil")
+
+// RedisError 是redis返回的错误回复
+type RedisError string
+
+func (e RedisError) Error() string { return string(e) }
+
+// writeCommand 把命令写成bulk string数组
+func writeCommand(w *bufio.Writer, args ...string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return w.Flush()
+}
+
+// readReply 读一个回复
+// 简单字符串和bulk string返回string, 整数返回int64, 数组返回[]any
+// 错误回复返回RedisError, nil回复返回ErrNil
+// 数组里的错误回复和nil回复不会当成error返回, 而是在对应的位置放RedisError和nil
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		// 元素是错误回复时也要把整个数组读完, 不然连接上剩下的数据会被当成下一个回复
+		arr := make([]any, n)
+		for i := range arr {
+			v, err := readReply(r)
+			var redisErr RedisError
+			switch {
+			case errors.As(err, &redisErr):
+				v = redisErr
+			case errors.Is(err, ErrNil):
+				v = nil
+			case err != nil:
+				return nil, err
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,33 @@
+package limit
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Test_readReply_ArrayError 数组里有错误回复时读完整个数组, 错误放在对应的位置
+func Test_readReply_ArrayError(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*4\r\n-ERR boom\r\n$-1\r\n$1\r\nx\r\n:1\r\n+PONG\r\n"))
+
+	v, err := readReply(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr, ok := v.([]any)
+	if !ok || len(arr) != 4 {
+		t.Fatalf("reply = %#v", v)
+	}
+	if arr[0] != RedisError("ERR boom") || arr[1] != nil || arr[2] != "x" || arr[3] != int64(1) {
+		t.Fatalf("reply = %#v", arr)
+	}
+
+	// 数组读完了, 下一个回复不受影响
+	if v, err := readReply(r); err != nil || v != "PONG" {
+		t.Fatalf("next reply = %v, %v", v, err)
+	}
+	if _, err := readReply(bufio.NewReader(strings.NewReader("-ERR x\r\n"))); !errors.As(err, new(RedisError)) {
+		t.Fatalf("error reply err = %v", err)
+	}
+}
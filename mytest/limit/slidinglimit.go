@@ -0,0 +1,128 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+// 固定窗口在边界前后各打满一次, 一个窗口长度内能放过2倍的quota
+// 见 read-source-code/go-zero/limit/periodlimit.md 该算法的缺点
+// 下面两个限流器和PeriodLimit返回一样的状态码
+
+// SlidingWindowLimit 滑动窗口计数, 只存当前和上一个窗口的计数
+// 上一个窗口的计数按还在滑动窗口里的比例算进来, 假设请求在窗口内是均匀的
+type SlidingWindowLimit struct {
+	window    time.Duration
+	quota     int
+	store     SlidingStore
+	keyPrefix string
+	opts      options
+	rescue    *rescue
+	local     *MemoryStore
+}
+
+// NewSlidingWindowLimit 创建滑动窗口计数限流, window内最多quota个请求
+func NewSlidingWindowLimit(window time.Duration, quota int, store SlidingStore, keyPrefix string, opts ...Option) *SlidingWindowLimit {
+	o := buildOptions(opts)
+	return &SlidingWindowLimit{
+		window:    window,
+		quota:     quota,
+		store:     store,
+		keyPrefix: keyPrefix,
+		opts:      o,
+		rescue:    newRescue(store, o.clock),
+		local:     NewMemoryStore(o.clock),
+	}
+}
+
+// Take 请求一个许可, 返回状态
+func (h *SlidingWindowLimit) Take(key string) (int, error) {
+	return h.TakeCtx(context.Background(), key)
+}
+
+// TakeCtx 和Take一样, 带ctx
+func (h *SlidingWindowLimit) TakeCtx(ctx context.Context, key string) (int, error) {
+	now := h.opts.clock.Now().UnixNano()
+	idx := now / int64(h.window)
+	elapsed := time.Duration(now % int64(h.window))
+	currKey := h.keyPrefix + key + ":" + strconv.FormatInt(idx, 10)
+	prevKey := h.keyPrefix + key + ":" + strconv.FormatInt(idx-1, 10)
+
+	take := func(store SlidingStore) (int, error) {
+		return store.SlidingWindowTake(ctx, currKey, prevKey, h.quota, h.window, elapsed)
+	}
+	return takeWithRescue(h.rescue, h.store, h.local, "sliding window", take)
+}
+
+// StoreAlive 存储是否可用
+func (h *SlidingWindowLimit) StoreAlive() bool {
+	return h.rescue.alive()
+}
+
+// SlidingLogLimit 滑动日志, 记住窗口内每个请求的时间, 结果是精确的
+// 代价是每个key要存quota个时间戳
+type SlidingLogLimit struct {
+	window    time.Duration
+	quota     int
+	store     SlidingStore
+	keyPrefix string
+	opts      options
+	rescue    *rescue
+	local     *MemoryStore
+}
+
+// NewSlidingLogLimit 创建滑动日志限流, 任意window长度内最多quota个请求
+func NewSlidingLogLimit(window time.Duration, quota int, store SlidingStore, keyPrefix string, opts ...Option) *SlidingLogLimit {
+	o := buildOptions(opts)
+	return &SlidingLogLimit{
+		window:    window,
+		quota:     quota,
+		store:     store,
+		keyPrefix: keyPrefix,
+		opts:      o,
+		rescue:    newRescue(store, o.clock),
+		local:     NewMemoryStore(o.clock),
+	}
+}
+
+// Take 请求一个许可, 返回状态
+func (h *SlidingLogLimit) Take(key string) (int, error) {
+	return h.TakeCtx(context.Background(), key)
+}
+
+// TakeCtx 和Take一样, 带ctx
+func (h *SlidingLogLimit) TakeCtx(ctx context.Context, key string) (int, error) {
+	now := h.opts.clock.Now()
+	take := func(store SlidingStore) (int, error) {
+		return store.SlidingLogTake(ctx, h.keyPrefix+key, h.quota, h.window, now)
+	}
+	return takeWithRescue(h.rescue, h.store, h.local, "sliding log", take)
+}
+
+// StoreAlive 存储是否可用
+func (h *SlidingLogLimit) StoreAlive() bool {
+	return h.rescue.alive()
+}
+
+// takeWithRescue 和PeriodLimit.TakeCtx一样, 存储出错时切到本地存储
+func takeWithRescue(r *rescue, store SlidingStore, local *MemoryStore, name string,
+	take func(SlidingStore) (int, error)) (int, error) {
+	if !r.alive() {
+		store = local
+	}
+
+	code, err := take(store)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, ErrUnknownCode) {
+		log.Printf("fail to use %s limiter: %s, use in-process limiter for rescue", name, err)
+		r.startMonitor()
+		code, err = take(local)
+	}
+	if err != nil {
+		return Unknown, err
+	}
+	return toCode(code)
+}
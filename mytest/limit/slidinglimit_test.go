@@ -0,0 +1,123 @@
+package limit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+type taker interface {
+	Take(key string) (int, error)
+}
+
+func Test_SlidingLimit_Codes(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	for name, store := range stores(t, clk) {
+		ss := store.(SlidingStore)
+		limiters := map[string]taker{
+			"window": NewSlidingWindowLimit(time.Second, 3, ss, "window:"+name+":", WithClock(clk)),
+			"log":    NewSlidingLogLimit(time.Second, 3, ss, "log:"+name+":", WithClock(clk)),
+		}
+		for lname, l := range limiters {
+			t.Run(name+"/"+lname, func(t *testing.T) {
+				want := []int{Allowed, Allowed, HitQuota, OverQuota, OverQuota}
+				for i, w := range want {
+					code, err := l.Take("first")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if code != w {
+						t.Fatalf("take %d: code %d, want %d", i, code, w)
+					}
+				}
+				// 别的key不受影响
+				if code, _ := l.Take("second"); code != Allowed {
+					t.Fatalf("code %d for another key", code)
+				}
+			})
+		}
+	}
+}
+
+// 固定窗口在边界两侧各放过quota个请求, 滑动窗口不会
+func Test_SlidingLimit_BoundaryBurst(t *testing.T) {
+	const quota = 100
+	take := func(l taker, n int) (allowed int) {
+		for i := 0; i < n; i++ {
+			code, err := l.Take("burst")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code == Allowed || code == HitQuota {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	for name, newStore := range map[string]func(clk second.Clock) SlidingStore{
+		"memory": func(clk second.Clock) SlidingStore { return NewMemoryStore(clk) },
+		"redis":  func(clk second.Clock) SlidingStore { return stores(t, clk)["redis"].(SlidingStore) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			// 窗口最后一秒打满, 下一个窗口第一秒再打满
+			clk := second.NewFakeClock(epoch.Add(59 * time.Second))
+			store := newStore(clk)
+			fixed := NewPeriodLimit(60, quota, store.(Store), "fixed:", WithClock(clk), Align())
+			window := NewSlidingWindowLimit(time.Minute, quota, store, "window:", WithClock(clk))
+			log := NewSlidingLogLimit(time.Minute, quota, store, "log:", WithClock(clk))
+
+			got := map[string]int{
+				"fixed":  take(fixed, quota),
+				"window": take(window, quota),
+				"log":    take(log, quota),
+			}
+			clk.Advance(time.Second)
+			got["fixed"] += take(fixed, quota)
+			got["window"] += take(window, quota)
+			got["log"] += take(log, quota)
+
+			if got["fixed"] != 2*quota {
+				t.Fatalf("fixed window allowed %d, want the 2x burst %d", got["fixed"], 2*quota)
+			}
+			if got["window"] > quota {
+				t.Fatalf("sliding window allowed %d within one second", got["window"])
+			}
+			if got["log"] != quota {
+				t.Fatalf("sliding log allowed %d, want %d", got["log"], quota)
+			}
+
+			// 第一批请求滑出窗口后, 滑动日志又能放过一整个quota
+			clk.Advance(59 * time.Second)
+			if n := take(log, quota); n != quota {
+				t.Fatalf("sliding log allowed %d after the window slid", n)
+			}
+		})
+	}
+}
+
+func Test_SlidingLimit_Rescue(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	fake := newFakeRedis(t, clk)
+	rs := NewRedisStore(fake.Addr())
+	defer rs.Close()
+
+	l := NewSlidingLogLimit(time.Second, 2, rs, "rescue:", WithClock(clk))
+	fake.SetFailing(true)
+
+	if code, err := l.Take("k"); err != nil || code != Allowed {
+		t.Fatalf("code %d, err %v", code, err)
+	}
+	if l.StoreAlive() {
+		t.Fatal("store should be marked dead")
+	}
+	if code, _ := l.Take("k"); code != HitQuota {
+		t.Fatalf("local fallback code %d", code)
+	}
+
+	fake.SetFailing(false)
+	clk.BlockUntil(1)
+	clk.Advance(pingInterval)
+	waitFor(t, l.StoreAlive)
+}
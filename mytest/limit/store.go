@@ -0,0 +1,183 @@
+package limit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// Store 保存限流状态的地方, 每个方法对应go-zero里的一个lua脚本, 必须是原子的
+// 见 read-source-code/go-zero/limit/tokenlimit_lua.md 和 periodlimit_lua.md
+type Store interface {
+	// TokenTake 令牌桶脚本, now是秒级时间戳, 返回是否拿到n个令牌
+	TokenTake(ctx context.Context, tokenKey, timestampKey string, rate, burst int, now int64, n int) (bool, error)
+	// PeriodTake 固定窗口脚本, 返回 internalOverQuota/internalAllowed/internalHitQuota
+	PeriodTake(ctx context.Context, key string, quota, expireSeconds int) (int, error)
+	// Ping 检查存储是否可用
+	Ping(ctx context.Context) bool
+}
+
+// SlidingStore 保存滑动窗口状态的地方, 同样要求原子
+type SlidingStore interface {
+	// SlidingWindowTake 滑动窗口计数, currKey/prevKey是当前和上一个窗口的计数
+	// elapsed是当前窗口已经过去的时间, 返回 internalOverQuota/internalAllowed/internalHitQuota
+	SlidingWindowTake(ctx context.Context, currKey, prevKey string, quota int, window, elapsed time.Duration) (int, error)
+	// SlidingLogTake 滑动日志, 记录窗口内每个请求的时间
+	SlidingLogTake(ctx context.Context, key string, quota int, window time.Duration, now time.Time) (int, error)
+	// Ping 检查存储是否可用
+	Ping(ctx context.Context) bool
+}
+
+type memItem struct {
+	value    string
+	expireAt time.Time // 零值表示不过期
+}
+
+// MemoryStore 进程内的Store, 单机使用, 也是Redis不可用时的本地兜底
+type MemoryStore struct {
+	mu    sync.Mutex
+	clock second.Clock
+	items map[string]memItem
+	logs  map[string][]time.Time // 滑动日志, 按时间从小到大
+}
+
+// NewMemoryStore 创建内存存储, clock为nil时用真实时间
+func NewMemoryStore(clock second.Clock) *MemoryStore {
+	if clock == nil {
+		clock = second.RealClock
+	}
+	return &MemoryStore{
+		clock: clock,
+		items: make(map[string]memItem),
+		logs:  make(map[string][]time.Time),
+	}
+}
+
+func (m *MemoryStore) getLocked(key string) (string, bool) {
+	item, ok := m.items[key]
+	if !ok {
+		return "", false
+	}
+	if !item.expireAt.IsZero() && !m.clock.Now().Before(item.expireAt) {
+		delete(m.items, key)
+		return "", false
+	}
+	return item.value, true
+}
+
+func (m *MemoryStore) setexLocked(key string, ttl time.Duration, value string) {
+	m.items[key] = memItem{value: value, expireAt: m.clock.Now().Add(ttl)}
+}
+
+func (m *MemoryStore) getNumberLocked(key string) (float64, bool) {
+	v, ok := m.getLocked(key)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f, err == nil
+}
+
+// TokenTake 和tokenscript.lua一样的逻辑
+func (m *MemoryStore) TokenTake(ctx context.Context, tokenKey, timestampKey string, rate, burst int, now int64, n int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	capacity := float64(burst)
+	fillTime := capacity / float64(rate)
+	ttl := time.Duration(math.Floor(fillTime*2)) * time.Second
+
+	lastTokens, ok := m.getNumberLocked(tokenKey)
+	if !ok {
+		lastTokens = capacity
+	}
+	lastRefreshed, _ := m.getNumberLocked(timestampKey)
+
+	delta := math.Max(0, float64(now)-lastRefreshed)
+	filled := math.Min(capacity, lastTokens+delta*float64(rate))
+	allowed := filled >= float64(n)
+	if allowed {
+		filled -= float64(n)
+	}
+
+	m.setexLocked(tokenKey, ttl, strconv.FormatFloat(filled, 'f', -1, 64))
+	m.setexLocked(timestampKey, ttl, strconv.FormatInt(now, 10))
+	return allowed, nil
+}
+
+// PeriodTake 和periodscript.lua一样的逻辑
+func (m *MemoryStore) PeriodTake(ctx context.Context, key string, quota, expireSeconds int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// INCRBY, 第一次创建时加过期时间
+	current := int64(1)
+	item := memItem{value: "1", expireAt: m.clock.Now().Add(time.Duration(expireSeconds) * time.Second)}
+	if v, ok := m.getLocked(key); ok {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		current = n + 1
+		item = m.items[key]
+		item.value = strconv.FormatInt(current, 10)
+	}
+	m.items[key] = item
+
+	switch {
+	case current < int64(quota):
+		return internalAllowed, nil
+	case current == int64(quota):
+		return internalHitQuota, nil
+	}
+	return internalOverQuota, nil
+}
+
+// SlidingWindowTake 和slidingWindowScript一样的逻辑
+func (m *MemoryStore) SlidingWindowTake(ctx context.Context, currKey, prevKey string, quota int, window, elapsed time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev, _ := m.getNumberLocked(prevKey)
+	curr, _ := m.getNumberLocked(currKey)
+	count := int64(math.Floor(prev*float64(window-elapsed)/float64(window))) + int64(curr) + 1
+	if count > int64(quota) {
+		return internalOverQuota, nil
+	}
+
+	m.setexLocked(currKey, 2*window, strconv.FormatInt(int64(curr)+1, 10))
+	if count == int64(quota) {
+		return internalHitQuota, nil
+	}
+	return internalAllowed, nil
+}
+
+// SlidingLogTake 和slidingLogScript一样的逻辑
+func (m *MemoryStore) SlidingLogTake(ctx context.Context, key string, quota int, window time.Duration, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log := m.logs[key]
+	expired := 0
+	for expired < len(log) && !log[expired].After(now.Add(-window)) {
+		expired++
+	}
+	log = log[expired:]
+
+	count := len(log) + 1
+	if count > quota {
+		m.logs[key] = log
+		return internalOverQuota, nil
+	}
+
+	m.logs[key] = append(log, now)
+	if count == quota {
+		return internalHitQuota, nil
+	}
+	return internalAllowed, nil
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) bool {
+	return true
+}
@@ -0,0 +1,122 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+	xrate "golang.org/x/time/rate"
+)
+
+// 见 read-source-code/go-zero/limit/tokenlimit.md
+
+const (
+	tokenFormat     = "{%s}.tokens"
+	timestampFormat = "{%s}.ts"
+)
+
+// ErrInvalidRate NewTokenLimiter的rate或者burst不是正数
+var ErrInvalidRate = errors.New("limit: rate and burst must be positive")
+
+// Option 定制TokenLimiter和PeriodLimit
+type Option func(o *options)
+
+type options struct {
+	clock second.Clock
+	align bool
+}
+
+// WithClock 指定时钟, 测试时用second.FakeClock
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func buildOptions(opts []Option) options {
+	o := options{clock: second.RealClock}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// TokenLimiter 分布式令牌桶, 每秒rate个令牌, 最多攒burst个
+// 存储不可用时退化成进程内的令牌桶
+type TokenLimiter struct {
+	rate          int
+	burst         int
+	store         Store
+	tokenKey      string
+	timestampKey  string
+	clock         second.Clock
+	rescue        *rescue
+	rescueLimiter *xrate.Limiter
+}
+
+// NewTokenLimiter 创建分布式令牌桶, rate和burst都要是正数
+// rate为0时本地令牌桶的间隔没法算, 脚本里capacity/rate也会除以0
+func NewTokenLimiter(rate, burst int, store Store, key string, opts ...Option) (*TokenLimiter, error) {
+	if rate <= 0 || burst <= 0 {
+		return nil, fmt.Errorf("%w: rate %d, burst %d", ErrInvalidRate, rate, burst)
+	}
+	o := buildOptions(opts)
+	return &TokenLimiter{
+		rate:          rate,
+		burst:         burst,
+		store:         store,
+		tokenKey:      fmt.Sprintf(tokenFormat, key),
+		timestampKey:  fmt.Sprintf(timestampFormat, key),
+		clock:         o.clock,
+		rescue:        newRescue(store, o.clock),
+		rescueLimiter: xrate.NewLimiter(xrate.Every(time.Second/time.Duration(rate)), burst),
+	}, nil
+}
+
+// Allow 是 AllowN(now, 1) 的简写
+func (lim *TokenLimiter) Allow() bool {
+	return lim.AllowN(lim.clock.Now(), 1)
+}
+
+// AllowCtx 是 AllowNCtx(ctx, now, 1) 的简写
+func (lim *TokenLimiter) AllowCtx(ctx context.Context) bool {
+	return lim.AllowNCtx(ctx, lim.clock.Now(), 1)
+}
+
+// AllowN 报告在now时刻是否可以发生n个事件
+func (lim *TokenLimiter) AllowN(now time.Time, n int) bool {
+	return lim.reserveN(context.Background(), now, n)
+}
+
+// AllowNCtx 和AllowN一样, 带ctx
+func (lim *TokenLimiter) AllowNCtx(ctx context.Context, now time.Time, n int) bool {
+	return lim.reserveN(ctx, now, n)
+}
+
+// StoreAlive 存储是否可用, 不可用时用的是本地令牌桶
+func (lim *TokenLimiter) StoreAlive() bool {
+	return lim.rescue.alive()
+}
+
+func (lim *TokenLimiter) reserveN(ctx context.Context, now time.Time, n int) bool {
+	if !lim.rescue.alive() {
+		return lim.rescueLimiter.AllowN(now, n)
+	}
+
+	allowed, err := lim.store.TokenTake(ctx, lim.tokenKey, lim.timestampKey,
+		lim.rate, lim.burst, now.Unix(), n)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		log.Printf("fail to use rate limiter: %s", err)
+		return false
+	}
+	if err != nil {
+		log.Printf("fail to use rate limiter: %s, use in-process limiter for rescue", err)
+		lim.rescue.startMonitor()
+		return lim.rescueLimiter.AllowN(now, n)
+	}
+
+	return allowed
+}
@@ -0,0 +1,287 @@
+package load
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/collection"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/go-zero/load/adaptiveshedder.md
+
+const (
+	defaultBuckets = 50
+	defaultWindow  = time.Second * 5
+	// 1000表示用满, 900就是90%
+	defaultCpuThreshold = 900
+	defaultMinRt        = float64(time.Second / time.Millisecond)
+	// flying的滑动平均系数
+	flyingBeta               = 0.9
+	coolOffDuration          = time.Second
+	cpuMax                   = 1000
+	millisecondsPerSecond    = 1000
+	overloadFactorLowerBound = 0.1
+)
+
+var (
+	// ErrServiceOverloaded 降载时Allow返回的错误
+	ErrServiceOverloaded = errors.New("service overloaded")
+
+	enabled    int32 = 1
+	logEnabled int32 = 1
+)
+
+type (
+	// Promise Allow的返回值, 请求结束时告诉Shedder结果
+	Promise interface {
+		// Pass 请求成功
+		Pass()
+		// Fail 请求失败
+		Fail()
+	}
+
+	// Shedder 降载器
+	Shedder interface {
+		// Allow 允许时返回Promise, 否则返回ErrServiceOverloaded
+		Allow() (Promise, error)
+	}
+
+	// ShedderOption 定制Shedder
+	ShedderOption func(opts *shedderOptions)
+
+	shedderOptions struct {
+		window       time.Duration
+		buckets      int
+		cpuThreshold int64
+		cpuUsage     func() int64
+		clock        second.Clock
+	}
+
+	adaptiveShedder struct {
+		cpuThreshold    int64
+		windowScale     float64
+		cpuUsage        func() int64
+		clock           second.Clock
+		flying          int64
+		avgFlying       float64
+		avgFlyingLock   sync.Mutex
+		overloadTime    int64 // 最近一次过载的时间, UnixNano
+		droppedRecently int32
+		passCounter     *collection.RollingWindow[int64, *collection.Bucket[int64]]
+		rtCounter       *collection.RollingWindow[int64, *collection.Bucket[int64]]
+	}
+)
+
+// Disable 关闭降载, 之后创建的Shedder都不降载
+func Disable() {
+	atomic.StoreInt32(&enabled, 0)
+}
+
+// DisableLog 关闭降载的日志
+func DisableLog() {
+	atomic.StoreInt32(&logEnabled, 0)
+}
+
+// NewAdaptiveShedder 创建自适应降载器
+// CPU超过阈值(或者刚降载过)并且并发超过 最大QPS*最小RT 时丢弃请求
+func NewAdaptiveShedder(opts ...ShedderOption) Shedder {
+	if atomic.LoadInt32(&enabled) == 0 {
+		return newNopShedder()
+	}
+
+	options := shedderOptions{
+		window:       defaultWindow,
+		buckets:      defaultBuckets,
+		cpuThreshold: defaultCpuThreshold,
+		cpuUsage:     CPUUsage,
+		clock:        second.RealClock,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	bucketDuration := options.window / time.Duration(options.buckets)
+	newBucket := func() *collection.Bucket[int64] {
+		return new(collection.Bucket[int64])
+	}
+	newWindow := func() *collection.RollingWindow[int64, *collection.Bucket[int64]] {
+		return collection.NewRollingWindow[int64, *collection.Bucket[int64]](newBucket, options.buckets, bucketDuration,
+			collection.IgnoreCurrentBucket[int64, *collection.Bucket[int64]](),
+			collection.WithClock[int64, *collection.Bucket[int64]](options.clock))
+	}
+	return &adaptiveShedder{
+		cpuThreshold: options.cpuThreshold,
+		windowScale:  float64(time.Second) / float64(bucketDuration) / millisecondsPerSecond,
+		cpuUsage:     options.cpuUsage,
+		clock:        options.clock,
+		passCounter:  newWindow(),
+		rtCounter:    newWindow(),
+	}
+}
+
+func (as *adaptiveShedder) Allow() (Promise, error) {
+	if as.shouldDrop() {
+		atomic.StoreInt32(&as.droppedRecently, 1)
+		return nil, ErrServiceOverloaded
+	}
+
+	as.addFlying(1)
+	return &promise{start: as.clock.Now(), shedder: as}, nil
+}
+
+func (as *adaptiveShedder) addFlying(delta int64) {
+	flying := atomic.AddInt64(&as.flying, delta)
+	// 请求结束时才更新avgFlying, 让它比flying滞后一些
+	// flying涨得快时多接一些请求, 降得快时少接一些
+	if delta < 0 {
+		as.avgFlyingLock.Lock()
+		as.avgFlying = as.avgFlying*flyingBeta + float64(flying)*(1-flyingBeta)
+		as.avgFlyingLock.Unlock()
+	}
+}
+
+func (as *adaptiveShedder) loadAvgFlying() float64 {
+	as.avgFlyingLock.Lock()
+	defer as.avgFlyingLock.Unlock()
+	return as.avgFlying
+}
+
+func (as *adaptiveShedder) highThru() bool {
+	avgFlying := as.loadAvgFlying()
+	maxFlight := as.maxFlight() * as.overloadFactor()
+	return avgFlying > maxFlight && float64(atomic.LoadInt64(&as.flying)) > maxFlight
+}
+
+// maxFlight 最多允许多少个并发请求
+// maxQPS = maxPass * 每秒的桶数, allowedFlying = maxQPS * minRT(毫秒) / 1000
+func (as *adaptiveShedder) maxFlight() float64 {
+	maxFlight := float64(as.maxPass()) * as.minRt() * as.windowScale
+	return math.Max(maxFlight, 1)
+}
+
+func (as *adaptiveShedder) maxPass() int64 {
+	var result int64 = 1
+	as.passCounter.Reduce(func(b *collection.Bucket[int64]) {
+		if b.Sum > result {
+			result = b.Sum
+		}
+	})
+	return result
+}
+
+func (as *adaptiveShedder) minRt() float64 {
+	// 窗口里没有请求时用一个比较大的值, 避免误杀
+	result := defaultMinRt
+	as.rtCounter.Reduce(func(b *collection.Bucket[int64]) {
+		if b.Count <= 0 {
+			return
+		}
+		avg := math.Round(float64(b.Sum) / float64(b.Count))
+		if avg < result {
+			result = avg
+		}
+	})
+	return result
+}
+
+// overloadFactor CPU越高允许的并发越少, 最少留10%
+func (as *adaptiveShedder) overloadFactor() float64 {
+	factor := (cpuMax - float64(as.cpuUsage())) / (cpuMax - float64(as.cpuThreshold))
+	return math.Min(math.Max(factor, overloadFactorLowerBound), 1)
+}
+
+func (as *adaptiveShedder) shouldDrop() bool {
+	if as.systemOverloaded() || as.stillHot() {
+		if as.highThru() {
+			if atomic.LoadInt32(&logEnabled) == 1 {
+				log.Printf("dropreq, cpu: %d, maxPass: %d, minRt: %.2f, hot: %t, flying: %d, avgFlying: %.2f",
+					as.cpuUsage(), as.maxPass(), as.minRt(), as.stillHot(),
+					atomic.LoadInt64(&as.flying), as.loadAvgFlying())
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// stillHot 刚降载过, 并且离上次过载不到coolOffDuration
+func (as *adaptiveShedder) stillHot() bool {
+	if atomic.LoadInt32(&as.droppedRecently) == 0 {
+		return false
+	}
+
+	overloadTime := atomic.LoadInt64(&as.overloadTime)
+	if overloadTime == 0 {
+		return false
+	}
+	if time.Duration(as.clock.Now().UnixNano()-overloadTime) < coolOffDuration {
+		return true
+	}
+
+	atomic.StoreInt32(&as.droppedRecently, 0)
+	return false
+}
+
+func (as *adaptiveShedder) systemOverloaded() bool {
+	if as.cpuUsage() < as.cpuThreshold {
+		return false
+	}
+
+	atomic.StoreInt64(&as.overloadTime, as.clock.Now().UnixNano())
+	return true
+}
+
+// WithBuckets 桶的数量
+func WithBuckets(buckets int) ShedderOption {
+	return func(opts *shedderOptions) {
+		opts.buckets = buckets
+	}
+}
+
+// WithCpuThreshold CPU阈值, 1000表示用满
+func WithCpuThreshold(threshold int64) ShedderOption {
+	return func(opts *shedderOptions) {
+		opts.cpuThreshold = threshold
+	}
+}
+
+// WithWindow 统计窗口
+func WithWindow(window time.Duration) ShedderOption {
+	return func(opts *shedderOptions) {
+		opts.window = window
+	}
+}
+
+// WithCPUUsage CPU使用率的来源, 默认是CPUUsage, 测试时可以用CPUMonitor.Usage或者固定值
+func WithCPUUsage(usage func() int64) ShedderOption {
+	return func(opts *shedderOptions) {
+		opts.cpuUsage = usage
+	}
+}
+
+// WithClock 指定时钟, 测试时用second.FakeClock
+func WithClock(c second.Clock) ShedderOption {
+	return func(opts *shedderOptions) {
+		opts.clock = c
+	}
+}
+
+type promise struct {
+	start   time.Time
+	shedder *adaptiveShedder
+}
+
+func (p *promise) Fail() {
+	p.shedder.addFlying(-1)
+}
+
+func (p *promise) Pass() {
+	rt := float64(p.shedder.clock.Now().Sub(p.start)) / float64(time.Millisecond)
+	p.shedder.addFlying(-1)
+	p.shedder.rtCounter.Add(int64(math.Ceil(rt)))
+	p.shedder.passCounter.Add(1)
+}
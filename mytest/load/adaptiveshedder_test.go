@@ -0,0 +1,198 @@
+package load
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+func init() {
+	DisableLog()
+}
+
+// newShedder CPU使用率由cpu控制, 桶是100ms
+func newShedder(clk *second.FakeClock, cpu *int64) *adaptiveShedder {
+	return NewAdaptiveShedder(
+		WithWindow(time.Second), WithBuckets(10), WithClock(clk),
+		WithCPUUsage(func() int64 { return atomic.LoadInt64(cpu) }),
+	).(*adaptiveShedder)
+}
+
+func Test_AdaptiveShedder_LowCPU(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	cpu := int64(100)
+	s := newShedder(clk, &cpu)
+
+	// CPU不高时并发再多也不丢
+	var promises []Promise
+	for i := 0; i < 1000; i++ {
+		p, err := s.Allow()
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		promises = append(promises, p)
+	}
+	for _, p := range promises {
+		p.Pass()
+	}
+}
+
+func Test_AdaptiveShedder_MaxPassMinRt(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	cpu := int64(0)
+	s := newShedder(clk, &cpu)
+
+	if s.maxPass() != 1 || s.minRt() != defaultMinRt {
+		t.Fatalf("empty window: maxPass %d, minRt %v", s.maxPass(), s.minRt())
+	}
+
+	// 每个桶里的请求数和耗时不一样, 第i个桶i*10个请求, 每个(10-i)ms
+	for i := 1; i <= 5; i++ {
+		batch(clk, s, i*10, time.Duration(10-i)*time.Millisecond)
+	}
+	if got := s.maxPass(); got != 50 {
+		t.Fatalf("maxPass %d", got)
+	}
+	if got := s.minRt(); got != 5 {
+		t.Fatalf("minRt %v", got)
+	}
+	// 每秒10个桶, 最大QPS是500, 最小RT是5ms, 最多2.5个并发
+	if got := s.maxFlight(); got != 2.5 {
+		t.Fatalf("maxFlight %v", got)
+	}
+}
+
+// batch 在一个桶里同时发出n个请求, 都耗时rt, 然后走到下一个桶的开头
+func batch(clk *second.FakeClock, s *adaptiveShedder, n int, rt time.Duration) {
+	promises := make([]Promise, 0, n)
+	for i := 0; i < n; i++ {
+		p, _ := s.Allow()
+		promises = append(promises, p)
+	}
+	clk.Advance(rt)
+	for _, p := range promises {
+		p.Pass()
+	}
+	bucket := 100 * time.Millisecond
+	clk.Advance(bucket - clk.Now().Sub(epoch)%bucket)
+}
+
+func Test_AdaptiveShedder_OverloadFactor(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	cpu := int64(0)
+	s := newShedder(clk, &cpu)
+	for _, tt := range []struct {
+		cpu  int64
+		want float64
+	}{{0, 1}, {900, 1}, {950, 0.5}, {1000, overloadFactorLowerBound}} {
+		atomic.StoreInt64(&cpu, tt.cpu)
+		if got := s.overloadFactor(); got != tt.want {
+			t.Fatalf("cpu %d: factor %v, want %v", tt.cpu, got, tt.want)
+		}
+	}
+}
+
+// CPU打满时, 超过maxFlight的并发请求被丢弃, 就是read-book/docker/test/loop的场景
+func Test_AdaptiveShedder_Drop(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	cpu := int64(950)
+	s := newShedder(clk, &cpu)
+
+	// 先跑出一个基线: 每个桶100个请求, 每个10ms, maxFlight = 100*10*10/1000 = 10
+	// 基线期间CPU不高
+	atomic.StoreInt64(&cpu, 0)
+	for i := 0; i < 9; i++ {
+		batch(clk, s, 100, 10*time.Millisecond)
+	}
+	atomic.StoreInt64(&cpu, 950)
+	if got := s.maxFlight(); got != 10 {
+		t.Fatalf("maxFlight %v", got)
+	}
+
+	// 堆积在途请求, CPU 95%时overloadFactor是0.5, 超过5个之后开始丢
+	var promises []Promise
+	dropped := 0
+	for i := 0; i < 100; i++ {
+		p, err := s.Allow()
+		if errors.Is(err, ErrServiceOverloaded) {
+			dropped++
+			continue
+		}
+		promises = append(promises, p)
+		// 一部分请求完成, avgFlying才会跟上来
+		if i%2 == 0 {
+			promises[0].Fail()
+			promises = promises[1:]
+		}
+	}
+	if dropped == 0 {
+		t.Fatal("should drop requests when cpu is high and too many requests are in flight")
+	}
+
+	// CPU降下来, 但是刚丢过请求, 冷却期内还是热的
+	atomic.StoreInt64(&cpu, 100)
+	if !s.stillHot() {
+		t.Fatal("should still be hot")
+	}
+
+	clk.Advance(coolOffDuration)
+	if s.stillHot() {
+		t.Fatal("should cool off")
+	}
+	if _, err := s.Allow(); err != nil {
+		t.Fatalf("err %v after cooling off", err)
+	}
+}
+
+func Test_AdaptiveShedder_Disable(t *testing.T) {
+	defer atomic.StoreInt32(&enabled, 1)
+	Disable()
+	if _, ok := NewAdaptiveShedder().(nopShedder); !ok {
+		t.Fatal("disabled shedder should be nop")
+	}
+}
+
+func Test_ShedderGroup(t *testing.T) {
+	g := NewShedderGroup(WithCPUUsage(func() int64 { return 0 }))
+	if g.GetShedder("/users") != g.GetShedder("/users") {
+		t.Fatal("same route should share the shedder")
+	}
+	if g.GetShedder("/users") == g.GetShedder("/orders") {
+		t.Fatal("routes should not share the shedder")
+	}
+}
+
+func Test_SheddingStat(t *testing.T) {
+	defer atomic.StoreInt32(&logEnabled, 0)
+	atomic.StoreInt32(&logEnabled, 1)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	st := newSheddingStat("api", func() int64 { return 800 })
+	for i := 0; i < 3; i++ {
+		st.IncrementTotal()
+		st.IncrementPass()
+	}
+	st.IncrementTotal()
+	st.IncrementDrop()
+
+	c := make(chan time.Time, 2)
+	c <- epoch
+	c <- epoch
+	close(c)
+	st.loop(c)
+
+	out := buf.String()
+	if !strings.Contains(out, "(api) shedding_stat_drop [1m], cpu: 800, total: 4, pass: 3, drop: 1") ||
+		!strings.Contains(out, "(api) shedding_stat [1m], cpu: 800, total: 0, pass: 0, drop: 0") {
+		t.Fatalf("log %s", out)
+	}
+}
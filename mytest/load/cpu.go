@@ -0,0 +1,177 @@
+package load
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+const (
+	cpuSampleInterval = time.Millisecond * 250
+	cpuBeta           = 0.95 // CPU使用率的滑动平均系数, 和go-zero的stat包一样
+)
+
+// ErrNoCgroup 不在cgroup里或者cgroup文件读不到
+var ErrNoCgroup = errors.New("load: no cgroup cpu files found")
+
+// CPUSource CPU的数据来源, 测试时用假的
+type CPUSource interface {
+	// Usage 累计用掉的CPU时间
+	Usage() (time.Duration, error)
+	// Limit 能用几个核, 没有限制时是机器的核数
+	Limit() (float64, error)
+}
+
+// cgroupCPU 从cgroup读CPU, v1和v2的文件由cgroup包解析
+type cgroupCPU struct {
+	cg *cgroup.Cgroup
+}
+
+// NewCgroupCPU 读root下的cgroup, 测试时指向fixture目录
+// 没有cgroup namespace时(systemd服务, v2的cgroupns=host)挂载点是根cgroup, 读不到限制, 这时用SelfCPU
+func NewCgroupCPU(root string) (CPUSource, error) {
+	cg, err := cgroup.Open(root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoCgroup, err)
+	}
+	return newCgroupCPU(cg)
+}
+
+// SelfCPU 读当前进程所在cgroup的CPU, 路径由cgroup.Self从/proc/self/cgroup解析
+func SelfCPU() (CPUSource, error) {
+	cg, err := cgroup.Self()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoCgroup, err)
+	}
+	return newCgroupCPU(cg)
+}
+
+// newCgroupCPU 先试读一次, 读不到时返回错误
+func newCgroupCPU(cg *cgroup.Cgroup) (CPUSource, error) {
+	src := cgroupCPU{cg: cg}
+	if _, err := src.Limit(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoCgroup, err)
+	}
+	return src, nil
+}
+
+func (c cgroupCPU) Usage() (time.Duration, error) {
+	stats, err := c.cg.CPU()
+	return stats.Usage, err
+}
+
+func (c cgroupCPU) Limit() (float64, error) {
+	stats, err := c.cg.CPU()
+	if err != nil {
+		return 0, err
+	}
+	if limit := stats.Limit(); limit > 0 {
+		return limit, nil
+	}
+	return float64(runtime.NumCPU()), nil
+}
+
+// CPUMonitor 定时采样, 算出最近的CPU使用率, 单位是千分之一, 1000表示用满了limit
+type CPUMonitor struct {
+	src   CPUSource
+	clock second.Clock
+
+	usage     uint64 // 千分比, float64的bits, 用整数存滑动平均会一直往下偏
+	lastUsage time.Duration
+	lastTime  time.Time
+	started   bool
+}
+
+// NewCPUMonitor 创建CPU使用率监控, clock为nil时用真实时间
+func NewCPUMonitor(src CPUSource, clock second.Clock) *CPUMonitor {
+	if clock == nil {
+		clock = second.RealClock
+	}
+	return &CPUMonitor{src: src, clock: clock}
+}
+
+// Usage 最近的CPU使用率, 900表示用了limit的90%
+func (m *CPUMonitor) Usage() int64 {
+	return int64(math.Round(math.Float64frombits(atomic.LoadUint64(&m.usage))))
+}
+
+// Sample 采样一次, Run里定时调用, 测试时直接调用
+func (m *CPUMonitor) Sample() error {
+	usage, err := m.src.Usage()
+	if err != nil {
+		return err
+	}
+	limit, err := m.src.Limit()
+	if err != nil {
+		return err
+	}
+
+	now := m.clock.Now()
+	if m.started && limit > 0 {
+		elapsed := now.Sub(m.lastTime)
+		if elapsed > 0 {
+			cur := float64(usage-m.lastUsage) / (float64(elapsed) * limit) * 1000
+			cur = math.Max(0, math.Min(cur, 1000))
+			prev := math.Float64frombits(atomic.LoadUint64(&m.usage))
+			atomic.StoreUint64(&m.usage, math.Float64bits(prev*cpuBeta+cur*(1-cpuBeta)))
+		}
+	}
+	m.started = true
+	m.lastUsage, m.lastTime = usage, now
+	return nil
+}
+
+// Run 每250ms采样一次, stop关闭时返回
+// cgroup文件读不到不会自己好, 采样出错时打一次日志就停止, 使用率停在最后的值
+func (m *CPUMonitor) Run(stop <-chan struct{}) {
+	ticker := m.clock.NewTicker(cpuSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			if err := m.Sample(); err != nil {
+				log.Printf("load: sample cpu: %s, stop sampling", err)
+				return
+			}
+		}
+	}
+}
+
+var (
+	defaultMonitorOnce sync.Once
+	defaultMonitor     *CPUMonitor
+)
+
+// CPUUsage 当前容器的CPU使用率, 第一次调用时开始采样
+// 读不到cgroup时一直返回0, 也就是不会因为CPU高而降载
+func CPUUsage() int64 {
+	defaultMonitorOnce.Do(func() {
+		src, err := SelfCPU()
+		if err != nil {
+			log.Printf("load: %s, cpu usage is always 0", err)
+			return
+		}
+		m := NewCPUMonitor(src, nil)
+		if err := m.Sample(); err != nil {
+			log.Printf("load: sample cpu: %s, cpu usage is always 0", err)
+			return
+		}
+		defaultMonitor = m
+		go m.Run(nil)
+	})
+	if defaultMonitor == nil {
+		return 0
+	}
+	return defaultMonitor.Usage()
+}
@@ -0,0 +1,221 @@
+package load
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeFiles 在dir下按相对路径写fixture文件
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_CgroupCPU(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantUsage time.Duration
+		wantLimit float64
+	}{
+		{
+			name: "v2",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory pids\n",
+				"cpu.stat":           "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+				"cpu.max":            "150000 100000\n",
+			},
+			wantUsage: 2500 * time.Millisecond,
+			wantLimit: 1.5,
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"cgroup.controllers": "cpu\n",
+				"cpu.stat":           "usage_usec 1\n",
+				"cpu.max":            "max 100000\n",
+			},
+			wantUsage: time.Microsecond,
+			wantLimit: float64(runtime.NumCPU()),
+		},
+		{
+			name: "v1",
+			files: map[string]string{
+				"cpuacct/cpuacct.usage": "3000000000\n",
+				"cpuacct/cpuacct.stat":  "user 200\nsystem 100\n",
+				"cpu/cpu.cfs_quota_us":  "50000\n",
+				"cpu/cpu.cfs_period_us": "100000\n",
+				"cpu/cpu.stat":          "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n",
+			},
+			wantUsage: 3 * time.Second,
+			wantLimit: 0.5,
+		},
+		{
+			name: "v1 combined",
+			files: map[string]string{
+				"cpu,cpuacct/cpuacct.usage":     "42\n",
+				"cpu,cpuacct/cpuacct.stat":      "user 0\nsystem 0\n",
+				"cpu,cpuacct/cpu.cfs_quota_us":  "-1\n",
+				"cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+				"cpu,cpuacct/cpu.stat":          "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n",
+			},
+			wantUsage: 42,
+			wantLimit: float64(runtime.NumCPU()),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			c, err := NewCgroupCPU(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u, err := c.Usage(); err != nil || u != tt.wantUsage {
+				t.Fatalf("usage %v, err %v", u, err)
+			}
+			if l, err := c.Limit(); err != nil || l != tt.wantLimit {
+				t.Fatalf("limit %v, err %v", l, err)
+			}
+		})
+	}
+
+	if _, err := NewCgroupCPU(t.TempDir()); !errors.Is(err, ErrNoCgroup) {
+		t.Fatalf("empty dir err %v", err)
+	}
+	// 是cgroup但是没有cpu的文件
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"cgroup.controllers": "memory\n"})
+	if _, err := NewCgroupCPU(dir); !errors.Is(err, ErrNoCgroup) {
+		t.Fatalf("no cpu files err %v", err)
+	}
+}
+
+// fakeCPU 测试里手动累加CPU时间
+type fakeCPU struct {
+	usage int64 // time.Duration
+	limit float64
+	err   error
+}
+
+func (f *fakeCPU) add(d time.Duration) { atomic.AddInt64(&f.usage, int64(d)) }
+
+func (f *fakeCPU) Usage() (time.Duration, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	return time.Duration(atomic.LoadInt64(&f.usage)), nil
+}
+
+func (f *fakeCPU) Limit() (float64, error) { return f.limit, nil }
+
+func Test_CPUMonitor(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	src := &fakeCPU{limit: 2}
+	m := NewCPUMonitor(src, clk)
+	m.Sample()
+
+	// 2个核的限制用了1.8个核, 滑动平均之后接近900
+	for i := 0; i < 200; i++ {
+		clk.Advance(cpuSampleInterval)
+		src.add(time.Duration(1.8 * float64(cpuSampleInterval)))
+		if err := m.Sample(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u := m.Usage(); u < 890 || u > 900 {
+		t.Fatalf("usage %d", u)
+	}
+
+	// 空闲之后慢慢降下来
+	for i := 0; i < 200; i++ {
+		clk.Advance(cpuSampleInterval)
+		m.Sample()
+	}
+	if u := m.Usage(); u > 10 {
+		t.Fatalf("usage %d after idle", u)
+	}
+}
+
+func Test_CPUMonitor_Run(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	src := &fakeCPU{limit: 1}
+	m := NewCPUMonitor(src, clk)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		m.Run(stop)
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	waitFor(t, func() bool {
+		src.add(cpuSampleInterval)
+		clk.Advance(cpuSampleInterval)
+		return m.Usage() > 0
+	})
+	close(stop)
+	<-done
+}
+
+// Test_CPUMonitor_RunError 读不到cgroup时只打一次日志就停, 不会每250ms刷一次
+func Test_CPUMonitor_RunError(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	m := NewCPUMonitor(&fakeCPU{err: errors.New("no cpu.max")}, clk)
+	done := make(chan struct{})
+	go func() {
+		m.Run(nil)
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(cpuSampleInterval)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run should stop after a sample error")
+	}
+}
+
+// SelfCPU在cgroup v1和v2上都应该读到当前进程的cgroup, 不在cgroup里的环境跳过
+func Test_SelfCPU(t *testing.T) {
+	src, err := SelfCPU()
+	if err != nil {
+		t.Skip(err)
+	}
+	if l, err := src.Limit(); err != nil || l <= 0 {
+		t.Fatalf("limit %v, err %v", l, err)
+	}
+	if _, err := src.Usage(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
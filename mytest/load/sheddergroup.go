@@ -0,0 +1,33 @@
+package load
+
+import "sync"
+
+// 见 read-source-code/go-zero/load/sheddergroup.md
+
+// ShedderGroup 按key(一般是路由)管理Shedder, 每个路由单独统计
+type ShedderGroup struct {
+	options  []ShedderOption
+	lock     sync.Mutex
+	shedders map[string]Shedder
+}
+
+// NewShedderGroup 创建ShedderGroup, opts给每个Shedder用
+func NewShedderGroup(opts ...ShedderOption) *ShedderGroup {
+	return &ShedderGroup{
+		options:  opts,
+		shedders: make(map[string]Shedder),
+	}
+}
+
+// GetShedder 返回key对应的Shedder, 没有就创建
+func (g *ShedderGroup) GetShedder(key string) Shedder {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	s, ok := g.shedders[key]
+	if !ok {
+		s = NewAdaptiveShedder(g.options...)
+		g.shedders[key] = s
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+package load
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 见 read-source-code/go-zero/load/sheddingstat.md
+
+type (
+	// SheddingStat 降载的统计, 每分钟打一次日志并清零
+	SheddingStat struct {
+		name     string
+		cpuUsage func() int64
+		total    int64
+		pass     int64
+		drop     int64
+	}
+
+	// snapshot 一分钟内的统计
+	snapshot struct {
+		Total int64
+		Pass  int64
+		Drop  int64
+	}
+)
+
+// NewSheddingStat 创建降载统计, 后台每分钟打一次日志
+func NewSheddingStat(name string) *SheddingStat {
+	st := newSheddingStat(name, CPUUsage)
+	go st.run(second.RealClock)
+	return st
+}
+
+func newSheddingStat(name string, cpuUsage func() int64) *SheddingStat {
+	return &SheddingStat{name: name, cpuUsage: cpuUsage}
+}
+
+// IncrementTotal 总请求数加1
+func (s *SheddingStat) IncrementTotal() {
+	atomic.AddInt64(&s.total, 1)
+}
+
+// IncrementPass 通过的请求数加1
+func (s *SheddingStat) IncrementPass() {
+	atomic.AddInt64(&s.pass, 1)
+}
+
+// IncrementDrop 丢弃的请求数加1
+func (s *SheddingStat) IncrementDrop() {
+	atomic.AddInt64(&s.drop, 1)
+}
+
+func (s *SheddingStat) loop(c <-chan time.Time) {
+	for range c {
+		st := s.reset()
+		if atomic.LoadInt32(&logEnabled) == 0 {
+			continue
+		}
+
+		c := s.cpuUsage()
+		if st.Drop == 0 {
+			log.Printf("(%s) shedding_stat [1m], cpu: %d, total: %d, pass: %d, drop: %d",
+				s.name, c, st.Total, st.Pass, st.Drop)
+		} else {
+			log.Printf("(%s) shedding_stat_drop [1m], cpu: %d, total: %d, pass: %d, drop: %d",
+				s.name, c, st.Total, st.Pass, st.Drop)
+		}
+	}
+}
+
+func (s *SheddingStat) reset() snapshot {
+	return snapshot{
+		Total: atomic.SwapInt64(&s.total, 0),
+		Pass:  atomic.SwapInt64(&s.pass, 0),
+		Drop:  atomic.SwapInt64(&s.drop, 0),
+	}
+}
+
+func (s *SheddingStat) run(clock second.Clock) {
+	ticker := clock.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	s.loop(ticker.C())
+}
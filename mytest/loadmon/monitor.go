@@ -0,0 +1,60 @@
+package loadmon
+
+import "time"
+
+// Monitor 定时采样, 算出变化率, 交给所有Writer
+type Monitor struct {
+	sampler  *Sampler
+	interval time.Duration
+	writers  []Writer
+	prev     *Sample
+}
+
+// NewMonitor interval是采样间隔
+func NewMonitor(interval time.Duration, writers []Writer, opts ...Option) *Monitor {
+	return &Monitor{
+		sampler:  NewSampler(opts...),
+		interval: interval,
+		writers:  writers,
+	}
+}
+
+// Step 采样一次并输出, Run里定时调用, 测试时直接调用
+func (m *Monitor) Step() (Row, error) {
+	s, err := m.sampler.Read()
+	if err != nil {
+		return Row{}, err
+	}
+	row := Row{Sample: s}
+	if m.prev != nil {
+		row.Rate = Delta(*m.prev, s)
+	}
+	m.prev = &s
+
+	for _, w := range m.writers {
+		if err := w.Write(row); err != nil {
+			return row, err
+		}
+	}
+	return row, nil
+}
+
+// Run 马上采样一次, 之后每个interval一次, 采样了count次(0表示不限)或者stop关闭时返回
+func (m *Monitor) Run(stop <-chan struct{}, count int) error {
+	ticker := m.sampler.opts.clock.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for n := 1; ; n++ {
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+		if count > 0 && n >= count {
+			return nil
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
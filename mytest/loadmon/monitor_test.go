@@ -0,0 +1,122 @@
+package loadmon
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// copyDir 把fixture复制到dst, 覆盖已有的文件, 模拟两次采样之间文件的变化
+func copyDir(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dst, filepath.Dir(rel)), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type rowRecorder struct {
+	mu   sync.Mutex
+	rows []Row
+}
+
+func (r *rowRecorder) Write(row Row) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rows = append(r.rows, row)
+	return nil
+}
+
+func (r *rowRecorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.rows)
+}
+
+func Test_Monitor_Run(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, "testdata/t0", dir)
+	cg, err := cgroup.Open(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := second.NewFakeClock(epoch)
+	rec := &rowRecorder{}
+	m := NewMonitor(10*time.Second, []Writer{rec},
+		WithProc(filepath.Join(dir, "proc")), WithCgroup(cg), WithClock(clk))
+
+	done := make(chan error, 1)
+	go func() { done <- m.Run(nil, 2) }()
+
+	// 第一次采样之后才换成t1
+	waitFor(t, func() bool { return rec.len() == 1 })
+	copyDir(t, "testdata/t1", dir)
+	clk.BlockUntil(1)
+	clk.Advance(10 * time.Second)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rec.rows[0].Rate != (Rate{}) {
+		t.Fatalf("first row has rate %+v", rec.rows[0].Rate)
+	}
+	if r := rec.rows[1].Rate; r.CPUSome != 20 || r.Throttled != 30 || r.Usage != 1.5 {
+		t.Fatalf("second row rate = %+v", r)
+	}
+}
+
+func Test_Monitor_Stop(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	rec := &rowRecorder{}
+	m := NewMonitor(time.Second, []Writer{rec}, WithProc("testdata/t0/proc"), WithClock(clk))
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- m.Run(stop, 0) }()
+
+	waitFor(t, func() bool {
+		clk.Advance(time.Second)
+		return rec.len() >= 3
+	})
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Monitor_Error(t *testing.T) {
+	m := NewMonitor(time.Second, nil, WithProc(t.TempDir()))
+	if err := m.Run(nil, 0); !os.IsNotExist(err) {
+		t.Fatalf("Run() = %v, want not exist", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,54 @@
+package loadmon
+
+import "time"
+
+// Rate 两次采样之间的变化
+// PSI的avg10是内核算的10秒平均, 采样间隔短时用total的增量更及时
+type Rate struct {
+	Interval time.Duration
+
+	// CPUSome 等CPU的时间占比, 百分比, 和PSI的avg一个单位
+	CPUSome, MemorySome, MemoryFull, IOSome, IOFull float64
+
+	// Usage cgroup用了多少核
+	Usage float64
+	// Throttled 这段时间里被限流的period占比, 百分比
+	Throttled float64
+	// ThrottledTime 每秒被限流的时间
+	ThrottledTime time.Duration
+}
+
+// Delta 算prev到cur的变化, 间隔不大于0时返回零值
+func Delta(prev, cur Sample) Rate {
+	r := Rate{Interval: cur.Time.Sub(prev.Time)}
+	if r.Interval <= 0 {
+		return Rate{}
+	}
+
+	pct := func(a, b time.Duration) float64 {
+		if b < a {
+			// 计数器重置了(比如cgroup重建)
+			return 0
+		}
+		return float64(b-a) / float64(r.Interval) * 100
+	}
+	if prev.HasPSI && cur.HasPSI {
+		r.CPUSome = pct(prev.CPU.Some.Total, cur.CPU.Some.Total)
+		r.MemorySome = pct(prev.Memory.Some.Total, cur.Memory.Some.Total)
+		r.MemoryFull = pct(prev.Memory.Full.Total, cur.Memory.Full.Total)
+		r.IOSome = pct(prev.IO.Some.Total, cur.IO.Some.Total)
+		r.IOFull = pct(prev.IO.Full.Total, cur.IO.Full.Total)
+	}
+
+	if prev.HasCgroup && cur.HasCgroup {
+		p, c := prev.Cgroup, cur.Cgroup
+		r.Usage = pct(p.Usage, c.Usage) / 100
+		if c.Periods > p.Periods && c.ThrottledPeriods >= p.ThrottledPeriods {
+			r.Throttled = float64(c.ThrottledPeriods-p.ThrottledPeriods) / float64(c.Periods-p.Periods) * 100
+		}
+		if c.ThrottledTime >= p.ThrottledTime {
+			r.ThrottledTime = time.Duration(float64(c.ThrottledTime-p.ThrottledTime) / r.Interval.Seconds())
+		}
+	}
+	return r
+}
@@ -0,0 +1,56 @@
+package loadmon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/second"
+)
+
+func readFixture(t *testing.T, dir string, at time.Time) Sample {
+	t.Helper()
+	s, err := fixtureSampler(t, dir, second.NewFakeClock(at)).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func Test_Delta(t *testing.T) {
+	prev := readFixture(t, "testdata/t0", epoch)
+	cur := readFixture(t, "testdata/t1", epoch.Add(10*time.Second))
+
+	got := Delta(prev, cur)
+	want := Rate{
+		Interval:      10 * time.Second,
+		CPUSome:       20,
+		MemorySome:    5,
+		MemoryFull:    2.5,
+		IOSome:        10,
+		IOFull:        5,
+		Usage:         1.5,
+		Throttled:     30,
+		ThrottledTime: 200 * time.Millisecond,
+	}
+	if got != want {
+		t.Fatalf("Delta() = %+v, want %+v", got, want)
+	}
+}
+
+// Test_Delta_Reset 计数器变小(cgroup重建), 时间倒退, 缺数据时都不报出负数
+func Test_Delta_Reset(t *testing.T) {
+	prev := readFixture(t, "testdata/t1", epoch)
+	cur := readFixture(t, "testdata/t0", epoch.Add(10*time.Second))
+	if got := Delta(prev, cur); got != (Rate{Interval: 10 * time.Second}) {
+		t.Fatalf("reset: Delta() = %+v", got)
+	}
+
+	if got := Delta(cur, prev); got != (Rate{}) {
+		t.Fatalf("time goes back: Delta() = %+v", got)
+	}
+
+	nopsi := readFixture(t, "testdata/nopsi", epoch.Add(20*time.Second))
+	if got := Delta(cur, nopsi); got != (Rate{Interval: 10 * time.Second}) {
+		t.Fatalf("no psi: Delta() = %+v", got)
+	}
+}
@@ -0,0 +1,222 @@
+package loadmon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 观察read-book/docker/test/load-average把负载推上去的过程, 不用再手动跑uptime和top
+// 定时读/proc/loadavg, /proc/pressure/{cpu,memory,io}和cgroup的cpu.stat
+
+// PSILine /proc/pressure/xxx的一行, Avg是百分比, Total是累计等待的时间
+type PSILine struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  time.Duration
+}
+
+// Pressure 一个资源的PSI, some是至少一个任务在等, full是所有任务都在等
+// 老内核的cpu没有full这一行
+type Pressure struct {
+	Some PSILine
+	Full PSILine
+}
+
+// Sample 一次采样
+type Sample struct {
+	Time time.Time
+
+	Load1, Load5, Load15 float64
+	// Running 正在运行的任务数, Tasks 总的任务数, 对应loadavg的第4列
+	Running, Tasks int
+
+	// HasPSI 内核没开PSI(CONFIG_PSI)时是false
+	HasPSI          bool
+	CPU, Memory, IO Pressure
+
+	// HasCgroup cgroup读不到时是false
+	HasCgroup bool
+	Cgroup    cgroup.CPUStats
+}
+
+type options struct {
+	proc  string
+	cg    *cgroup.Cgroup
+	clock second.Clock
+}
+
+// Option Sampler的选项
+type Option func(*options)
+
+// WithProc proc的挂载点, 默认/proc, 测试时指向fixture目录
+func WithProc(root string) Option {
+	return func(o *options) {
+		o.proc = root
+	}
+}
+
+// WithCgroup 从哪个cgroup读cpu.stat, 默认不读
+func WithCgroup(cg *cgroup.Cgroup) Option {
+	return func(o *options) {
+		o.cg = cg
+	}
+}
+
+// WithClock 采样时间和定时用的时钟, 测试时用FakeClock
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func buildOptions(opts []Option) options {
+	o := options{proc: "/proc", clock: second.RealClock}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Sampler 读一次数据
+type Sampler struct {
+	opts options
+}
+
+// NewSampler 创建Sampler
+func NewSampler(opts ...Option) *Sampler {
+	return &Sampler{opts: buildOptions(opts)}
+}
+
+// Read loadavg必须能读到, PSI和cgroup读不到时对应的HasXxx是false
+func (s *Sampler) Read() (Sample, error) {
+	smp := Sample{Time: s.opts.clock.Now()}
+
+	f, err := os.Open(filepath.Join(s.opts.proc, "loadavg"))
+	if err != nil {
+		return smp, err
+	}
+	err = parseLoadavg(f, &smp)
+	f.Close()
+	if err != nil {
+		return smp, err
+	}
+
+	smp.HasPSI = true
+	for name, p := range map[string]*Pressure{"cpu": &smp.CPU, "memory": &smp.Memory, "io": &smp.IO} {
+		if *p, err = readPressure(filepath.Join(s.opts.proc, "pressure", name)); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return smp, err
+			}
+			smp.HasPSI = false
+		}
+	}
+	if !smp.HasPSI {
+		smp.CPU, smp.Memory, smp.IO = Pressure{}, Pressure{}, Pressure{}
+	}
+
+	if s.opts.cg != nil {
+		if smp.Cgroup, err = s.opts.cg.CPU(); err == nil {
+			smp.HasCgroup = true
+		}
+	}
+	return smp, nil
+}
+
+// parseLoadavg 格式是 "0.07 0.07 0.08 2/72 14160"
+func parseLoadavg(r io.Reader, smp *Sample) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 {
+		return fmt.Errorf("loadmon: bad loadavg %q", data)
+	}
+
+	loads := []*float64{&smp.Load1, &smp.Load5, &smp.Load15}
+	for i, p := range loads {
+		if *p, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return fmt.Errorf("loadmon: bad loadavg %q", data)
+		}
+	}
+	running, tasks, ok := strings.Cut(fields[3], "/")
+	if !ok {
+		return fmt.Errorf("loadmon: bad loadavg %q", data)
+	}
+	if smp.Running, err = strconv.Atoi(running); err != nil {
+		return fmt.Errorf("loadmon: bad loadavg %q", data)
+	}
+	if smp.Tasks, err = strconv.Atoi(tasks); err != nil {
+		return fmt.Errorf("loadmon: bad loadavg %q", data)
+	}
+	return nil
+}
+
+func readPressure(path string) (Pressure, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Pressure{}, err
+	}
+	defer f.Close()
+	return parsePressure(f)
+}
+
+// parsePressure 每行是 "some avg10=2.19 avg60=1.12 avg300=1.06 total=38199701", total是微秒
+func parsePressure(r io.Reader) (Pressure, error) {
+	var p Pressure
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var line *PSILine
+		switch fields[0] {
+		case "some":
+			line = &p.Some
+		case "full":
+			line = &p.Full
+		default:
+			return p, fmt.Errorf("loadmon: bad pressure line %q", s.Text())
+		}
+		for _, kv := range fields[1:] {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return p, fmt.Errorf("loadmon: bad pressure line %q", s.Text())
+			}
+			if k == "total" {
+				us, err := strconv.ParseUint(v, 10, 64)
+				if err != nil {
+					return p, fmt.Errorf("loadmon: bad pressure line %q", s.Text())
+				}
+				line.Total = time.Duration(us) * time.Microsecond
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return p, fmt.Errorf("loadmon: bad pressure line %q", s.Text())
+			}
+			switch k {
+			case "avg10":
+				line.Avg10 = f
+			case "avg60":
+				line.Avg60 = f
+			case "avg300":
+				line.Avg300 = f
+			}
+		}
+	}
+	return p, s.Err()
+}
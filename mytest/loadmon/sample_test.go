@@ -0,0 +1,86 @@
+package loadmon
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fixtureSampler 读testdata/name下的proc和cgroup
+func fixtureSampler(t *testing.T, dir string, clk second.Clock) *Sampler {
+	t.Helper()
+	opts := []Option{WithProc(filepath.Join(dir, "proc")), WithClock(clk)}
+	if cg, err := cgroup.Open(filepath.Join(dir, "cgroup")); err == nil {
+		opts = append(opts, WithCgroup(cg))
+	}
+	return NewSampler(opts...)
+}
+
+func Test_Sampler_Read(t *testing.T) {
+	s, err := fixtureSampler(t, "testdata/t0", second.NewFakeClock(epoch)).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Time.Equal(epoch) {
+		t.Fatalf("Time = %v", s.Time)
+	}
+	if s.Load1 != 0.5 || s.Load5 != 0.3 || s.Load15 != 0.1 || s.Running != 1 || s.Tasks != 80 {
+		t.Fatalf("loadavg = %v %v %v %d/%d", s.Load1, s.Load5, s.Load15, s.Running, s.Tasks)
+	}
+	if !s.HasPSI {
+		t.Fatal("HasPSI = false")
+	}
+	wantCPU := Pressure{Some: PSILine{Avg10: 5, Avg60: 1, Avg300: 0.5, Total: time.Second}}
+	if s.CPU != wantCPU {
+		t.Fatalf("CPU = %+v, want %+v", s.CPU, wantCPU)
+	}
+	if s.Memory.Full.Total != 100*time.Millisecond || s.IO.Some.Avg10 != 1.5 {
+		t.Fatalf("Memory = %+v IO = %+v", s.Memory, s.IO)
+	}
+	if !s.HasCgroup || s.Cgroup.Limit() != 2 || s.Cgroup.ThrottledPeriods != 10 {
+		t.Fatalf("Cgroup = %v %+v", s.HasCgroup, s.Cgroup)
+	}
+}
+
+// Test_Sampler_NoPSI 老内核没有/proc/pressure, 不在cgroup里
+func Test_Sampler_NoPSI(t *testing.T) {
+	s, err := fixtureSampler(t, "testdata/nopsi", second.NewFakeClock(epoch)).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.HasPSI || s.HasCgroup || s.Load1 != 1 || s.Tasks != 90 {
+		t.Fatalf("Read() = %+v", s)
+	}
+}
+
+func Test_parseLoadavg(t *testing.T) {
+	for _, bad := range []string{"", "0.1 0.2 0.3", "a 0.2 0.3 1/2 3", "0.1 0.2 0.3 12 3", "0.1 0.2 0.3 x/2 3"} {
+		var s Sample
+		if err := parseLoadavg(strings.NewReader(bad), &s); err == nil {
+			t.Fatalf("parseLoadavg(%q) want error", bad)
+		}
+	}
+}
+
+func Test_parsePressure(t *testing.T) {
+	// 老内核的cpu只有some
+	p, err := parsePressure(strings.NewReader("some avg10=0.25 avg60=0.10 avg300=0.00 total=1234\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Some.Avg10 != 0.25 || p.Some.Total != 1234*time.Microsecond || p.Full != (PSILine{}) {
+		t.Fatalf("parsePressure() = %+v", p)
+	}
+
+	for _, bad := range []string{"half avg10=1", "some avg10", "some avg10=x", "some total=-1"} {
+		if _, err := parsePressure(strings.NewReader(bad)); err == nil {
+			t.Fatalf("parsePressure(%q) want error", bad)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package loadmon
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Row 输出的一行, 第一次采样没有Rate
+type Row struct {
+	Sample
+	Rate Rate
+}
+
+// Writer 输出每一行
+type Writer interface {
+	Write(Row) error
+}
+
+// column 表格和CSV共用的列
+type column struct {
+	name  string
+	width int
+	value func(Row) string
+}
+
+func fmtFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+var columns = []column{
+	{"time", 8, func(r Row) string { return r.Time.Format("15:04:05") }},
+	{"load1", 6, func(r Row) string { return fmtFloat(r.Load1) }},
+	{"load5", 6, func(r Row) string { return fmtFloat(r.Load5) }},
+	{"load15", 6, func(r Row) string { return fmtFloat(r.Load15) }},
+	{"running", 7, func(r Row) string { return strconv.Itoa(r.Running) }},
+	{"tasks", 6, func(r Row) string { return strconv.Itoa(r.Tasks) }},
+	{"cpu_some10", 10, func(r Row) string { return fmtFloat(r.CPU.Some.Avg10) }},
+	{"cpu_some", 8, func(r Row) string { return fmtFloat(r.Rate.CPUSome) }},
+	{"mem_some", 8, func(r Row) string { return fmtFloat(r.Rate.MemorySome) }},
+	{"mem_full", 8, func(r Row) string { return fmtFloat(r.Rate.MemoryFull) }},
+	{"io_some", 8, func(r Row) string { return fmtFloat(r.Rate.IOSome) }},
+	{"io_full", 8, func(r Row) string { return fmtFloat(r.Rate.IOFull) }},
+	{"usage", 6, func(r Row) string { return fmtFloat(r.Rate.Usage) }},
+	{"limit", 6, func(r Row) string { return fmtFloat(r.Cgroup.Limit()) }},
+	{"throttled", 9, func(r Row) string { return fmtFloat(r.Rate.Throttled) }},
+	{"throttled_ms", 12, func(r Row) string {
+		return strconv.FormatInt(r.Rate.ThrottledTime.Milliseconds(), 10)
+	}},
+}
+
+// TableWriter 对齐的表格, 每repeat行重新打一次表头, 滚动时也能看到列名
+type TableWriter struct {
+	w      io.Writer
+	repeat int
+	rows   int
+}
+
+// NewTableWriter repeat不大于0时只在开头打一次表头
+func NewTableWriter(w io.Writer, repeat int) *TableWriter {
+	return &TableWriter{w: w, repeat: repeat}
+}
+
+func (t *TableWriter) Write(r Row) error {
+	if t.rows == 0 || (t.repeat > 0 && t.rows%t.repeat == 0) {
+		if err := t.line(func(c column) string { return c.name }); err != nil {
+			return err
+		}
+	}
+	t.rows++
+	return t.line(func(c column) string { return c.value(r) })
+}
+
+func (t *TableWriter) line(cell func(column) string) error {
+	var b strings.Builder
+	for i, c := range columns {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%*s", c.width, cell(c))
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+// CSVWriter CSV, 第一行是表头, 时间用RFC3339方便别的工具解析
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVWriter 每行写完就Flush, 中途退出也不会丢
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+func (c *CSVWriter) Write(r Row) error {
+	if !c.header {
+		c.header = true
+		names := make([]string, len(columns))
+		for i, col := range columns {
+			names[i] = col.name
+		}
+		if err := c.w.Write(names); err != nil {
+			return err
+		}
+	}
+
+	record := make([]string, len(columns))
+	for i, col := range columns {
+		record[i] = col.value(r)
+	}
+	record[0] = r.Time.Format(time.RFC3339)
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,80 @@
+package loadmon
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRows(t *testing.T) []Row {
+	prev := readFixture(t, "testdata/t0", epoch)
+	cur := readFixture(t, "testdata/t1", epoch.Add(10*time.Second))
+	return []Row{{Sample: prev}, {Sample: cur, Rate: Delta(prev, cur)}}
+}
+
+func Test_TableWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTableWriter(&buf, 2)
+	rows := testRows(t)
+	for _, r := range append(rows, rows[1]) {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// 表头, 两行, 再一次表头, 一行
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines:\n%s", len(lines), buf.String())
+	}
+	if lines[0] != lines[3] || !strings.HasPrefix(strings.TrimSpace(lines[0]), "time") {
+		t.Fatalf("header = %q, %q", lines[0], lines[3])
+	}
+	for i, line := range lines {
+		if len(line) != len(lines[0]) {
+			t.Fatalf("line %d is not aligned:\n%s", i, buf.String())
+		}
+	}
+	fields := strings.Fields(lines[2])
+	if fields[0] != "00:00:10" || fields[1] != "2.10" || fields[7] != "20.00" || fields[len(fields)-1] != "200" {
+		t.Fatalf("row = %q", lines[2])
+	}
+}
+
+func Test_CSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf)
+	for _, r := range testRows(t) {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records", len(records))
+	}
+	get := func(row int, name string) string {
+		for i, n := range records[0] {
+			if n == name {
+				return records[row][i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	if got := get(2, "time"); got != "2024-01-01T00:00:10Z" {
+		t.Fatalf("time = %s", got)
+	}
+	if get(1, "cpu_some") != "0.00" || get(2, "cpu_some") != "20.00" {
+		t.Fatalf("cpu_some = %s %s", get(1, "cpu_some"), get(2, "cpu_some"))
+	}
+	if get(2, "usage") != "1.50" || get(2, "limit") != "2.00" || get(2, "throttled") != "30.00" {
+		t.Fatalf("records = %v", records)
+	}
+}
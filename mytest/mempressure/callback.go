@@ -0,0 +1,43 @@
+package mempressure
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/pprof"
+	"time"
+)
+
+// FreeOSMemory 强制GC并把空闲内存还给系统, 释放完自己的缓存之后调用效果最好
+func FreeOSMemory() Callback {
+	return func(Event) {
+		debug.FreeOSMemory()
+	}
+}
+
+// WriteHeapProfile 把堆的profile写到dir下, 文件名带时间, 事后分析是谁占的内存
+func WriteHeapProfile(dir string) Callback {
+	return func(e Event) {
+		name := fmt.Sprintf("heap-%s-%s.pprof", e.Level, time.Now().Format("20060102-150405.000"))
+		path := filepath.Join(dir, name)
+		if err := writeHeapProfile(path); err != nil {
+			log.Printf("mempressure: write heap profile: %s", err)
+			return
+		}
+		log.Printf("mempressure: heap profile written to %s", path)
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,113 @@
+package mempressure
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+)
+
+// Reading 一次读到的内存数据, 单位是字节
+type Reading struct {
+	// Heap 堆上还在用的内存, runtime.MemStats.HeapAlloc
+	Heap uint64
+	// RSS 进程的常驻内存, /proc/self/status的VmRSS
+	RSS uint64
+	// Usage cgroup的memory.current(v1是memory.usage_in_bytes), 包括page cache
+	Usage uint64
+	// InactiveFile 可以回收的page cache, 算压力时从Usage里减掉
+	InactiveFile uint64
+	// Limit cgroup的内存上限, 0表示没有限制
+	Limit uint64
+	// Events cgroup的memory.events
+	Events cgroup.MemoryEvents
+}
+
+// Used 取几个值里最大的, 堆没多大但是cgo或者page cache占满了也算
+func (r Reading) Used() uint64 {
+	workingSet := uint64(0)
+	if r.Usage > r.InactiveFile {
+		workingSet = r.Usage - r.InactiveFile
+	}
+	return max(r.Heap, r.RSS, workingSet)
+}
+
+// Source 内存数据的来源, 测试时用假的
+type Source interface {
+	Read() (Reading, error)
+}
+
+// SourceFunc 把函数当作Source
+type SourceFunc func() (Reading, error)
+
+func (f SourceFunc) Read() (Reading, error) {
+	return f()
+}
+
+// systemSource 从runtime, /proc/self/status和cgroup读
+type systemSource struct {
+	cg         *cgroup.Cgroup
+	statusPath string
+}
+
+// NewSource cg为nil时只读堆和RSS
+func NewSource(cg *cgroup.Cgroup) Source {
+	return &systemSource{cg: cg, statusPath: "/proc/self/status"}
+}
+
+func (s *systemSource) Read() (Reading, error) {
+	var r Reading
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	r.Heap = ms.HeapAlloc
+
+	f, err := os.Open(s.statusPath)
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+	if r.RSS, err = parseRSS(f); err != nil {
+		return r, err
+	}
+
+	if s.cg == nil {
+		return r, nil
+	}
+	m, err := s.cg.Memory()
+	if err != nil {
+		return r, err
+	}
+	r.Usage, r.InactiveFile, r.Limit = m.Usage, m.InactiveFile, m.Limit
+	// v1老内核可能没有memory.oom_control, 事件读不到不影响按比例判断
+	r.Events, _ = s.cg.MemoryEvents()
+	return r, nil
+}
+
+// parseRSS 找 "VmRSS:     1234 kB" 这一行
+func parseRSS(r io.Reader) (uint64, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[2] != "kB" {
+			return 0, fmt.Errorf("mempressure: bad VmRSS line %q", line)
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("mempressure: bad VmRSS line %q", line)
+		}
+		return kb * 1024, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("mempressure: no VmRSS in status")
+}
@@ -0,0 +1,81 @@
+package mempressure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+)
+
+func Test_parseRSS(t *testing.T) {
+	status := "Name:\tmain\nVmPeak:\t  812340 kB\nVmRSS:\t    5120 kB\nThreads:\t5\n"
+	rss, err := parseRSS(strings.NewReader(status))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rss != 5*mb {
+		t.Fatalf("rss = %d, want %d", rss, 5*mb)
+	}
+
+	if _, err := parseRSS(strings.NewReader("Name:\tmain\n")); err == nil {
+		t.Fatal("want error without VmRSS")
+	}
+}
+
+func Test_Reading_Used(t *testing.T) {
+	tests := []struct {
+		r    Reading
+		want uint64
+	}{
+		{Reading{Heap: 3, RSS: 5}, 5},
+		{Reading{Heap: 9, RSS: 5}, 9},
+		{Reading{RSS: 5, Usage: 20, InactiveFile: 10}, 10},
+		{Reading{RSS: 5, Usage: 10, InactiveFile: 20}, 5},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Used(); got != tt.want {
+			t.Fatalf("%+v Used() = %d, want %d", tt.r, got, tt.want)
+		}
+	}
+}
+
+// Test_systemSource 用假的cgroup目录和status文件
+func Test_systemSource(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"cgroup.controllers": "memory\n",
+		"memory.max":         "104857600\n",
+		"memory.current":     "52428800\n",
+		"memory.stat":        "anon 20971520\nfile 31457280\ninactive_file 10485760\n",
+		"memory.events":      "low 0\nhigh 2\nmax 1\noom 0\noom_kill 0\n",
+		"status":             "VmRSS:\t 20480 kB\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cg, err := cgroup.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &systemSource{cg: cg, statusPath: filepath.Join(root, "status")}
+
+	r, err := src.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Heap == 0 {
+		t.Fatal("heap is 0")
+	}
+	r.Heap = 0
+	want := Reading{
+		RSS: 20 * mb, Usage: 50 * mb, InactiveFile: 10 * mb, Limit: 100 * mb,
+		Events: cgroup.MemoryEvents{High: 2, Max: 1},
+	}
+	if r != want {
+		t.Fatalf("Read() = %+v, want %+v", r, want)
+	}
+}
@@ -0,0 +1,273 @@
+package mempressure
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+// 内存压力监控, 在被容器OOM kill之前通知调用方
+// read-book/docker/test/memlimit每秒打印一次Alloc/Sys/NumGC, 一直到被kill, 中间没有机会做任何事
+// Watcher定时读堆, RSS和cgroup, 按占上限的比例分成Normal/Warning/Critical三级,
+// 升级时调用注册的回调(释放缓存, 拒绝请求, 保存堆的profile)
+
+const (
+	defaultInterval   = time.Second
+	defaultWarning    = 0.8
+	defaultCritical   = 0.95
+	defaultHysteresis = 0.05
+)
+
+var (
+	// ErrBadThreshold 阈值不在(0, 1]或者warning大于critical
+	ErrBadThreshold = errors.New("mempressure: bad threshold")
+	// ErrNoLimit cgroup没有内存上限也没有WithLimit, 算不出比例, 永远不会升级
+	ErrNoLimit = errors.New("mempressure: no memory limit, use WithLimit")
+)
+
+// Level 压力等级
+type Level int
+
+const (
+	LevelNormal Level = iota
+	LevelWarning
+	LevelCritical
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelNormal:
+		return "normal"
+	case LevelWarning:
+		return "warning"
+	case LevelCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// Event 回调收到的事件
+type Event struct {
+	Level   Level
+	Prev    Level
+	Reading Reading
+	// Ratio Used()/Limit, 没有上限时是0
+	Ratio float64
+}
+
+// Callback 压力升到某一级时调用, 在Watcher的go程里执行, 不要阻塞太久
+type Callback func(Event)
+
+type options struct {
+	source     Source
+	interval   time.Duration
+	warning    float64
+	critical   float64
+	hysteresis float64
+	limit      uint64
+	clock      second.Clock
+}
+
+// Option Watcher的选项
+type Option func(*options)
+
+// WithSource 数据来源, 默认是当前进程所在的cgroup(cgroup.Self), 读memory.current和memory.events
+func WithSource(s Source) Option {
+	return func(o *options) {
+		o.source = s
+	}
+}
+
+// WithInterval 多久读一次, 默认1秒
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithThresholds 占上限多少算warning和critical, 默认0.8和0.95
+func WithThresholds(warning, critical float64) Option {
+	return func(o *options) {
+		o.warning, o.critical = warning, critical
+	}
+}
+
+// WithHysteresis 降级时要比阈值再低多少, 默认0.05
+// 在阈值附近上下抖动时不会反复触发回调
+func WithHysteresis(h float64) Option {
+	return func(o *options) {
+		o.hysteresis = h
+	}
+}
+
+// WithLimit 指定内存上限, 不在容器里或者想留得更多时用, 0表示用cgroup的memory.max
+func WithLimit(n uint64) Option {
+	return func(o *options) {
+		o.limit = n
+	}
+}
+
+// WithClock 定时用的时钟, 测试时用FakeClock
+func WithClock(c second.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// Watcher 内存压力监控
+type Watcher struct {
+	opts options
+
+	mu        sync.Mutex
+	level     Level
+	last      Event
+	events    cgroup.MemoryEvents // 上一次的memory.events, 用来算增量
+	callbacks map[Level][]Callback
+	started   bool
+}
+
+// NewWatcher 创建Watcher, 没有内存上限时返回ErrNoLimit
+func NewWatcher(opts ...Option) (*Watcher, error) {
+	o := options{
+		interval:   defaultInterval,
+		warning:    defaultWarning,
+		critical:   defaultCritical,
+		hysteresis: defaultHysteresis,
+		clock:      second.RealClock,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.warning <= 0 || o.critical > 1 || o.warning > o.critical || o.hysteresis < 0 {
+		return nil, ErrBadThreshold
+	}
+	if o.source == nil {
+		// 不在cgroup里时cg是nil, 按WithLimit只看堆和RSS
+		cg, err := cgroup.Self()
+		if err != nil && o.limit == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrNoLimit, err)
+		}
+		o.source = NewSource(cg)
+	}
+	// 上限从cgroup来的话先读一次, 没有上限就什么也监控不了, 直接报错
+	if o.limit == 0 {
+		r, err := o.source.Read()
+		if err != nil {
+			return nil, err
+		}
+		if r.Limit == 0 {
+			return nil, ErrNoLimit
+		}
+	}
+
+	return &Watcher{
+		opts:      o,
+		callbacks: make(map[Level][]Callback),
+	}, nil
+}
+
+// Register 压力从低于level升到level或更高时调用fn
+// 从Normal直接跳到Critical时, Warning和Critical的回调都会调用, 先调Warning的
+func (w *Watcher) Register(level Level, fn Callback) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callbacks[level] = append(w.callbacks[level], fn)
+}
+
+// Level 当前的压力等级, 可以给限流或者降载用
+func (w *Watcher) Level() Level {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.level
+}
+
+// Last 最近一次检查的结果
+func (w *Watcher) Last() Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// Check 读一次并更新等级, 升级时调用回调, Run里定时调用, 测试时直接调用
+func (w *Watcher) Check() (Event, error) {
+	r, err := w.opts.source.Read()
+	if err != nil {
+		return Event{}, err
+	}
+	if w.opts.limit > 0 {
+		r.Limit = w.opts.limit
+	}
+
+	w.mu.Lock()
+	e := Event{Prev: w.level, Reading: r}
+	if r.Limit > 0 {
+		e.Ratio = float64(r.Used()) / float64(r.Limit)
+	}
+	e.Level = w.levelFor(e.Ratio, w.level)
+
+	// cgroup已经开始回收内存(high/max)或者杀过进程(oom_kill), 不管比例是多少都要处理
+	if w.started {
+		prev := w.events
+		if r.Events.OOMKill > prev.OOMKill {
+			e.Level = LevelCritical
+		} else if r.Events.High > prev.High || r.Events.Max > prev.Max {
+			e.Level = max(e.Level, LevelWarning)
+		}
+	}
+	w.started = true
+	w.events = r.Events
+	w.level = e.Level
+	w.last = e
+
+	var fire []Callback
+	for l := e.Prev + 1; l <= e.Level; l++ {
+		fire = append(fire, w.callbacks[l]...)
+	}
+	w.mu.Unlock()
+
+	if e.Level != e.Prev {
+		log.Printf("mempressure: %s -> %s, used %d of %d (%.1f%%)",
+			e.Prev, e.Level, r.Used(), r.Limit, e.Ratio*100)
+	}
+	for _, fn := range fire {
+		fn(e)
+	}
+	return e, nil
+}
+
+// levelFor 按比例算等级, 升级看阈值, 降级要低于阈值减hysteresis
+func (w *Watcher) levelFor(ratio float64, prev Level) Level {
+	thresholds := [...]float64{LevelWarning: w.opts.warning, LevelCritical: w.opts.critical}
+	for l := LevelCritical; l > LevelNormal; l-- {
+		t := thresholds[l]
+		if prev >= l {
+			t -= w.opts.hysteresis
+		}
+		if ratio >= t {
+			return l
+		}
+	}
+	return LevelNormal
+}
+
+// Run 定时检查, stop关闭时返回, 读失败只打日志
+func (w *Watcher) Run(stop <-chan struct{}) {
+	ticker := w.opts.clock.NewTicker(w.opts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			if _, err := w.Check(); err != nil {
+				log.Printf("mempressure: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package mempressure
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/second"
+)
+
+const mb = 1 << 20
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeSource 测试里直接改要返回的Reading
+type fakeSource struct {
+	mu  sync.Mutex
+	r   Reading
+	err error
+}
+
+func (f *fakeSource) Read() (Reading, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.r, f.err
+}
+
+func (f *fakeSource) set(fn func(r *Reading)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(&f.r)
+}
+
+// recorder 记录回调收到的事件
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) callback(name string) Callback {
+	return func(e Event) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, name+":"+e.Level.String())
+	}
+}
+
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func newTestWatcher(t *testing.T, src Source, opts ...Option) (*Watcher, *recorder) {
+	t.Helper()
+	w, err := NewWatcher(append([]Option{WithSource(src)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	w.Register(LevelWarning, rec.callback("warn"))
+	w.Register(LevelCritical, rec.callback("crit"))
+	return w, rec
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Test_Watcher_Hysteresis 升级按阈值, 降级要低于阈值减hysteresis, 在阈值附近抖动不重复触发
+func Test_Watcher_Hysteresis(t *testing.T) {
+	src := &fakeSource{r: Reading{Limit: 100 * mb}}
+	w, rec := newTestWatcher(t, src)
+
+	steps := []struct {
+		rss   uint64
+		level Level
+		fired []string
+	}{
+		{rss: 50 * mb, level: LevelNormal},
+		{rss: 80 * mb, level: LevelWarning, fired: []string{"warn:warning"}},
+		{rss: 78 * mb, level: LevelWarning}, // 还在hysteresis里
+		{rss: 81 * mb, level: LevelWarning},
+		{rss: 74 * mb, level: LevelNormal},
+		{rss: 80 * mb, level: LevelWarning, fired: []string{"warn:warning"}},
+		{rss: 96 * mb, level: LevelCritical, fired: []string{"crit:critical"}},
+		{rss: 91 * mb, level: LevelCritical},
+		{rss: 89 * mb, level: LevelWarning},
+		{rss: 95 * mb, level: LevelCritical, fired: []string{"crit:critical"}},
+		{rss: 10 * mb, level: LevelNormal},
+		// 直接跳到critical, 两级的回调都要调, 先warning
+		{rss: 99 * mb, level: LevelCritical, fired: []string{"warn:critical", "crit:critical"}},
+	}
+	for i, s := range steps {
+		src.set(func(r *Reading) { r.RSS = s.rss })
+		e, err := w.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Level != s.level || w.Level() != s.level {
+			t.Fatalf("step %d: rss %dMB level = %s, want %s", i, s.rss/mb, e.Level, s.level)
+		}
+		if fired := rec.take(); !equal(fired, s.fired) {
+			t.Fatalf("step %d: fired %v, want %v", i, fired, s.fired)
+		}
+	}
+}
+
+// Test_Watcher_Used 堆, RSS, cgroup的working set取最大的
+func Test_Watcher_Used(t *testing.T) {
+	src := &fakeSource{r: Reading{
+		Heap: 20 * mb, RSS: 30 * mb,
+		Usage: 100 * mb, InactiveFile: 10 * mb, Limit: 100 * mb,
+	}}
+	w, _ := newTestWatcher(t, src)
+
+	e, err := w.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Ratio != 0.9 || e.Level != LevelWarning {
+		t.Fatalf("ratio = %v level = %s, want 0.9 warning", e.Ratio, e.Level)
+	}
+	if w.Last().Ratio != e.Ratio {
+		t.Fatalf("Last() = %+v", w.Last())
+	}
+}
+
+// Test_Watcher_Events memory.events里的计数增加时提升等级, 第一次读只记下来
+func Test_Watcher_Events(t *testing.T) {
+	src := &fakeSource{r: Reading{RSS: 10 * mb, Limit: 100 * mb, Events: cgroup.MemoryEvents{High: 5, OOMKill: 1}}}
+	w, rec := newTestWatcher(t, src)
+
+	check := func(want Level) {
+		t.Helper()
+		e, err := w.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Level != want {
+			t.Fatalf("level = %s, want %s", e.Level, want)
+		}
+	}
+
+	check(LevelNormal)
+	src.set(func(r *Reading) { r.Events.High++ })
+	check(LevelWarning)
+	check(LevelNormal)
+	src.set(func(r *Reading) { r.Events.OOMKill++ })
+	check(LevelCritical)
+	if fired := rec.take(); !equal(fired, []string{"warn:warning", "warn:critical", "crit:critical"}) {
+		t.Fatalf("fired %v", fired)
+	}
+}
+
+// Test_Watcher_Limit 没有cgroup上限时用WithLimit, 都没有就报错
+func Test_Watcher_Limit(t *testing.T) {
+	src := &fakeSource{r: Reading{Heap: 90 * mb}}
+	if _, err := NewWatcher(WithSource(src)); !errors.Is(err, ErrNoLimit) {
+		t.Fatalf("no limit: err = %v, want ErrNoLimit", err)
+	}
+
+	w, _ := newTestWatcher(t, src, WithLimit(100*mb))
+	if e, _ := w.Check(); e.Level != LevelWarning {
+		t.Fatalf("WithLimit: %+v", e)
+	}
+}
+
+// Test_NewWatcher_Default 默认读当前进程的cgroup, cgroup没有上限时要WithLimit
+func Test_NewWatcher_Default(t *testing.T) {
+	if _, err := NewWatcher(); err != nil && !errors.Is(err, ErrNoLimit) {
+		t.Fatal(err)
+	}
+
+	w, err := NewWatcher(WithLimit(1 << 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := w.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reading.RSS == 0 || e.Level != LevelNormal {
+		t.Fatalf("event %+v", e)
+	}
+}
+
+func Test_NewWatcher_BadThreshold(t *testing.T) {
+	for _, th := range [][2]float64{{0, 0.9}, {0.9, 0.8}, {0.8, 1.1}} {
+		if _, err := NewWatcher(WithThresholds(th[0], th[1])); !errors.Is(err, ErrBadThreshold) {
+			t.Fatalf("%v: err = %v, want ErrBadThreshold", th, err)
+		}
+	}
+}
+
+func Test_Watcher_Run(t *testing.T) {
+	clk := second.NewFakeClock(epoch)
+	src := &fakeSource{r: Reading{RSS: 10 * mb, Limit: 100 * mb}}
+	w, rec := newTestWatcher(t, src, WithClock(clk), WithInterval(time.Second))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.Run(stop)
+		close(done)
+	}()
+	clk.BlockUntil(1)
+
+	// 读失败不退出
+	src.set(func(r *Reading) { r.RSS = 97 * mb })
+	src.mu.Lock()
+	src.err = errors.New("read failed")
+	src.mu.Unlock()
+	clk.Advance(time.Second)
+
+	src.mu.Lock()
+	src.err = nil
+	src.mu.Unlock()
+	waitFor(t, func() bool {
+		clk.Advance(time.Second)
+		return w.Level() == LevelCritical
+	})
+	close(stop)
+	<-done
+
+	if fired := rec.take(); !equal(fired, []string{"warn:critical", "crit:critical"}) {
+		t.Fatalf("fired %v", fired)
+	}
+}
+
+func Test_WriteHeapProfile(t *testing.T) {
+	dir := t.TempDir()
+	WriteHeapProfile(dir)(Event{Level: LevelCritical})
+
+	files, err := filepath.Glob(filepath.Join(dir, "heap-critical-*.pprof"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files = %v, err = %v", files, err)
+	}
+	if fi, err := os.Stat(files[0]); err != nil || fi.Size() == 0 {
+		t.Fatalf("profile: %v %v", fi, err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,402 @@
+package mr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// 参考 read-source-code/go-zero/mr.md, 去掉了对go-zero其它包的依赖
+
+const (
+	defaultWorkers = 16 // 默认工作go程数
+	minWorkers     = 1  // 最小工作go程数
+)
+
+var (
+	// ErrCancelWithNil 表示mapreduce被nil取消
+	ErrCancelWithNil = errors.New("mapreduce cancelled with nil")
+	// ErrReduceNoOutput 表示reduce没有写出值
+	ErrReduceNoOutput = errors.New("reduce not writing value")
+)
+
+type (
+	// ForEachFunc 处理元素, 没有输出
+	ForEachFunc[T any] func(item T)
+	// GenerateFunc 由调用方往source里写元素
+	GenerateFunc[T any] func(source chan<- T)
+	// MapFunc 处理元素并把结果写到writer
+	MapFunc[T, U any] func(item T, writer Writer[U])
+	// MapperFunc 处理元素并把结果写到writer, 可以用cancel提前结束
+	MapperFunc[T, U any] func(item T, writer Writer[U], cancel func(error))
+	// ReducerFunc 汇总所有mapper的输出, 把结果写到writer, 可以用cancel提前结束
+	ReducerFunc[U, V any] func(pipe <-chan U, writer Writer[V], cancel func(error))
+	// VoidReducerFunc 汇总所有mapper的输出, 没有结果
+	VoidReducerFunc[U any] func(pipe <-chan U, cancel func(error))
+	// Option 定制mapreduce
+	Option func(opts *mapReduceOptions)
+
+	mapperContext[T, U any] struct {
+		ctx       context.Context
+		mapper    MapFunc[T, U]
+		source    <-chan T
+		panicChan *onceChan
+		collector chan<- U
+		doneChan  <-chan struct{}
+		workers   int
+	}
+
+	mapReduceOptions struct {
+		ctx     context.Context
+		workers int
+	}
+
+	// Writer 包装了Write方法
+	Writer[T any] interface {
+		Write(v T)
+	}
+)
+
+// Finish 并发运行fns, 任意一个出错都会取消
+func Finish(fns ...func() error) error {
+	if len(fns) == 0 {
+		return nil
+	}
+
+	return MapReduceVoid(func(source chan<- func() error) {
+		for _, fn := range fns {
+			source <- fn
+		}
+	}, func(fn func() error, writer Writer[any], cancel func(error)) {
+		if err := fn(); err != nil {
+			cancel(err)
+		}
+	}, func(pipe <-chan any, cancel func(error)) {
+	}, WithWorkers(len(fns)))
+}
+
+// FinishVoid 并发运行fns
+func FinishVoid(fns ...func()) {
+	if len(fns) == 0 {
+		return
+	}
+
+	ForEach(func(source chan<- func()) {
+		for _, fn := range fns {
+			source <- fn
+		}
+	}, func(fn func()) {
+		fn()
+	}, WithWorkers(len(fns)))
+}
+
+// ForEach 处理generate生成的所有元素, 没有输出
+func ForEach[T any](generate GenerateFunc[T], mapper ForEachFunc[T], opts ...Option) {
+	options := buildOptions(opts...)
+	panicChan := &onceChan{channel: make(chan any)}
+	source := buildSource(generate, panicChan)
+	collector := make(chan any)
+	done := make(chan struct{})
+
+	go executeMappers(mapperContext[T, any]{
+		ctx: options.ctx,
+		mapper: func(item T, _ Writer[any]) {
+			mapper(item)
+		},
+		source:    source,
+		panicChan: panicChan,
+		collector: collector,
+		doneChan:  done,
+		workers:   options.workers,
+	})
+
+	for {
+		select {
+		case v := <-panicChan.channel:
+			panic(v)
+		case _, ok := <-collector:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// MapReduce 用mapper处理generate生成的所有元素, 再用reducer汇总
+func MapReduce[T, U, V any](generate GenerateFunc[T], mapper MapperFunc[T, U], reducer ReducerFunc[U, V],
+	opts ...Option) (V, error) {
+	panicChan := &onceChan{channel: make(chan any)}
+	source := buildSource(generate, panicChan)
+	return mapReduceWithPanicChan(source, panicChan, mapper, reducer, opts...)
+}
+
+// MapReduceChan 用mapper处理source里的所有元素, 再用reducer汇总
+func MapReduceChan[T, U, V any](source <-chan T, mapper MapperFunc[T, U], reducer ReducerFunc[U, V],
+	opts ...Option) (V, error) {
+	panicChan := &onceChan{channel: make(chan any)}
+	return mapReduceWithPanicChan(source, panicChan, mapper, reducer, opts...)
+}
+
+func mapReduceWithPanicChan[T, U, V any](source <-chan T, panicChan *onceChan, mapper MapperFunc[T, U],
+	reducer ReducerFunc[U, V], opts ...Option) (val V, err error) {
+	options := buildOptions(opts...)
+	// output 用于写最终结果
+	output := make(chan V)
+	defer func() {
+		// reducer只能写一次, 多次写panic
+		for range output {
+			panic("more than one element written in reducer")
+		}
+	}()
+
+	// collector 收集mapper的输出, 给reducer消费
+	collector := make(chan U, options.workers)
+	// done关闭后, 所有的mapper和reducer都要停止
+	done := make(chan struct{})
+	writer := newGuardedWriter(options.ctx, output, done)
+	var closeOnce sync.Once
+	var retErr atomicError
+	finish := func() {
+		closeOnce.Do(func() {
+			close(done)
+			close(output)
+		})
+	}
+	cancel := once(func(err error) {
+		if err != nil {
+			retErr.Set(err)
+		} else {
+			retErr.Set(ErrCancelWithNil)
+		}
+
+		drain(source)
+		finish()
+	})
+
+	go func() {
+		defer func() {
+			drain(collector)
+			if r := recover(); r != nil {
+				panicChan.write(r)
+			}
+			finish()
+		}()
+
+		reducer(collector, writer, cancel)
+	}()
+
+	go executeMappers(mapperContext[T, U]{
+		ctx: options.ctx,
+		mapper: func(item T, w Writer[U]) {
+			mapper(item, w, cancel)
+		},
+		source:    source,
+		panicChan: panicChan,
+		collector: collector,
+		doneChan:  done,
+		workers:   options.workers,
+	})
+
+	select {
+	case <-options.ctx.Done():
+		cancel(context.DeadlineExceeded)
+		err = context.DeadlineExceeded
+	case v := <-panicChan.channel:
+		// 先把output排空, 不然defer里的for循环会panic
+		drain(output)
+		panic(v)
+	case v, ok := <-output:
+		if e := retErr.Load(); e != nil {
+			err = e
+		} else if ok {
+			val = v
+		} else {
+			err = ErrReduceNoOutput
+		}
+	}
+
+	return
+}
+
+// MapReduceVoid 用mapper处理generate生成的所有元素, 再用reducer汇总, 没有结果
+func MapReduceVoid[T, U any](generate GenerateFunc[T], mapper MapperFunc[T, U],
+	reducer VoidReducerFunc[U], opts ...Option) error {
+	_, err := MapReduce(generate, mapper, func(input <-chan U, writer Writer[any], cancel func(error)) {
+		reducer(input, cancel)
+	}, opts...)
+	if errors.Is(err, ErrReduceNoOutput) {
+		return nil
+	}
+
+	return err
+}
+
+// WithContext 使用给定的ctx
+func WithContext(ctx context.Context) Option {
+	return func(opts *mapReduceOptions) {
+		opts.ctx = ctx
+	}
+}
+
+// WithWorkers 使用给定的工作go程数
+func WithWorkers(workers int) Option {
+	return func(opts *mapReduceOptions) {
+		if workers < minWorkers {
+			opts.workers = minWorkers
+		} else {
+			opts.workers = workers
+		}
+	}
+}
+
+func buildOptions(opts ...Option) *mapReduceOptions {
+	options := newOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
+
+func buildSource[T any](generate GenerateFunc[T], panicChan *onceChan) chan T {
+	source := make(chan T)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicChan.write(r)
+			}
+			close(source)
+		}()
+
+		generate(source)
+	}()
+
+	return source
+}
+
+// drain 把chan读完, 让写端不会卡住
+func drain[T any](channel <-chan T) {
+	for range channel {
+	}
+}
+
+func executeMappers[T, U any](mCtx mapperContext[T, U]) {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		close(mCtx.collector)
+		drain(mCtx.source)
+	}()
+
+	var failed int32
+	pool := make(chan struct{}, mCtx.workers)
+	writer := newGuardedWriter(mCtx.ctx, mCtx.collector, mCtx.doneChan)
+	for atomic.LoadInt32(&failed) == 0 {
+		select {
+		case <-mCtx.ctx.Done():
+			return
+		case <-mCtx.doneChan:
+			return
+		// 限制并发数
+		case pool <- struct{}{}:
+			item, ok := <-mCtx.source
+			if !ok {
+				<-pool
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						atomic.AddInt32(&failed, 1)
+						mCtx.panicChan.write(r)
+					}
+					wg.Done()
+					// 业务函数执行完, 释放令牌
+					<-pool
+				}()
+
+				mCtx.mapper(item, writer)
+			}()
+		}
+	}
+}
+
+func newOptions() *mapReduceOptions {
+	return &mapReduceOptions{
+		ctx:     context.Background(),
+		workers: defaultWorkers,
+	}
+}
+
+func once(fn func(error)) func(error) {
+	once := new(sync.Once)
+	return func(err error) {
+		once.Do(func() {
+			fn(err)
+		})
+	}
+}
+
+type guardedWriter[T any] struct {
+	ctx     context.Context
+	channel chan<- T
+	done    <-chan struct{}
+}
+
+func newGuardedWriter[T any](ctx context.Context, channel chan<- T, done <-chan struct{}) guardedWriter[T] {
+	return guardedWriter[T]{
+		ctx:     ctx,
+		channel: channel,
+		done:    done,
+	}
+}
+
+// Write 和go-zero不同, 写chan也放到select里, 这样写的过程中被取消也不会卡住
+func (gw guardedWriter[T]) Write(v T) {
+	select {
+	case <-gw.ctx.Done():
+		return
+	case <-gw.done:
+		return
+	default:
+	}
+
+	select {
+	case <-gw.ctx.Done():
+	case <-gw.done:
+	case gw.channel <- v:
+	}
+}
+
+// onceChan 只写一次的chan, 用于把第一个panic传回调用方的go程
+type onceChan struct {
+	channel chan any
+	wrote   int32
+}
+
+func (oc *onceChan) write(val any) {
+	if atomic.CompareAndSwapInt32(&oc.wrote, 0, 1) {
+		oc.channel <- val
+	}
+}
+
+// atomicError 对应go-zero的errorx.AtomicError
+type atomicError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (ae *atomicError) Set(err error) {
+	ae.mu.Lock()
+	ae.err = err
+	ae.mu.Unlock()
+}
+
+func (ae *atomicError) Load() error {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	return ae.err
+}
@@ -0,0 +1,254 @@
+package mr
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Finish(t *testing.T) {
+	var total uint32
+	err := Finish(func() error {
+		atomic.AddUint32(&total, 2)
+		return nil
+	}, func() error {
+		atomic.AddUint32(&total, 3)
+		return nil
+	}, func() error {
+		atomic.AddUint32(&total, 5)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 10 {
+		t.Fatalf("total = %d", total)
+	}
+
+	errDummy := errors.New("dummy")
+	if err := Finish(func() error { return nil }, func() error { return errDummy }); err != errDummy {
+		t.Fatalf("want errDummy, got %v", err)
+	}
+}
+
+func Test_MapReduce(t *testing.T) {
+	v, err := MapReduce(func(source chan<- int) {
+		for i := 1; i <= 10; i++ {
+			source <- i
+		}
+	}, func(item int, writer Writer[int], cancel func(error)) {
+		writer.Write(item * item)
+	}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+		sum := 0
+		for v := range pipe {
+			sum += v
+		}
+		writer.Write(sum)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 385 {
+		t.Fatalf("sum = %d", v)
+	}
+}
+
+func Test_MapReduce_NoOutput(t *testing.T) {
+	_, err := MapReduce(func(source chan<- int) {
+		source <- 1
+	}, func(item int, writer Writer[int], cancel func(error)) {
+	}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+		for range pipe {
+		}
+	})
+	if err != ErrReduceNoOutput {
+		t.Fatalf("want ErrReduceNoOutput, got %v", err)
+	}
+}
+
+func Test_MapReduce_MoreThanOneOutput(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want panic")
+		}
+	}()
+
+	MapReduce(func(source chan<- int) {
+		source <- 1
+	}, func(item int, writer Writer[int], cancel func(error)) {
+		writer.Write(item)
+	}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+		for v := range pipe {
+			writer.Write(v)
+			writer.Write(v)
+		}
+	})
+}
+
+// 下面几个用例对应笔记里的drain逻辑: 提前取消后, 生成器和mapper都不能卡住
+func Test_MapReduce_CancelInMapperDrainsSource(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errStop := errors.New("stop")
+	var generated int32
+
+	_, err := MapReduce(func(source chan<- int) {
+		for i := 0; i < 1000; i++ {
+			source <- i
+			atomic.AddInt32(&generated, 1)
+		}
+	}, func(item int, writer Writer[int], cancel func(error)) {
+		if item == 10 {
+			cancel(errStop)
+			return
+		}
+		writer.Write(item)
+	}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+		for range pipe {
+		}
+	}, WithWorkers(2))
+	if err != errStop {
+		t.Fatalf("want errStop, got %v", err)
+	}
+	// cancel会把source读完, 生成器一定能写完
+	if n := atomic.LoadInt32(&generated); n != 1000 {
+		t.Fatalf("generator blocked after %d items", n)
+	}
+	waitGoroutines(t, before)
+}
+
+func Test_MapReduce_CancelInReducer(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errStop := errors.New("stop")
+
+	_, err := MapReduce(func(source chan<- int) {
+		for i := 0; i < 1000; i++ {
+			source <- i
+		}
+	}, func(item int, writer Writer[int], cancel func(error)) {
+		writer.Write(item)
+	}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+		for v := range pipe {
+			if v >= 5 {
+				cancel(errStop)
+				return
+			}
+		}
+	})
+	if err != errStop {
+		t.Fatalf("want errStop, got %v", err)
+	}
+	waitGoroutines(t, before)
+}
+
+func Test_MapReduce_CancelWithNil(t *testing.T) {
+	_, err := MapReduce(func(source chan<- int) {
+		source <- 1
+	}, func(item int, writer Writer[int], cancel func(error)) {
+		cancel(nil)
+	}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+		for range pipe {
+		}
+	})
+	if err != ErrCancelWithNil {
+		t.Fatalf("want ErrCancelWithNil, got %v", err)
+	}
+}
+
+func Test_MapReduce_Context(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	source := make(chan int, 1)
+	source <- 1
+	close(source)
+	_, err := MapReduceChan(source, func(item int, writer Writer[int], cancel func(error)) {
+		time.Sleep(100 * time.Millisecond)
+		writer.Write(item)
+	}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+		for range pipe {
+		}
+	}, WithContext(ctx))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+}
+
+func Test_MapReduce_Panic(t *testing.T) {
+	for name, fn := range map[string]func(){
+		"generate": func() {
+			MapReduce(func(source chan<- int) {
+				panic("generate")
+			}, func(item int, writer Writer[int], cancel func(error)) {
+			}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+				for range pipe {
+				}
+			})
+		},
+		"mapper": func() {
+			MapReduce(func(source chan<- int) {
+				source <- 1
+			}, func(item int, writer Writer[int], cancel func(error)) {
+				panic("mapper")
+			}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+				for range pipe {
+				}
+			})
+		},
+		"reducer": func() {
+			MapReduce(func(source chan<- int) {
+				source <- 1
+			}, func(item int, writer Writer[int], cancel func(error)) {
+				writer.Write(item)
+			}, func(pipe <-chan int, writer Writer[int], cancel func(error)) {
+				panic("reducer")
+			})
+		},
+	} {
+		name, fn := name, fn
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != name {
+					t.Fatalf("want panic %q, got %v", name, r)
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+func Test_ForEach_Workers(t *testing.T) {
+	var running, maxRunning int32
+	ForEach(func(source chan<- int) {
+		for i := 0; i < 20; i++ {
+			source <- i
+		}
+	}, func(item int) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}, WithWorkers(3))
+
+	if maxRunning > 3 {
+		t.Fatalf("workers exceeded: %d", maxRunning)
+	}
+}
+
+func waitGoroutines(t *testing.T, want int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if runtime.NumGoroutine() <= want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("goroutine leak: %d > %d", runtime.NumGoroutine(), want)
+}
@@ -0,0 +1,435 @@
+//go:build linux || darwin
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 见 read-source-code/go-zero/proc/signals.md
+// go-zero在init里写死了信号和动作, 这里改成命令表: 信号映射到命令名, unix socket也按名字调用同一个命令
+// 比如read-book/docker/test/pagecache的-grow注册了grow命令, SIGUSR1和socket都能触发, 不用自己signal.Notify之后阻塞等待
+
+const (
+	defaultShutdownTimeout = 5 * time.Second
+	timeFormat             = "0102150405"
+)
+
+var (
+	// ErrUnknownCommand 没有注册的命令
+	ErrUnknownCommand = errors.New("proc: unknown command")
+	// ErrNoProfiler 没有设置Profiler时调用profile命令
+	ErrNoProfiler = errors.New("proc: no profiler")
+)
+
+// Command 一个命令, args不包括命令名, 返回的文本写回socket或者打到日志里
+type Command func(args []string) (string, error)
+
+// Profiler profile命令开关的对象
+type Profiler interface {
+	Start() error
+	// Stop 返回写了哪些文件
+	Stop() (string, error)
+	Running() bool
+}
+
+type options struct {
+	shutdownTimeout time.Duration
+	dumpDir         string
+	exit            func(code int)
+	profiler        Profiler
+}
+
+// Option Controller的选项
+type Option func(*options)
+
+// WithShutdownTimeout 优雅退出最多等多久, 默认5秒
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.shutdownTimeout = d
+	}
+}
+
+// WithDumpDir goroutine dump和profile写到哪里, 默认os.TempDir()
+func WithDumpDir(dir string) Option {
+	return func(o *options) {
+		o.dumpDir = dir
+	}
+}
+
+// WithExit 优雅退出结束后调用, 默认os.Exit, 超时时code是1
+// 传nil时不退出, 由调用方等Done()和Wait()
+func WithExit(exit func(code int)) Option {
+	return func(o *options) {
+		o.exit = exit
+	}
+}
+
+// WithProfiler profile命令开关的Profiler, 默认只采CPU
+func WithProfiler(p Profiler) Option {
+	return func(o *options) {
+		o.profiler = p
+	}
+}
+
+// Controller 把信号和socket命令映射到注册的动作上
+// 默认: SIGUSR1 dump, SIGUSR2 profile, SIGTERM/SIGINT shutdown, SIGHUP reload
+type Controller struct {
+	opts options
+
+	mu        sync.Mutex
+	commands  map[string]Command
+	signals   map[os.Signal]string
+	onStop    []func()
+	onReload  []func() error
+	sigCh     chan os.Signal
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	done         chan struct{}
+	shutdownOnce sync.Once
+	stopped      chan struct{}
+}
+
+// New 创建Controller, 调用Start之后才处理信号
+func New(opts ...Option) *Controller {
+	o := options{
+		shutdownTimeout: defaultShutdownTimeout,
+		dumpDir:         os.TempDir(),
+		exit:            os.Exit,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &Controller{
+		opts:     o,
+		commands: make(map[string]Command),
+		signals:  make(map[os.Signal]string),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if c.opts.profiler == nil {
+		c.opts.profiler = &cpuProfiler{dir: o.dumpDir}
+	}
+
+	c.Register("dump", c.dump)
+	c.Register("profile", c.profile)
+	c.Register("shutdown", c.shutdown)
+	c.Register("reload", c.reload)
+	c.Register("help", c.help)
+	c.Handle(syscall.SIGUSR1, "dump")
+	c.Handle(syscall.SIGUSR2, "profile")
+	c.Handle(syscall.SIGTERM, "shutdown")
+	c.Handle(syscall.SIGINT, "shutdown")
+	c.Handle(syscall.SIGHUP, "reload")
+	return c
+}
+
+// Register 注册命令, 同名的会被替换
+func (c *Controller) Register(name string, cmd Command) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands[name] = cmd
+}
+
+// Handle 收到sig时执行命令name, 要在Start之前调用
+func (c *Controller) Handle(sig os.Signal, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.signals[sig] = name
+}
+
+// OnShutdown shutdown时并发调用, 都返回或者超时之后退出
+func (c *Controller) OnShutdown(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onStop = append(c.onStop, fn)
+}
+
+// OnReload reload时按注册的顺序调用, 一般是重新读配置
+func (c *Controller) OnReload(fn func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReload = append(c.onReload, fn)
+}
+
+// Done 开始退出时关闭, 和go-zero的proc.Done()一样
+func (c *Controller) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait 等shutdown的回调都结束或者超时
+func (c *Controller) Wait() {
+	<-c.stopped
+}
+
+// Exec 执行一行命令, 比如 "profile stop"
+func (c *Controller) Exec(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", ErrUnknownCommand
+	}
+
+	c.mu.Lock()
+	cmd, ok := c.commands[fields[0]]
+	c.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownCommand, fields[0])
+	}
+	return cmd(fields[1:])
+}
+
+// Start 开始处理信号
+func (c *Controller) Start() {
+	c.mu.Lock()
+	sigs := make([]os.Signal, 0, len(c.signals))
+	for sig := range c.signals {
+		sigs = append(sigs, sig)
+	}
+	c.sigCh = make(chan os.Signal, 1)
+	c.mu.Unlock()
+
+	signal.Notify(c.sigCh, sigs...)
+	go c.loop()
+}
+
+func (c *Controller) loop() {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case sig := <-c.sigCh:
+			c.mu.Lock()
+			name := c.signals[sig]
+			c.mu.Unlock()
+
+			out, err := c.Exec(name)
+			if err != nil {
+				log.Printf("proc: %s: %s: %s", sig, name, err)
+				continue
+			}
+			log.Printf("proc: %s: %s: %s", sig, name, out)
+		}
+	}
+}
+
+// Close 停止处理信号, 关闭socket, 不会触发shutdown
+func (c *Controller) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		if c.sigCh != nil {
+			signal.Stop(c.sigCh)
+		}
+		c.mu.Unlock()
+	})
+	return nil
+}
+
+// dump 把所有goroutine的栈写到文件里, 返回文件名
+func (c *Controller) dump([]string) (string, error) {
+	path := c.dumpFile("goroutine", "dump")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// profile 不带参数时开关切换, 也可以指定start或stop
+func (c *Controller) profile(args []string) (string, error) {
+	p := c.opts.profiler
+	if p == nil {
+		return "", ErrNoProfiler
+	}
+
+	action := "stop"
+	if !p.Running() {
+		action = "start"
+	}
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "start":
+		if err := p.Start(); err != nil {
+			return "", err
+		}
+		return "profiling started", nil
+	case "stop":
+		return p.Stop()
+	case "status":
+		if p.Running() {
+			return "running", nil
+		}
+		return "stopped", nil
+	}
+	return "", fmt.Errorf("proc: profile %s: want start, stop or status", action)
+}
+
+// shutdown 关闭Done(), 在后台调用OnShutdown的回调, 马上返回, socket的客户端能收到回复
+func (c *Controller) shutdown([]string) (string, error) {
+	first := false
+	c.shutdownOnce.Do(func() {
+		first = true
+		close(c.done)
+		go c.gracefulStop()
+	})
+	if !first {
+		return "already shutting down", nil
+	}
+	return fmt.Sprintf("shutting down, timeout %s", c.opts.shutdownTimeout), nil
+}
+
+func (c *Controller) gracefulStop() {
+	c.mu.Lock()
+	hooks := append([]func(){}, c.onStop...)
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(hooks))
+	for _, fn := range hooks {
+		go func(fn func()) {
+			defer wg.Done()
+			fn()
+		}(fn)
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	code := 0
+	timer := time.NewTimer(c.opts.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-finished:
+		log.Printf("proc: graceful shutdown finished")
+	case <-timer.C:
+		code = 1
+		log.Printf("proc: graceful shutdown timed out after %s", c.opts.shutdownTimeout)
+	}
+
+	close(c.stopped)
+	if c.opts.exit != nil {
+		c.opts.exit(code)
+	}
+}
+
+// reload 按顺序调用OnReload的回调, 出错的也接着调后面的
+func (c *Controller) reload([]string) (string, error) {
+	c.mu.Lock()
+	hooks := append([]func() error{}, c.onReload...)
+	c.mu.Unlock()
+
+	var errs []error
+	for _, fn := range hooks {
+		if err := fn(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("reloaded %d", len(hooks)), nil
+}
+
+// help 列出所有命令和信号
+func (c *Controller) help([]string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bySignal := make(map[string][]string)
+	for sig, name := range c.signals {
+		bySignal[name] = append(bySignal[name], sig.String())
+	}
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		if sigs := bySignal[name]; len(sigs) > 0 {
+			sort.Strings(sigs)
+			fmt.Fprintf(&b, " (%s)", strings.Join(sigs, ", "))
+		}
+		b.WriteByte('\n')
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// dumpFile 和go-zero的createDumpFile一样, 文件名带程序名, pid和时间
+func (c *Controller) dumpFile(kind, ext string) string {
+	command := filepath.Base(os.Args[0])
+	return filepath.Join(c.opts.dumpDir, fmt.Sprintf("%s-%d-%s-%s.%s",
+		command, os.Getpid(), kind, time.Now().Format(timeFormat), ext))
+}
+
+// cpuProfiler 默认的Profiler, 只采CPU
+type cpuProfiler struct {
+	dir  string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (p *cpuProfiler) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file != nil {
+		return errors.New("proc: profiling already started")
+	}
+
+	f, err := os.Create(filepath.Join(p.dir, fmt.Sprintf("%s-%d-cpu-%s.pprof",
+		filepath.Base(os.Args[0]), os.Getpid(), time.Now().Format(timeFormat))))
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	p.file = f
+	return nil
+}
+
+func (p *cpuProfiler) Stop() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return "", errors.New("proc: profiling not started")
+	}
+
+	pprof.StopCPUProfile()
+	name := p.file.Name()
+	err := p.file.Close()
+	p.file = nil
+	return name, err
+}
+
+func (p *cpuProfiler) Running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file != nil
+}
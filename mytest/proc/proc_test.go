@@ -0,0 +1,186 @@
+//go:build linux || darwin
+
+package proc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestController 不真的退出, exit的code写到返回的通道里
+func newTestController(t *testing.T, opts ...Option) (*Controller, chan int) {
+	t.Helper()
+	exited := make(chan int, 1)
+	opts = append([]Option{WithDumpDir(t.TempDir()), WithExit(func(code int) { exited <- code })}, opts...)
+	c := New(opts...)
+	t.Cleanup(func() { c.Close() })
+	return c, exited
+}
+
+func Test_Exec_Dump(t *testing.T) {
+	c, _ := newTestController(t)
+	path, err := c.Exec("dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Test_Exec_Dump") {
+		t.Fatalf("dump does not contain the test goroutine:\n%s", data)
+	}
+}
+
+func Test_Exec_Unknown(t *testing.T) {
+	c, _ := newTestController(t)
+	for _, line := range []string{"", "  ", "nope"} {
+		if _, err := c.Exec(line); !errors.Is(err, ErrUnknownCommand) {
+			t.Fatalf("Exec(%q) = %v, want ErrUnknownCommand", line, err)
+		}
+	}
+}
+
+func Test_Exec_Register(t *testing.T) {
+	c, _ := newTestController(t)
+	c.Register("echo", func(args []string) (string, error) {
+		return strings.Join(args, " "), nil
+	})
+	if out, err := c.Exec("echo a  b"); err != nil || out != "a b" {
+		t.Fatalf("Exec() = %q, %v", out, err)
+	}
+
+	out, err := c.Exec("help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"echo", "dump (user defined signal 1)", "reload (hangup)"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("help does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func Test_Exec_Profile(t *testing.T) {
+	c, _ := newTestController(t)
+
+	if out, err := c.Exec("profile"); err != nil || out != "profiling started" {
+		t.Fatalf("start: %q, %v", out, err)
+	}
+	if out, _ := c.Exec("profile status"); out != "running" {
+		t.Fatalf("status = %q", out)
+	}
+	if _, err := c.Exec("profile start"); err == nil {
+		t.Fatal("start twice want error")
+	}
+	path, err := c.Exec("profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+		t.Fatalf("profile %s: %v", path, err)
+	}
+	if _, err := c.Exec("profile bogus"); err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func Test_Exec_Reload(t *testing.T) {
+	c, _ := newTestController(t)
+	var calls int32
+	c.OnReload(func() error { atomic.AddInt32(&calls, 1); return nil })
+	c.OnReload(func() error { atomic.AddInt32(&calls, 1); return errors.New("bad config") })
+	c.OnReload(func() error { atomic.AddInt32(&calls, 1); return nil })
+
+	if _, err := c.Exec("reload"); err == nil || err.Error() != "bad config" {
+		t.Fatalf("reload err = %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+}
+
+func Test_Shutdown(t *testing.T) {
+	c, exited := newTestController(t)
+	var stopped int32
+	c.OnShutdown(func() { atomic.AddInt32(&stopped, 1) })
+	c.OnShutdown(func() { atomic.AddInt32(&stopped, 1) })
+
+	if _, err := c.Exec("shutdown"); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := c.Exec("shutdown"); out != "already shutting down" {
+		t.Fatalf("second shutdown = %q", out)
+	}
+	<-c.Done()
+	c.Wait()
+	if code := <-exited; code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if stopped != 2 {
+		t.Fatalf("stopped = %d, want 2", stopped)
+	}
+}
+
+func Test_Shutdown_Timeout(t *testing.T) {
+	c, exited := newTestController(t, WithShutdownTimeout(50*time.Millisecond))
+	block := make(chan struct{})
+	defer close(block)
+	c.OnShutdown(func() { <-block })
+
+	c.Exec("shutdown")
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Fatalf("exit code = %d, want 1", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not time out")
+	}
+}
+
+// Test_Signals 给自己发信号
+func Test_Signals(t *testing.T) {
+	dir := t.TempDir()
+	c, exited := newTestController(t, WithDumpDir(dir))
+	reloaded := make(chan struct{}, 1)
+	c.OnReload(func() error { reloaded <- struct{}{}; return nil })
+	c.Start()
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	waitFor(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*-goroutine-*.dump"))
+		return len(files) == 1
+	})
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload on SIGHUP")
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no shutdown on SIGTERM")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,477 @@
+//go:build linux || darwin
+
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 见 read-source-code/go-zero/proc/profile.md
+// go-zero的StartProfile全局只能有一个, 文件散在TempDir里, 这里:
+//   - 一次采集的所有文件放在一个带时间的目录里, 加一个manifest.json
+//   - 同时进行的采集数有上限, 超过时返回ErrProfileBusy
+//   - 可以由信号(实现了Profiler, 给Controller用), HTTP和代码触发
+
+const (
+	// DefaultMemProfileRate 和go-zero一样
+	DefaultMemProfileRate = 4096
+	defaultProfileTime    = time.Minute
+	maxHTTPProfileTime    = 5 * time.Minute
+	manifestName          = "manifest.json"
+)
+
+// ErrProfileBusy 同时进行的采集已经到上限了
+var ErrProfileBusy = errors.New("proc: too many profile captures")
+
+// DefaultProfileKinds 默认采集的内容, 和go-zero的StartProfile一样
+var DefaultProfileKinds = []string{"cpu", "mem", "mutex", "block", "trace", "threadcreate"}
+
+// Manifest 一次采集的说明, 写在目录里的manifest.json
+type Manifest struct {
+	Label     string            `json:"label,omitempty"`
+	Command   string            `json:"command"`
+	Pid       int               `json:"pid"`
+	GoVersion string            `json:"go_version"`
+	Started   time.Time         `json:"started"`
+	Stopped   time.Time         `json:"stopped"`
+	Duration  string            `json:"duration"`
+	Dir       string            `json:"dir"`
+	Files     []ManifestFile    `json:"files"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// ManifestFile 目录里的一个profile文件
+type ManifestFile struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+type profileOptions struct {
+	dir         string
+	kinds       []string
+	duration    time.Duration
+	maxCaptures int
+}
+
+// ProfileOption ProfileController的选项
+type ProfileOption func(*profileOptions)
+
+// WithProfileDir 采集的目录建在哪里, 默认os.TempDir()
+func WithProfileDir(dir string) ProfileOption {
+	return func(o *profileOptions) {
+		o.dir = dir
+	}
+}
+
+// WithProfileKinds 默认采集哪些, 见DefaultProfileKinds, 还可以用goroutine
+func WithProfileKinds(kinds ...string) ProfileOption {
+	return func(o *profileOptions) {
+		o.kinds = kinds
+	}
+}
+
+// WithProfileDuration 信号触发的采集多久自动停止, 默认1分钟, 和go-zero一样
+func WithProfileDuration(d time.Duration) ProfileOption {
+	return func(o *profileOptions) {
+		o.duration = d
+	}
+}
+
+// WithMaxCaptures 最多同时进行几个采集, 默认1
+// cpu和trace在进程里只能有一个, 同时采集时后开始的那个会在Errors里记一条
+func WithMaxCaptures(n int) ProfileOption {
+	return func(o *profileOptions) {
+		o.maxCaptures = n
+	}
+}
+
+// ProfileController 管理profile的采集
+type ProfileController struct {
+	opts profileOptions
+	sem  chan struct{}
+
+	mu sync.Mutex
+	// rates 打开了block, mutex, mem采样的采集数, 最后一个结束时恢复原来的值
+	rates    map[string]int
+	oldMem   int
+	oldMutex int
+
+	toggleMu sync.Mutex
+	toggled  *ProfileSession // Start/Stop开关的那一个
+}
+
+// NewProfileController 创建ProfileController
+func NewProfileController(opts ...ProfileOption) *ProfileController {
+	o := profileOptions{
+		dir:         os.TempDir(),
+		kinds:       DefaultProfileKinds,
+		duration:    defaultProfileTime,
+		maxCaptures: 1,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.maxCaptures <= 0 {
+		o.maxCaptures = 1
+	}
+
+	return &ProfileController{
+		opts:  o,
+		sem:   make(chan struct{}, o.maxCaptures),
+		rates: make(map[string]int),
+	}
+}
+
+// ProfileSession 一次正在进行的采集
+type ProfileSession struct {
+	c        *ProfileController
+	manifest Manifest
+	closers  []func() error
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// StartCapture 开始采集, d大于0时到时间自动停止, kinds为空时用默认的
+func (c *ProfileController) StartCapture(label string, kinds []string, d time.Duration) (*ProfileSession, error) {
+	select {
+	case c.sem <- struct{}{}:
+	default:
+		return nil, ErrProfileBusy
+	}
+
+	if len(kinds) == 0 {
+		kinds = c.opts.kinds
+	}
+	s := &ProfileSession{
+		c: c,
+		manifest: Manifest{
+			Label:     label,
+			Command:   filepath.Base(os.Args[0]),
+			Pid:       os.Getpid(),
+			GoVersion: runtime.Version(),
+			Started:   time.Now(),
+			Errors:    make(map[string]string),
+		},
+		done: make(chan struct{}),
+	}
+
+	dir, err := c.mkdir(s.manifest.Started, label)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+	s.manifest.Dir = dir
+
+	for _, kind := range kinds {
+		if err := s.start(kind); err != nil {
+			s.manifest.Errors[kind] = err.Error()
+			log.Printf("proc: profile %s: %s", kind, err)
+		}
+	}
+	if d > 0 {
+		go s.stopAfter(d)
+	}
+	log.Printf("proc: profiling %s to %s", strings.Join(kinds, ","), dir)
+	return s, nil
+}
+
+// Capture 采集d这么久, ctx取消时提前停止, 返回manifest
+func (c *ProfileController) Capture(ctx context.Context, label string, kinds []string, d time.Duration) (Manifest, error) {
+	s, err := c.StartCapture(label, kinds, d)
+	if err != nil {
+		return Manifest{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return s.Stop()
+	case <-s.done:
+		return s.Wait()
+	}
+}
+
+// mkdir 目录名是 程序名-pid-时间[-label], 同一毫秒里重名时加序号, 上级目录不存在时先建
+func (c *ProfileController) mkdir(now time.Time, label string) (string, error) {
+	name := fmt.Sprintf("%s-%d-%s", filepath.Base(os.Args[0]), os.Getpid(), now.Format("20060102-150405.000"))
+	if label != "" {
+		name += "-" + strings.Map(func(r rune) rune {
+			if r == '/' || r == ' ' || r == os.PathSeparator {
+				return '_'
+			}
+			return r
+		}, label)
+	}
+
+	if err := os.MkdirAll(c.opts.dir, 0o755); err != nil {
+		return "", err
+	}
+	base := filepath.Join(c.opts.dir, name)
+	path := base
+	for i := 2; ; i++ {
+		err := os.Mkdir(path, 0o755)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		path = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// start 打开一种profile, stop的时候写文件
+func (s *ProfileSession) start(kind string) error {
+	name := kind + ".pprof"
+	if kind == "trace" {
+		name = "trace.out"
+	}
+	switch kind {
+	case "cpu", "mem", "mutex", "block", "trace", "threadcreate", "goroutine":
+	default:
+		return fmt.Errorf("unknown profile kind %q", kind)
+	}
+
+	f, err := os.Create(filepath.Join(s.manifest.Dir, name))
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	var stop func() error
+	switch kind {
+	case "cpu":
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return fail(err)
+		}
+		stop = func() error { pprof.StopCPUProfile(); return nil }
+	case "trace":
+		if err := trace.Start(f); err != nil {
+			return fail(err)
+		}
+		stop = func() error { trace.Stop(); return nil }
+	case "mem", "mutex", "block":
+		s.c.enableRate(kind)
+		stop = func() error {
+			profile := kind
+			if kind == "mem" {
+				profile = "heap"
+			}
+			err := pprof.Lookup(profile).WriteTo(f, 0)
+			s.c.disableRate(kind)
+			return err
+		}
+	default:
+		// threadcreate和goroutine只是快照, 结束时写
+		stop = func() error { return pprof.Lookup(kind).WriteTo(f, 0) }
+	}
+
+	s.closers = append(s.closers, func() error {
+		err := stop()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", kind, err)
+		}
+		fi, err := os.Stat(f.Name())
+		if err != nil {
+			return err
+		}
+		s.manifest.Files = append(s.manifest.Files, ManifestFile{Kind: kind, Name: name, Bytes: fi.Size()})
+		return nil
+	})
+	return nil
+}
+
+// enableRate 第一个打开的采集设置采样率
+func (c *ProfileController) enableRate(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates[kind]++
+	if c.rates[kind] > 1 {
+		return
+	}
+	switch kind {
+	case "mem":
+		c.oldMem = runtime.MemProfileRate
+		runtime.MemProfileRate = DefaultMemProfileRate
+	case "mutex":
+		c.oldMutex = runtime.SetMutexProfileFraction(1)
+	case "block":
+		runtime.SetBlockProfileRate(1)
+	}
+}
+
+// disableRate 最后一个结束的采集恢复采样率
+// block的采样率读不回来, 只能关掉, 程序自己开了block采样的话采集之后要重新设置
+func (c *ProfileController) disableRate(kind string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates[kind]--
+	if c.rates[kind] > 0 {
+		return
+	}
+	switch kind {
+	case "mem":
+		runtime.MemProfileRate = c.oldMem
+	case "mutex":
+		runtime.SetMutexProfileFraction(c.oldMutex)
+	case "block":
+		runtime.SetBlockProfileRate(0)
+	}
+}
+
+// Stop 停止采集, 写manifest, 可以调用多次
+func (s *ProfileSession) Stop() (Manifest, error) {
+	s.once.Do(func() {
+		var errs []error
+		for i := len(s.closers) - 1; i >= 0; i-- {
+			if err := s.closers[i](); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		s.manifest.Stopped = time.Now()
+		s.manifest.Duration = s.manifest.Stopped.Sub(s.manifest.Started).Round(time.Millisecond).String()
+		if err := s.writeManifest(); err != nil {
+			errs = append(errs, err)
+		}
+		s.err = errors.Join(errs...)
+
+		<-s.c.sem
+		close(s.done)
+		log.Printf("proc: profile written to %s", s.manifest.Dir)
+	})
+	return s.Wait()
+}
+
+// stopAfter 到时间停止, 提前Stop了就退出
+func (s *ProfileSession) stopAfter(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		s.Stop()
+	case <-s.done:
+	}
+}
+
+// Wait 等采集结束
+func (s *ProfileSession) Wait() (Manifest, error) {
+	<-s.done
+	return s.manifest, s.err
+}
+
+// Dir 采集的目录
+func (s *ProfileSession) Dir() string {
+	return s.manifest.Dir
+}
+
+func (s *ProfileSession) writeManifest() error {
+	data, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.manifest.Dir, manifestName), append(data, '\n'), 0o644)
+}
+
+// Start 实现Profiler, 给SIGUSR2用, 到WithProfileDuration的时间自动停止
+func (c *ProfileController) Start() error {
+	c.toggleMu.Lock()
+	defer c.toggleMu.Unlock()
+	if c.toggled != nil && !c.toggled.stopped() {
+		return errors.New("proc: profiling already started")
+	}
+
+	s, err := c.StartCapture("signal", nil, c.opts.duration)
+	if err != nil {
+		return err
+	}
+	c.toggled = s
+	return nil
+}
+
+// Stop 实现Profiler, 返回目录
+func (c *ProfileController) Stop() (string, error) {
+	c.toggleMu.Lock()
+	s := c.toggled
+	c.toggled = nil
+	c.toggleMu.Unlock()
+
+	if s == nil {
+		return "", errors.New("proc: profiling not started")
+	}
+	m, err := s.Stop()
+	return m.Dir, err
+}
+
+// Running 实现Profiler
+func (c *ProfileController) Running() bool {
+	c.toggleMu.Lock()
+	defer c.toggleMu.Unlock()
+	return c.toggled != nil && !c.toggled.stopped()
+}
+
+func (s *ProfileSession) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServeHTTP 采集一次, 返回manifest
+// 参数: seconds(默认30, 最多300), kinds(逗号分隔), label
+// 正在采集时返回429, 客户端断开时提前停止
+func (c *ProfileController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	d := 30 * time.Second
+	if v := q.Get("seconds"); v != "" {
+		sec, err := strconv.ParseFloat(v, 64)
+		if err != nil || sec <= 0 {
+			http.Error(w, "bad seconds", http.StatusBadRequest)
+			return
+		}
+		d = min(time.Duration(sec*float64(time.Second)), maxHTTPProfileTime)
+	}
+	var kinds []string
+	if v := q.Get("kinds"); v != "" {
+		kinds = strings.Split(v, ",")
+	}
+
+	m, err := c.Capture(r.Context(), q.Get("label"), kinds, d)
+	switch {
+	case errors.Is(err, ErrProfileBusy):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil && m.Dir == "":
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 部分文件写失败时manifest还是有用的, 错误在Errors里
+	if err != nil {
+		m.Errors["stop"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
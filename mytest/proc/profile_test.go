@@ -0,0 +1,267 @@
+//go:build linux || darwin
+
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// contend 让mutex和block的profile里有东西
+func contend() {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				mu.Lock()
+				time.Sleep(10 * time.Microsecond)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func readManifest(t *testing.T, dir string) Manifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func Test_Capture(t *testing.T) {
+	dir := t.TempDir()
+	pc := NewProfileController(WithProfileDir(dir))
+	s, err := pc.StartCapture("bench/lock", []string{"cpu", "mem", "mutex", "block", "goroutine", "trace"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contend()
+	m, err := s.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Dir(m.Dir) != dir || !strings.HasSuffix(m.Dir, "-bench_lock") {
+		t.Fatalf("Dir = %q, want a dir under %s ending with the label", m.Dir, dir)
+	}
+	if len(m.Files) != 6 || len(m.Errors) != 0 {
+		t.Fatalf("files = %+v, errors = %v", m.Files, m.Errors)
+	}
+	for _, f := range m.Files {
+		fi, err := os.Stat(filepath.Join(m.Dir, f.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != f.Bytes || f.Bytes == 0 {
+			t.Fatalf("%s: size %d, manifest %d", f.Name, fi.Size(), f.Bytes)
+		}
+	}
+
+	saved := readManifest(t, m.Dir)
+	saved.Started, saved.Stopped = m.Started, m.Stopped // JSON里没有单调时钟
+	if saved.Errors == nil {
+		saved.Errors = map[string]string{}
+	}
+	if !reflect.DeepEqual(saved, m) {
+		t.Fatalf("manifest.json = %+v, want %+v", saved, m)
+	}
+
+	// 采样率恢复了
+	if rate := runtime.SetMutexProfileFraction(-1); rate != 0 {
+		t.Fatalf("mutex fraction = %d after stop", rate)
+	}
+}
+
+func Test_Capture_Busy(t *testing.T) {
+	pc := NewProfileController(WithProfileDir(t.TempDir()))
+	s, err := pc.StartCapture("", []string{"goroutine"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.StartCapture("", []string{"goroutine"}, 0); !errors.Is(err, ErrProfileBusy) {
+		t.Fatalf("second capture err = %v, want ErrProfileBusy", err)
+	}
+	s.Stop()
+	s.Stop() // 多次调用没问题
+	if s, err = pc.StartCapture("", []string{"goroutine"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+}
+
+// Test_Capture_Concurrent cpu只能有一个, 后开始的采集在Errors里记下来, 其它的照常
+func Test_Capture_Concurrent(t *testing.T) {
+	pc := NewProfileController(WithProfileDir(t.TempDir()), WithMaxCaptures(2))
+	s1, err := pc.StartCapture("a", []string{"cpu", "mutex"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := pc.StartCapture("b", []string{"cpu", "mutex", "bogus"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1.Dir() == s2.Dir() {
+		t.Fatal("same dir")
+	}
+
+	m1, err := s1.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// s1停了之后s2的mutex还开着
+	if rate := runtime.SetMutexProfileFraction(-1); rate != 1 {
+		t.Fatalf("mutex fraction = %d while s2 is running", rate)
+	}
+	m2, err := s2.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m1.Files) != 2 || len(m1.Errors) != 0 {
+		t.Fatalf("m1 = %+v", m1)
+	}
+	if len(m2.Files) != 1 || m2.Errors["cpu"] == "" || m2.Errors["bogus"] == "" {
+		t.Fatalf("m2 files = %+v errors = %v", m2.Files, m2.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(m2.Dir, "cpu.pprof")); !os.IsNotExist(err) {
+		t.Fatalf("failed cpu.pprof left behind: %v", err)
+	}
+}
+
+func Test_Capture_Duration(t *testing.T) {
+	pc := NewProfileController(WithProfileDir(t.TempDir()))
+
+	start := time.Now()
+	m, err := pc.Capture(context.Background(), "", []string{"goroutine"}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond || len(m.Files) != 1 {
+		t.Fatalf("Capture returned after %s: %+v", time.Since(start), m)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pc.Capture(ctx, "", []string{"goroutine"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test_Profiler_Signal 给Controller当Profiler用, profile命令(SIGUSR2)开关
+func Test_Profiler_Signal(t *testing.T) {
+	pc := NewProfileController(WithProfileDir(t.TempDir()), WithProfileKinds("mutex", "goroutine"))
+	c, _ := newTestController(t, WithProfiler(pc))
+
+	if _, err := c.Exec("profile"); err != nil {
+		t.Fatal(err)
+	}
+	if !pc.Running() {
+		t.Fatal("not running")
+	}
+	dir, err := c.Exec("profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := readManifest(t, dir); m.Label != "signal" || len(m.Files) != 2 {
+		t.Fatalf("manifest = %+v", m)
+	}
+
+	// 到时间自动停止
+	pc = NewProfileController(WithProfileDir(t.TempDir()), WithProfileKinds("goroutine"),
+		WithProfileDuration(20*time.Millisecond))
+	if err := pc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !pc.Running() })
+	if _, err := pc.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.Stop(); err == nil {
+		t.Fatal("stop twice want error")
+	}
+}
+
+func Test_ProfileController_ServeHTTP(t *testing.T) {
+	pc := NewProfileController(WithProfileDir(t.TempDir()))
+	srv := httptest.NewServer(pc)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?seconds=0.05&kinds=mutex,block&label=http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, err %v", resp.StatusCode, err)
+	}
+	if m.Label != "http" || len(m.Files) != 2 {
+		t.Fatalf("manifest = %+v", m)
+	}
+
+	resp, err = http.Get(srv.URL + "?seconds=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad seconds: status %d", resp.StatusCode)
+	}
+
+	s, err := pc.StartCapture("", []string{"goroutine"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	resp, err = http.Get(srv.URL + "?seconds=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("busy: status %d", resp.StatusCode)
+	}
+}
+
+// Test_Capture_RestoreMutex 程序自己设置的mutex采样率, 采集结束后还原
+func Test_Capture_RestoreMutex(t *testing.T) {
+	prev := runtime.SetMutexProfileFraction(5)
+	defer runtime.SetMutexProfileFraction(prev)
+
+	pc := NewProfileController(WithProfileDir(t.TempDir()))
+	s, err := pc.StartCapture("", []string{"mutex"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := runtime.SetMutexProfileFraction(-1); rate != 1 {
+		t.Fatalf("mutex fraction = %d while capturing", rate)
+	}
+	if _, err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if rate := runtime.SetMutexProfileFraction(-1); rate != 5 {
+		t.Fatalf("mutex fraction = %d after stop, want 5", rate)
+	}
+}
@@ -0,0 +1,112 @@
+//go:build linux || darwin
+
+package proc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 命令的socket协议: 客户端发一行命令, 服务端回 "ok\n"+输出 或者 "error: xxx\n", 然后关闭连接
+// 可以用 echo dump | nc -U /tmp/app.sock 手动调用
+
+const socketTimeout = 10 * time.Second
+
+// Listen 在path上监听unix socket, 之前留下的socket文件会被删掉, 不是socket的文件不动, Close时关闭
+// 能连上socket就能dump和shutdown, 所以只给自己读写
+func (c *Controller) Listen(path string) error {
+	fi, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	case fi.Mode()&os.ModeSocket == 0:
+		return fmt.Errorf("proc: %s exists and is not a socket", path)
+	default:
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return err
+	}
+
+	go func() {
+		<-c.closed
+		l.Close()
+	}()
+	go c.serve(l)
+	return nil
+}
+
+func (c *Controller) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-c.closed:
+			default:
+				log.Printf("proc: accept: %s", err)
+			}
+			return
+		}
+		go c.serveConn(conn)
+	}
+}
+
+func (c *Controller) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return
+	}
+
+	out, err := c.Exec(line)
+	if err != nil {
+		io.WriteString(conn, "error: "+err.Error()+"\n")
+		return
+	}
+	io.WriteString(conn, "ok\n"+out)
+}
+
+// Send 客户端, 发送一行命令, 返回输出
+func Send(path, line string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, socketTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	if _, err := io.WriteString(conn, strings.TrimSpace(line)+"\n"); err != nil {
+		return "", err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	status, out, _ := strings.Cut(string(reply), "\n")
+	if msg, ok := strings.CutPrefix(status, "error: "); ok {
+		return "", errors.New(msg)
+	}
+	if status != "ok" {
+		return "", errors.New("proc: bad reply " + status)
+	}
+	return out, nil
+}
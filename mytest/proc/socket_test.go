@@ -0,0 +1,103 @@
+//go:build linux || darwin
+
+package proc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func listen(t *testing.T, c *Controller) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "proc.sock")
+	if err := c.Listen(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_Socket(t *testing.T) {
+	c, exited := newTestController(t)
+	c.Register("echo", func(args []string) (string, error) {
+		return strings.Join(args, " "), nil
+	})
+	path := listen(t, c)
+
+	if out, err := Send(path, "echo hello world\n"); err != nil || out != "hello world" {
+		t.Fatalf("echo = %q, %v", out, err)
+	}
+	if _, err := Send(path, "nope"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("nope err = %v", err)
+	}
+
+	dump, err := Send(path, "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dump); err != nil {
+		t.Fatal(err)
+	}
+
+	// socket的客户端要先收到回复, 之后才退出
+	if out, err := Send(path, "shutdown"); err != nil || !strings.HasPrefix(out, "shutting down") {
+		t.Fatalf("shutdown = %q, %v", out, err)
+	}
+	<-exited
+}
+
+// Test_Socket_Stale 上次没删掉的socket文件不影响监听
+func Test_Socket_Stale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proc.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+	l.Close()
+
+	c, _ := newTestController(t)
+	if err := c.Listen(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Send(path, "help"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("socket perm %o, want 600", perm)
+	}
+}
+
+// Test_Socket_NotSocket path写错成普通文件时不能删掉
+func Test_Socket_NotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := newTestController(t)
+	if err := c.Listen(path); err == nil {
+		t.Fatal("want error")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "keep" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+}
+
+func Test_Socket_Close(t *testing.T) {
+	c, _ := newTestController(t)
+	path := listen(t, c)
+	c.Close()
+
+	waitFor(t, func() bool {
+		_, err := Send(path, "help")
+		var opErr *net.OpError
+		return errors.As(err, &opErr)
+	})
+}
@@ -0,0 +1,37 @@
+//go:build linux || darwin
+
+package first
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/guonaihong/question/mytest/proc"
+)
+
+// profileDir 不为空时整个测试期间采集mutex和block的profile, 对比Lock和RWLock的竞争
+//
+//	go test -bench ReadMore -run ^$ -args -profile.dir /tmp/rwlock
+//	go tool pprof -top /tmp/rwlock/*/mutex.pprof
+var profileDir = flag.String("profile.dir", "", "capture mutex and block profiles into this directory")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if *profileDir == "" {
+		os.Exit(m.Run())
+	}
+
+	pc := proc.NewProfileController(proc.WithProfileDir(*profileDir), proc.WithProfileKinds("mutex", "block"))
+	s, err := pc.StartCapture("rwlock", nil, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	if _, err := s.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,146 @@
+package second
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/ratelimit"
+	"golang.org/x/time/rate"
+)
+
+// noSleepClock 给uber ratelimit用的时钟, Sleep不真正睡眠
+// 这样Take马上返回, 返回值就是可以执行的时间点, 由适配器决定怎么等
+type noSleepClock struct{ Clock }
+
+func (noSleepClock) Sleep(d time.Duration) {}
+
+// Uber 把 go.uber.org/ratelimit 适配成Limiter
+// uber的漏桶只有Take, 不能拒绝请求, 所以Allow返回false时名额也已经用掉了
+// Take之外拿不到内部状态, 所以没有实现QuotaReporter
+type Uber struct {
+	mu    sync.RWMutex
+	rl    ratelimit.Limiter // Limit为0时是nil, 全部拒绝
+	per   time.Duration
+	clock Clock
+}
+
+// NewUber 创建uber漏桶, Burst对应WithSlack
+func NewUber(r Rate, opts ...Option) *Uber {
+	u := &Uber{clock: buildOptions(opts).clock}
+	u.SetRate(r)
+	return u
+}
+
+// take ok为false表示Limit为0
+func (u *Uber) take() (delay time.Duration, ok bool) {
+	u.mu.RLock()
+	rl, per := u.rl, u.per
+	u.mu.RUnlock()
+	if rl == nil {
+		return per, false
+	}
+	return rl.Take().Sub(u.clock.Now()), true
+}
+
+func (u *Uber) Allow() bool {
+	delay, ok := u.take()
+	return ok && delay <= 0
+}
+
+func (u *Uber) Reserve() Reservation {
+	delay, ok := u.take()
+	return Reservation{OK: ok, Delay: delay}
+}
+
+func (u *Uber) Wait(ctx context.Context) error {
+	return waitReserve(ctx, u, u.clock)
+}
+
+// SetRate uber的Limiter不能改速率, 直接换一个新的
+// uber里会用Per除以Limit, Limit为0时不创建
+func (u *Uber) SetRate(r Rate) {
+	checkPer(r)
+	var rl ratelimit.Limiter
+	if r.Limit > 0 {
+		rl = ratelimit.New(r.Limit,
+			ratelimit.Per(r.Per),
+			ratelimit.WithSlack(r.Burst),
+			ratelimit.WithClock(noSleepClock{u.clock}))
+	}
+
+	u.mu.Lock()
+	u.rl, u.per = rl, r.Per
+	u.mu.Unlock()
+}
+
+// XRate 把 golang.org/x/time/rate 适配成Limiter
+// 时间都从clock取, 调用rate的xxxN(t)版本
+type XRate struct {
+	lim   *rate.Limiter
+	clock Clock
+}
+
+// NewXRate 创建令牌桶, Burst为0时用1
+func NewXRate(r Rate, opts ...Option) *XRate {
+	checkPer(r)
+	return &XRate{
+		lim:   rate.NewLimiter(xrateLimit(r), xrateBurst(r)),
+		clock: buildOptions(opts).clock,
+	}
+}
+
+// xrateLimit rate.Every(0)是不限速, Limit为0时要换成0
+func xrateLimit(r Rate) rate.Limit {
+	if r.Limit <= 0 {
+		return 0
+	}
+	return rate.Every(r.interval())
+}
+
+// xrateBurst 速率是0时桶里还有Burst个令牌, 要全部拒绝只能把Burst也设成0
+func xrateBurst(r Rate) int {
+	if r.Limit <= 0 {
+		return 0
+	}
+	if r.Burst <= 0 {
+		return 1
+	}
+	return r.Burst
+}
+
+func (x *XRate) Allow() bool {
+	return x.lim.AllowN(x.clock.Now(), 1)
+}
+
+func (x *XRate) Reserve() Reservation {
+	now := x.clock.Now()
+	r := x.lim.ReserveN(now, 1)
+	if !r.OK() {
+		return Reservation{}
+	}
+	return Reservation{OK: true, Delay: r.DelayFrom(now), cancel: func() {
+		r.CancelAt(x.clock.Now())
+	}}
+}
+
+// Wait rate.Limiter.Wait用的是真实的timer, 这里换成clock的
+func (x *XRate) Wait(ctx context.Context) error {
+	return waitReserve(ctx, x, x.clock)
+}
+
+func (x *XRate) Quota() Quota {
+	tokens := x.lim.TokensAt(x.clock.Now())
+	q := Quota{Limit: x.lim.Burst(), Remaining: max(int(tokens), 0)}
+	if limit := float64(x.lim.Limit()); limit > 0 {
+		q.Reset = time.Duration((float64(q.Limit) - tokens) / limit * float64(time.Second))
+	}
+	return q
+}
+
+func (x *XRate) SetRate(r Rate) {
+	checkPer(r)
+	now := x.clock.Now()
+	x.lim.SetLimitAt(now, xrateLimit(r))
+	x.lim.SetBurstAt(now, xrateBurst(r))
+}
@@ -0,0 +1,216 @@
+package second
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// LeakyBucket 漏桶, 和uber ratelimit的算法一样, 请求之间的间隔平均是Per/Limit
+// Burst(slack)允许空闲一段时间后攒下最多Burst个请求的额度
+// 和uber不同的是Allow在没有额度时不会扣名额
+type LeakyBucket struct {
+	mu       sync.Mutex
+	clock    Clock
+	interval time.Duration // 0表示Limit为0, 全部拒绝
+	slack    time.Duration
+	per      time.Duration
+	last     time.Time // 上一个请求被放行的时间点
+}
+
+// NewLeakyBucket 创建漏桶
+func NewLeakyBucket(r Rate, opts ...Option) *LeakyBucket {
+	l := &LeakyBucket{clock: buildOptions(opts).clock}
+	l.SetRate(r)
+	return l
+}
+
+// next 下一个请求最早可以放行的时间点
+func (l *LeakyBucket) next(now time.Time) time.Time {
+	if l.last.IsZero() {
+		return now
+	}
+	t := l.last.Add(l.interval)
+	if floor := now.Add(-l.slack); t.Before(floor) {
+		t = floor
+	}
+	return t
+}
+
+func (l *LeakyBucket) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval <= 0 {
+		return false
+	}
+	now := l.clock.Now()
+	t := l.next(now)
+	if t.After(now) {
+		return false
+	}
+	l.last = t
+	return true
+}
+
+func (l *LeakyBucket) Reserve() Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval <= 0 {
+		return Reservation{Delay: l.per}
+	}
+	now := l.clock.Now()
+	prev, t := l.last, l.next(now)
+	l.last = t
+
+	delay := t.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	return Reservation{OK: true, Delay: delay, cancel: func() {
+		l.mu.Lock()
+		// 后面没有人再预订过才能还回去
+		if l.last.Equal(t) {
+			l.last = prev
+		}
+		l.mu.Unlock()
+	}}
+}
+
+func (l *LeakyBucket) Wait(ctx context.Context) error {
+	return waitReserve(ctx, l, l.clock)
+}
+
+// Quota 空闲时最多能连续放行Burst+1个请求
+func (l *LeakyBucket) Quota() Quota {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval <= 0 {
+		return Quota{}
+	}
+	now := l.clock.Now()
+	q := Quota{Limit: int(l.slack/l.interval) + 1}
+	if l.last.IsZero() {
+		// 第一个请求之前还没有攒下slack
+		q.Remaining = 1
+		return q
+	}
+	if t := l.next(now); !t.After(now) {
+		q.Remaining = int(now.Sub(t)/l.interval) + 1
+	}
+	if reset := l.last.Add(l.interval + l.slack).Sub(now); reset > 0 {
+		q.Reset = reset
+	}
+	return q
+}
+
+func (l *LeakyBucket) SetRate(r Rate) {
+	checkPer(r)
+	l.mu.Lock()
+	l.per = r.Per
+	l.interval = r.interval()
+	l.slack = time.Duration(r.Burst) * l.interval
+	l.mu.Unlock()
+}
+
+// TokenBucket 令牌桶, 每Per时间放Limit个令牌, 桶的容量是Burst
+// 和 x/time/rate 的算法一样, 令牌可以欠, 欠多少就要等多久
+type TokenBucket struct {
+	mu       sync.Mutex
+	clock    Clock
+	perToken time.Duration // 0表示Limit为0, 全部拒绝
+	per      time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewTokenBucket 创建令牌桶, 初始是满的, Burst为0时用1
+func NewTokenBucket(r Rate, opts ...Option) *TokenBucket {
+	t := &TokenBucket{clock: buildOptions(opts).clock}
+	t.SetRate(r)
+	t.tokens = t.burst
+	return t
+}
+
+// advance 把从last到now应该放的令牌放进桶
+func (t *TokenBucket) advance(now time.Time) {
+	if !t.last.IsZero() && t.perToken > 0 {
+		elapsed := now.Sub(t.last)
+		t.tokens = math.Min(t.burst, t.tokens+float64(elapsed)/float64(t.perToken))
+	}
+	t.last = now
+}
+
+func (t *TokenBucket) Allow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.advance(t.clock.Now())
+	if t.perToken <= 0 || t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+func (t *TokenBucket) Reserve() Reservation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.perToken <= 0 {
+		return Reservation{Delay: t.per}
+	}
+	t.advance(t.clock.Now())
+	t.tokens--
+
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens * float64(t.perToken))
+	}
+	return Reservation{OK: true, Delay: delay, cancel: func() {
+		t.mu.Lock()
+		t.advance(t.clock.Now())
+		t.tokens = math.Min(t.burst, t.tokens+1)
+		t.mu.Unlock()
+	}}
+}
+
+func (t *TokenBucket) Wait(ctx context.Context) error {
+	return waitReserve(ctx, t, t.clock)
+}
+
+func (t *TokenBucket) Quota() Quota {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.perToken <= 0 {
+		return Quota{}
+	}
+	t.advance(t.clock.Now())
+	return Quota{
+		Limit:     int(t.burst),
+		Remaining: max(int(t.tokens), 0),
+		Reset:     time.Duration((t.burst - t.tokens) * float64(t.perToken)),
+	}
+}
+
+func (t *TokenBucket) SetRate(r Rate) {
+	checkPer(r)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.advance(t.clock.Now())
+	t.per = r.Per
+	t.perToken = r.interval()
+	t.burst = float64(r.Burst)
+	if t.burst < 1 {
+		t.burst = 1
+	}
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+}
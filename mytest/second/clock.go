@@ -0,0 +1,233 @@
+package second
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 时间的抽象, 所有的Limiter都可以传入, 测试时用FakeClock
+// 也满足 go.uber.org/ratelimit 的Clock接口
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer 对应time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker 对应time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// RealClock 用time包实现的Clock
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) Sleep(d time.Duration)            { time.Sleep(d) }
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (r realTimer) C() <-chan time.Time        { return r.t.C }
+func (r realTimer) Stop() bool                 { return r.t.Stop() }
+func (r realTimer) Reset(d time.Duration) bool { return r.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time   { return r.t.C }
+func (r realTicker) Stop()                 { r.t.Stop() }
+func (r realTicker) Reset(d time.Duration) { r.t.Reset(d) }
+
+// FakeClock 手动推进的时钟, 只有调用Advance时间才会走
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeTimer // 还没触发的timer和ticker
+}
+
+// NewFakeClock 创建停在now的时钟
+func NewFakeClock(now time.Time) *FakeClock {
+	f := &FakeClock{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Sleep 阻塞到别的go程用Advance把时间推过d
+func (f *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-f.NewTimer(d).C()
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("second: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return fakeTicker{t}
+}
+
+// Advance 把时间往前推d, 按顺序触发到期的timer和ticker
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	end := f.now.Add(d)
+	for len(f.waiters) > 0 && !f.waiters[0].when.After(end) {
+		t := f.waiters[0]
+		f.now = t.when
+		select {
+		case t.c <- f.now:
+		default:
+		}
+
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			f.sortLocked()
+		} else {
+			f.removeLocked(t)
+		}
+	}
+	f.now = end
+	f.cond.Broadcast()
+}
+
+// BlockUntil 阻塞到至少有n个timer/ticker/Sleep在等待
+// 用来确认被测的go程已经开始等了, 再Advance
+func (f *FakeClock) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// BlockUntilContext 和BlockUntil一样, ctx结束时返回ctx.Err()
+// 被测的go程可能不等就返回了, 这时候用ctx放掉调用方
+func (f *FakeClock) BlockUntilContext(ctx context.Context, n int) error {
+	stop := context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	})
+	defer stop()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f.cond.Wait()
+	}
+	return nil
+}
+
+// AdvanceNext 把时间推到最早的timer/ticker到期, 返回推进了多久, 没有在等的返回0
+func (f *FakeClock) AdvanceNext() time.Duration {
+	f.mu.Lock()
+	if len(f.waiters) == 0 {
+		f.mu.Unlock()
+		return 0
+	}
+	d := f.waiters[0].when.Sub(f.now)
+	f.mu.Unlock()
+
+	f.Advance(d)
+	return d
+}
+
+func (f *FakeClock) addLocked(t *fakeTimer) {
+	f.waiters = append(f.waiters, t)
+	f.sortLocked()
+	f.cond.Broadcast()
+}
+
+func (f *FakeClock) removeLocked(t *fakeTimer) bool {
+	for i, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClock) sortLocked() {
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].when.Before(f.waiters[j].when)
+	})
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.removeLocked(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	active := f.removeLocked(t)
+	if t.period > 0 {
+		t.period = d
+	}
+	t.when = f.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		// 和time.Timer一样, 0或者负数马上触发
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		return active
+	}
+	f.addLocked(t)
+	return active
+}
+
+type fakeTicker struct{ t *fakeTimer }
+
+func (t fakeTicker) C() <-chan time.Time   { return t.t.c }
+func (t fakeTicker) Stop()                 { t.t.Stop() }
+func (t fakeTicker) Reset(d time.Duration) { t.t.Reset(d) }
@@ -0,0 +1,103 @@
+package second
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_FakeClock_Sleep(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	done := make(chan struct{})
+	go func() {
+		clk.Sleep(time.Second)
+		close(done)
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("woke up too early")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	<-done
+	if got := clk.Now().Sub(epoch); got != time.Second {
+		t.Fatalf("now = %v", got)
+	}
+}
+
+func Test_FakeClock_Timer(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	t1 := clk.NewTimer(time.Second)
+	t2 := clk.NewTimer(2 * time.Second)
+	if !t2.Stop() {
+		t.Fatal("Stop should report an active timer")
+	}
+
+	clk.Advance(3 * time.Second)
+	if at := <-t1.C(); !at.Equal(epoch.Add(time.Second)) {
+		t.Fatalf("fired at %v", at)
+	}
+	select {
+	case <-t2.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	if t1.Reset(time.Second) {
+		t.Fatal("Reset of a fired timer should report inactive")
+	}
+	clk.Advance(time.Second)
+	<-t1.C()
+}
+
+func Test_FakeClock_Ticker(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	tk := clk.NewTicker(100 * time.Millisecond)
+	defer tk.Stop()
+
+	for i := 1; i <= 3; i++ {
+		clk.Advance(100 * time.Millisecond)
+		if at := <-tk.C(); !at.Equal(epoch.Add(time.Duration(i) * 100 * time.Millisecond)) {
+			t.Fatalf("tick %d at %v", i, at)
+		}
+	}
+
+	// 和time.Ticker一样, 没人读的时候多余的tick丢掉
+	clk.Advance(time.Second)
+	<-tk.C()
+	select {
+	case <-tk.C():
+		t.Fatal("ticks should be dropped")
+	default:
+	}
+}
+
+func Test_FakeClock_AdvanceNext(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	if d := clk.AdvanceNext(); d != 0 {
+		t.Fatalf("AdvanceNext without waiters = %v", d)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		clk.Sleep(3 * time.Second)
+		close(done)
+	}()
+	if err := clk.BlockUntilContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if d := clk.AdvanceNext(); d != 3*time.Second {
+		t.Fatalf("AdvanceNext = %v, want 3s", d)
+	}
+	<-done
+
+	// 没有go程会等的时候用ctx放掉
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := clk.BlockUntilContext(ctx, 1); err != context.Canceled {
+		t.Fatalf("err = %v, want Canceled", err)
+	}
+}
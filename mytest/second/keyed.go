@@ -0,0 +1,163 @@
+package second
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// KeyedConfig 按key限流的配置
+type KeyedConfig struct {
+	Algorithm Algorithm
+	Rate      Rate
+	// MaxKeys 最多记录多少个key, 超过后淘汰最久没用的, 0表示不限制
+	MaxKeys int
+	// IdleTimeout key多久没用就删掉, 0表示不删
+	IdleTimeout time.Duration
+	// Overrides 单独给某些key设置速率
+	Overrides map[string]Rate
+}
+
+// Keyed 每个key(API key, 用户, IP)一个Limiter, 第一次用到时才创建
+// 用LRU限制内存, 空闲的key会被删掉, 下次再来就是一个新的桶
+type Keyed struct {
+	mu        sync.Mutex
+	cfg       KeyedConfig
+	opts      []Option
+	clock     Clock
+	overrides map[string]Rate
+	lru       list.List // 元素是*keyedEntry, 队头是最近用过的
+	items     map[string]*list.Element
+}
+
+type keyedEntry struct {
+	key      string
+	limiter  Limiter
+	lastSeen time.Time
+}
+
+// NewKeyed 创建按key限流的Limiter, opts会传给每个key的Limiter
+func NewKeyed(cfg KeyedConfig, opts ...Option) (*Keyed, error) {
+	// 先创建一个检查配置
+	if _, err := New(cfg.Algorithm, cfg.Rate, opts...); err != nil {
+		return nil, err
+	}
+
+	k := &Keyed{
+		cfg:       cfg,
+		opts:      opts,
+		clock:     buildOptions(opts).clock,
+		overrides: make(map[string]Rate, len(cfg.Overrides)),
+		items:     make(map[string]*list.Element),
+	}
+	for key, r := range cfg.Overrides {
+		k.overrides[key] = r
+	}
+	return k, nil
+}
+
+// Get 返回key对应的Limiter, 没有就创建
+func (k *Keyed) Get(key string) Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.clock.Now()
+	k.evictIdleLocked(now)
+
+	if elem, ok := k.items[key]; ok {
+		e := elem.Value.(*keyedEntry)
+		e.lastSeen = now
+		k.lru.MoveToFront(elem)
+		return e.limiter
+	}
+
+	r := k.cfg.Rate
+	if o, ok := k.overrides[key]; ok {
+		r = o
+	}
+	// 配置在NewKeyed和SetOverride里检查过了
+	l, _ := New(k.cfg.Algorithm, r, k.opts...)
+	k.items[key] = k.lru.PushFront(&keyedEntry{key: key, limiter: l, lastSeen: now})
+
+	if k.cfg.MaxKeys > 0 && k.lru.Len() > k.cfg.MaxKeys {
+		k.removeLocked(k.lru.Back())
+	}
+	return l
+}
+
+func (k *Keyed) Allow(key string) bool {
+	return k.Get(key).Allow()
+}
+
+func (k *Keyed) Wait(ctx context.Context, key string) error {
+	return k.Get(key).Wait(ctx)
+}
+
+func (k *Keyed) Reserve(key string) Reservation {
+	return k.Get(key).Reserve()
+}
+
+// SetOverride 给key单独设置速率, 已经存在的桶马上生效
+func (k *Keyed) SetOverride(key string, r Rate) error {
+	if _, err := New(k.cfg.Algorithm, r); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.overrides[key] = r
+	if elem, ok := k.items[key]; ok {
+		elem.Value.(*keyedEntry).limiter.SetRate(r)
+	}
+	return nil
+}
+
+// RemoveOverride 去掉key的单独设置, 恢复默认速率
+func (k *Keyed) RemoveOverride(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.overrides[key]; !ok {
+		return
+	}
+	delete(k.overrides, key)
+	if elem, ok := k.items[key]; ok {
+		elem.Value.(*keyedEntry).limiter.SetRate(k.cfg.Rate)
+	}
+}
+
+// Len 当前记录的key数
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lru.Len()
+}
+
+// EvictIdle 删掉所有空闲超时的key, 返回删掉的个数
+// Get的时候也会顺便清理, 访问很少时可以定时调用
+func (k *Keyed) EvictIdle() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.evictIdleLocked(k.clock.Now())
+}
+
+// evictIdleLocked LRU的队尾就是最久没用的, 从队尾开始删, 遇到没超时的就停
+func (k *Keyed) evictIdleLocked(now time.Time) int {
+	if k.cfg.IdleTimeout <= 0 {
+		return 0
+	}
+	n := 0
+	for elem := k.lru.Back(); elem != nil; elem = k.lru.Back() {
+		if now.Sub(elem.Value.(*keyedEntry).lastSeen) < k.cfg.IdleTimeout {
+			break
+		}
+		k.removeLocked(elem)
+		n++
+	}
+	return n
+}
+
+func (k *Keyed) removeLocked(elem *list.Element) {
+	e := k.lru.Remove(elem).(*keyedEntry)
+	delete(k.items, e.key)
+}
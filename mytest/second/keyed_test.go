@@ -0,0 +1,118 @@
+package second
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Keyed_PerKey(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	k, err := NewKeyed(KeyedConfig{
+		Algorithm: AlgTokenBucket,
+		Rate:      Rate{Limit: 1, Per: time.Second, Burst: 2},
+		Overrides: map[string]Rate{"vip": {Limit: 1, Per: time.Second, Burst: 5}},
+	}, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(key string) int {
+		n := 0
+		for k.Allow(key) {
+			n++
+		}
+		return n
+	}
+	if n := count("alice"); n != 2 {
+		t.Fatalf("alice: %d", n)
+	}
+	// 每个key一个桶, 互不影响
+	if n := count("bob"); n != 2 {
+		t.Fatalf("bob: %d", n)
+	}
+	if n := count("vip"); n != 5 {
+		t.Fatalf("vip: %d", n)
+	}
+
+	k.SetOverride("alice", Rate{Limit: 10, Per: time.Second, Burst: 10})
+	clk.Advance(time.Second)
+	if n := count("alice"); n != 10 {
+		t.Fatalf("alice after override: %d", n)
+	}
+}
+
+func Test_Keyed_LRU(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	k, _ := NewKeyed(KeyedConfig{
+		Algorithm: AlgFixedWindow,
+		Rate:      Rate{Limit: 1, Per: time.Hour},
+		MaxKeys:   2,
+	}, WithClock(clk))
+
+	k.Allow("a")
+	k.Allow("b")
+	k.Allow("a") // a变成最近用过的
+	k.Allow("c") // 淘汰b
+
+	if k.Len() != 2 {
+		t.Fatalf("len = %d", k.Len())
+	}
+	// b被淘汰了, 重新创建的桶是满的
+	if !k.Allow("b") {
+		t.Fatal("evicted key should start with a fresh bucket")
+	}
+	// b回来时淘汰的是a, c还在, 名额已经用完
+	if k.Allow("c") {
+		t.Fatal("c should still be limited")
+	}
+}
+
+func Test_Keyed_Idle(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	k, _ := NewKeyed(KeyedConfig{
+		Algorithm:   AlgFixedWindow,
+		Rate:        Rate{Limit: 1, Per: time.Hour},
+		IdleTimeout: time.Minute,
+	}, WithClock(clk))
+
+	k.Allow("a")
+	clk.Advance(30 * time.Second)
+	k.Allow("b")
+	clk.Advance(30 * time.Second)
+
+	if n := k.EvictIdle(); n != 1 {
+		t.Fatalf("evicted %d", n)
+	}
+	if k.Len() != 1 {
+		t.Fatalf("len = %d", k.Len())
+	}
+
+	clk.Advance(time.Minute)
+	k.Allow("c") // Get时顺便清理
+	if k.Len() != 1 {
+		t.Fatalf("len = %d", k.Len())
+	}
+}
+
+// 一百万个不同的key, 只保留最近的十万个
+func Benchmark_Keyed_MillionKeys(b *testing.B) {
+	const n = 1000000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "user-" + strconv.Itoa(i)
+	}
+
+	k, _ := NewKeyed(KeyedConfig{
+		Algorithm: AlgTokenBucket,
+		Rate:      PerSecond(100),
+		MaxKeys:   n / 10,
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k.Allow(keys[i%n])
+	}
+	b.ReportMetric(float64(k.Len()), "keys")
+}
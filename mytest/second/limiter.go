@@ -0,0 +1,177 @@
+package second
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Limiter 统一的限流接口, 屏蔽 go.uber.org/ratelimit 和 golang.org/x/time/rate 的差异
+// 换算法只需要改New的第一个参数
+type Limiter interface {
+	// Allow 现在能不能放行, 能放行时会消耗一个名额
+	Allow() bool
+	// Wait 阻塞到可以放行或者ctx结束
+	Wait(ctx context.Context) error
+	// Reserve 预订一个名额, 见Reservation
+	Reserve() Reservation
+	// SetRate 运行时修改速率
+	SetRate(r Rate)
+}
+
+// Rate 每Per时间最多Limit个请求, Burst是允许的突发量
+// 令牌桶里Burst是桶的容量, 漏桶里Burst是slack, 窗口类算法忽略Burst
+// Limit为0或者负数时所有算法都全部拒绝, Reserve返回OK为false; Per必须是正数
+type Rate struct {
+	Limit int
+	Per   time.Duration
+	Burst int
+}
+
+// PerSecond 每秒n个请求, 突发量也是n
+func PerSecond(n int) Rate {
+	return Rate{Limit: n, Per: time.Second, Burst: n}
+}
+
+// checkPer Per为0或者负数没有意义, 和time.NewTicker一样直接panic, 构造函数和SetRate都要检查
+// New会先检查返回错误, 直接调NewXxx时才会panic
+func checkPer(r Rate) {
+	if r.Per <= 0 {
+		panic(fmt.Sprintf("second: non-positive Per in rate %+v", r))
+	}
+}
+
+// interval 两个请求之间的平均间隔, Limit为0或者负数时返回0表示全部拒绝
+func (r Rate) interval() time.Duration {
+	if r.Limit <= 0 {
+		return 0
+	}
+	return r.Per / time.Duration(r.Limit)
+}
+
+// Reservation 是Reserve的结果
+// OK为true表示名额已经扣掉, 等Delay之后就可以执行, 不用了要Cancel
+// OK为false表示现在预订不到, Delay之后再试
+type Reservation struct {
+	OK     bool
+	Delay  time.Duration
+	cancel func()
+}
+
+// Cancel 把预订的名额还回去, 只有OK为true时有意义
+func (r Reservation) Cancel() {
+	if r.OK && r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// Quota 某一时刻的额度, 对应HTTP的RateLimit-Limit/Remaining/Reset响应头
+type Quota struct {
+	Limit     int           // 一个周期最多放行多少个请求
+	Remaining int           // 现在还能放行多少个
+	Reset     time.Duration // 多久之后额度恢复满
+}
+
+// QuotaReporter 能报告当前额度的Limiter, 除了Uber都实现了
+// 查询不消耗名额
+type QuotaReporter interface {
+	Quota() Quota
+}
+
+// Option 定制Limiter
+type Option func(o *options)
+
+type options struct {
+	clock Clock
+}
+
+// WithClock 指定时钟, 默认是RealClock, 测试时传FakeClock
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func buildOptions(opts []Option) options {
+	o := options{clock: RealClock}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Algorithm 限流算法的名字, 用于从配置里创建Limiter
+type Algorithm string
+
+const (
+	AlgUber          Algorithm = "uber"           // go.uber.org/ratelimit
+	AlgXRate         Algorithm = "x_rate"         // golang.org/x/time/rate
+	AlgLeakyBucket   Algorithm = "leaky_bucket"   // 漏桶
+	AlgTokenBucket   Algorithm = "token_bucket"   // 令牌桶
+	AlgFixedWindow   Algorithm = "fixed_window"   // 固定窗口
+	AlgSlidingLog    Algorithm = "sliding_log"    // 滑动日志
+	AlgSlidingWindow Algorithm = "sliding_window" // 滑动窗口计数
+)
+
+// New 按算法名创建Limiter
+func New(alg Algorithm, r Rate, opts ...Option) (Limiter, error) {
+	if r.Limit <= 0 || r.Per <= 0 {
+		return nil, fmt.Errorf("second: invalid rate %+v", r)
+	}
+
+	switch alg {
+	case AlgUber:
+		return NewUber(r, opts...), nil
+	case AlgXRate:
+		return NewXRate(r, opts...), nil
+	case AlgLeakyBucket:
+		return NewLeakyBucket(r, opts...), nil
+	case AlgTokenBucket:
+		return NewTokenBucket(r, opts...), nil
+	case AlgFixedWindow:
+		return NewFixedWindow(r, opts...), nil
+	case AlgSlidingLog:
+		return NewSlidingLog(r, opts...), nil
+	case AlgSlidingWindow:
+		return NewSlidingWindow(r, opts...), nil
+	}
+	return nil, fmt.Errorf("second: unknown algorithm %q", alg)
+}
+
+// waitReserve 用Reserve实现Wait, 给没有原生Wait的实现用
+func waitReserve(ctx context.Context, l Limiter, clock Clock) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r := l.Reserve()
+		if r.OK && r.Delay <= 0 {
+			return nil
+		}
+		if r.OK {
+			// r.Delay是按clock算的, 离deadline还有多久也要按clock算
+			if deadline, ok := ctx.Deadline(); ok && deadline.Sub(clock.Now()) < r.Delay {
+				// 等不到了, 别占着名额
+				r.Cancel()
+				return context.DeadlineExceeded
+			}
+		}
+
+		delay := r.Delay
+		if !r.OK && delay <= 0 {
+			delay = time.Millisecond
+		}
+		timer := clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.Cancel()
+			return ctx.Err()
+		case <-timer.C():
+			if r.OK {
+				return nil
+			}
+		}
+	}
+}
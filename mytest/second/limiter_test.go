@@ -0,0 +1,267 @@
+package second
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var allAlgorithms = []Algorithm{
+	AlgUber, AlgXRate, AlgLeakyBucket, AlgTokenBucket,
+	AlgFixedWindow, AlgSlidingLog, AlgSlidingWindow,
+}
+
+func Test_New(t *testing.T) {
+	for _, alg := range allAlgorithms {
+		if _, err := New(alg, PerSecond(10)); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+	}
+	if _, err := New("nope", PerSecond(10)); err == nil {
+		t.Fatal("unknown algorithm should fail")
+	}
+	if _, err := New(AlgTokenBucket, Rate{}); err == nil {
+		t.Fatal("zero rate should fail")
+	}
+}
+
+// 同一个循环换不同的算法, 一秒内放行的数量都不应该超过 Limit+Burst
+func Test_Limiter_Allow(t *testing.T) {
+	for _, alg := range allAlgorithms {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			l, _ := New(alg, Rate{Limit: 5, Per: time.Second, Burst: 3})
+			allowed := 0
+			for i := 0; i < 20; i++ {
+				if l.Allow() {
+					allowed++
+				}
+			}
+			if allowed == 0 || allowed > 5+3 {
+				t.Fatalf("allowed %d", allowed)
+			}
+		})
+	}
+}
+
+func Test_Limiter_Wait(t *testing.T) {
+	for _, alg := range allAlgorithms {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			clk := NewFakeClock(epoch)
+			l, _ := New(alg, Rate{Limit: 10, Per: 100 * time.Millisecond, Burst: 1}, WithClock(clk))
+
+			for i := 0; i < 20; i++ {
+				if err := fakeWait(clk, l); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// 20个请求, 每100ms 10个, 至少要等一个周期
+			if d := clk.Now().Sub(epoch); d < 100*time.Millisecond {
+				t.Fatalf("too fast: %v", d)
+			}
+		})
+	}
+}
+
+// fakeWait 在后台调用Wait, 每次Wait停在timer上就把时钟推到timer到期, 不用真的等
+func fakeWait(clk *FakeClock, l Limiter) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Wait(context.Background())
+		cancel()
+	}()
+	for clk.BlockUntilContext(ctx, 1) == nil {
+		clk.AdvanceNext()
+	}
+	return <-done
+}
+
+func Test_Limiter_WaitDeadline(t *testing.T) {
+	for _, alg := range allAlgorithms {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			l, _ := New(alg, Rate{Limit: 1, Per: time.Hour})
+			l.Allow()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := l.Wait(ctx); err == nil {
+				t.Fatal("Wait should fail before the deadline")
+			}
+		})
+	}
+}
+
+func Test_TokenBucket_ReserveCancel(t *testing.T) {
+	l := NewTokenBucket(Rate{Limit: 1, Per: time.Hour, Burst: 1})
+	r := l.Reserve()
+	if !r.OK || r.Delay != 0 {
+		t.Fatalf("reservation: %+v", r)
+	}
+	r.Cancel()
+	if !l.Allow() {
+		t.Fatal("canceled reservation should give the token back")
+	}
+}
+
+func Test_Limiter_SetRate(t *testing.T) {
+	for _, alg := range allAlgorithms {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			clk := NewFakeClock(epoch)
+			l, _ := New(alg, Rate{Limit: 1, Per: time.Hour, Burst: 1}, WithClock(clk))
+			for l.Allow() {
+			}
+			l.SetRate(Rate{Limit: 1000, Per: time.Millisecond, Burst: 1000})
+			clk.Advance(5 * time.Millisecond)
+			if !l.Allow() {
+				t.Fatal("Allow should pass after raising the rate")
+			}
+		})
+	}
+}
+
+// constructors 直接调构造函数, 不经过New的检查
+var constructors = map[Algorithm]func(r Rate, opts ...Option) Limiter{
+	AlgUber:          func(r Rate, opts ...Option) Limiter { return NewUber(r, opts...) },
+	AlgXRate:         func(r Rate, opts ...Option) Limiter { return NewXRate(r, opts...) },
+	AlgLeakyBucket:   func(r Rate, opts ...Option) Limiter { return NewLeakyBucket(r, opts...) },
+	AlgTokenBucket:   func(r Rate, opts ...Option) Limiter { return NewTokenBucket(r, opts...) },
+	AlgFixedWindow:   func(r Rate, opts ...Option) Limiter { return NewFixedWindow(r, opts...) },
+	AlgSlidingLog:    func(r Rate, opts ...Option) Limiter { return NewSlidingLog(r, opts...) },
+	AlgSlidingWindow: func(r Rate, opts ...Option) Limiter { return NewSlidingWindow(r, opts...) },
+}
+
+// Test_ZeroLimit 所有算法Limit为0时都全部拒绝, Allow, Reserve, Wait的结果一致, Per不是正数时panic
+func Test_ZeroLimit(t *testing.T) {
+	for alg, newLimiter := range constructors {
+		alg, newLimiter := alg, newLimiter
+		t.Run(string(alg), func(t *testing.T) {
+			clk := NewFakeClock(epoch)
+			l := newLimiter(Rate{Limit: 0, Per: time.Second, Burst: 3}, WithClock(clk))
+			denied := func(when string) {
+				t.Helper()
+				if l.Allow() {
+					t.Fatalf("%s: Allow should deny", when)
+				}
+				if r := l.Reserve(); r.OK {
+					t.Fatalf("%s: reservation %+v", when, r)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				if err := l.Wait(ctx); err == nil {
+					t.Fatalf("%s: Wait should fail", when)
+				}
+			}
+			denied("Limit 0")
+
+			// 运行时改成0也一样
+			// x/time/rate的Burst从0改大时桶是空的, 等一个周期再试
+			l.SetRate(Rate{Limit: 5, Per: time.Second, Burst: 3})
+			clk.Advance(time.Second)
+			if !l.Allow() {
+				t.Fatal("Allow after SetRate")
+			}
+			l.SetRate(Rate{Limit: 0, Per: time.Second, Burst: 3})
+			denied("SetRate to Limit 0")
+
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatal("SetRate with Per 0 should panic")
+					}
+				}()
+				l.SetRate(Rate{Limit: 1})
+			}()
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatal("constructor with Per 0 should panic")
+					}
+				}()
+				newLimiter(Rate{Limit: 1}, WithClock(clk))
+			}()
+		})
+	}
+}
+
+// Test_Limiter_WaitFakeDeadline 离deadline还有多久按注入的clock算, 不按真实时间
+func Test_Limiter_WaitFakeDeadline(t *testing.T) {
+	// 假的时钟比真实时间快1小时, deadline在真实时间的1.5小时后, 按假的时钟只剩半小时
+	clk := NewFakeClock(time.Now().Add(time.Hour))
+	l := NewTokenBucket(Rate{Limit: 1, Per: time.Hour, Burst: 1}, WithClock(clk))
+	l.Allow()
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(90*time.Minute))
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx) }()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("err = %v, want DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait should give up at once")
+	}
+	// 等不到的预订还回去了
+	if r := l.Reserve(); r.Delay != time.Hour {
+		t.Fatalf("reservation %+v", r)
+	}
+}
+
+// 本地漏桶和uber的漏桶, 同样的slack应该得到同样的延迟
+func Test_LeakyBucket_SameAsUber(t *testing.T) {
+	r := Rate{Limit: 10, Per: time.Second, Burst: 3}
+	clk1, clk2 := NewFakeClock(epoch), NewFakeClock(epoch)
+	leaky, uber := NewLeakyBucket(r, WithClock(clk1)), NewUber(r, WithClock(clk2))
+
+	for i := 0; i < 20; i++ {
+		r1, r2 := leaky.Reserve(), uber.Reserve()
+		if r1.Delay != r2.Delay {
+			t.Fatalf("%d: leaky %v, uber %v", i, r1.Delay, r2.Delay)
+		}
+		step := r1.Delay
+		if i%5 == 0 {
+			step += 700 * time.Millisecond
+		}
+		clk1.Advance(step)
+		clk2.Advance(step)
+	}
+}
+
+// 用完额度后Remaining是0, 过了Reset之后额度恢复满
+func Test_Limiter_Quota(t *testing.T) {
+	for _, alg := range allAlgorithms {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			clk := NewFakeClock(epoch)
+			l, _ := New(alg, Rate{Limit: 5, Per: time.Second, Burst: 3}, WithClock(clk))
+			qr, ok := l.(QuotaReporter)
+			if !ok {
+				if alg != AlgUber {
+					t.Fatalf("%s should report quota", alg)
+				}
+				return
+			}
+
+			for l.Allow() {
+			}
+			q := qr.Quota()
+			if q.Limit <= 0 || q.Remaining != 0 || q.Reset <= 0 {
+				t.Fatalf("exhausted quota %+v", q)
+			}
+
+			clk.Advance(q.Reset)
+			q = qr.Quota()
+			if q.Remaining != q.Limit || q.Reset != 0 {
+				t.Fatalf("quota %+v after reset", q)
+			}
+			// 查询不消耗名额
+			if q2 := qr.Quota(); q2 != q {
+				t.Fatalf("quota changed: %+v, %+v", q, q2)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package second
+
+import (
+	"context"
+	"time"
+)
+
+// Multi 同时满足多个Limiter才放行, 比如每秒5个并且每分钟100个
+// 和kong rate-limiting插件的second/minute/hour一样
+type Multi struct {
+	limiters []Limiter
+	clock    Clock
+}
+
+// NewMulti 组合多个Limiter
+func NewMulti(limiters []Limiter, opts ...Option) *Multi {
+	return &Multi{limiters: limiters, clock: buildOptions(opts).clock}
+}
+
+func (m *Multi) Allow() bool {
+	r := m.Reserve()
+	if r.OK && r.Delay == 0 {
+		return true
+	}
+	r.Cancel()
+	return false
+}
+
+// Reserve 每个Limiter都预订, 有一个预订不到就把其他的还回去
+// Delay取最大的那个
+func (m *Multi) Reserve() Reservation {
+	rs := make([]Reservation, 0, len(m.limiters))
+	ok := true
+	var delay time.Duration
+	for _, l := range m.limiters {
+		r := l.Reserve()
+		rs = append(rs, r)
+		ok = ok && r.OK
+		delay = max(delay, r.Delay)
+	}
+
+	cancel := func() {
+		for _, r := range rs {
+			r.Cancel()
+		}
+	}
+	if !ok {
+		cancel()
+		return Reservation{Delay: delay}
+	}
+	return Reservation{OK: true, Delay: delay, cancel: cancel}
+}
+
+func (m *Multi) Wait(ctx context.Context) error {
+	return waitReserve(ctx, m, m.clock)
+}
+
+// SetRate 组合起来的Limiter速率各不相同, 这里给每个都设置成r
+func (m *Multi) SetRate(r Rate) {
+	for _, l := range m.limiters {
+		l.SetRate(r)
+	}
+}
+
+// Quota 返回剩余最少的那个, 没有一个实现QuotaReporter时返回零值
+func (m *Multi) Quota() Quota {
+	var q Quota
+	found := false
+	for _, l := range m.limiters {
+		qr, ok := l.(QuotaReporter)
+		if !ok {
+			continue
+		}
+		cur := qr.Quota()
+		if !found || cur.Remaining < q.Remaining || (cur.Remaining == q.Remaining && cur.Reset > q.Reset) {
+			q, found = cur, true
+		}
+	}
+	return q
+}
@@ -0,0 +1,46 @@
+package second
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_Multi(t *testing.T) {
+	clk := NewFakeClock(epoch)
+	perSecond := NewTokenBucket(Rate{Limit: 2, Per: time.Second, Burst: 2}, WithClock(clk))
+	perMinute := NewFixedWindow(Rate{Limit: 3, Per: time.Minute}, WithClock(clk))
+	m := NewMulti([]Limiter{perSecond, perMinute}, WithClock(clk))
+
+	if !m.Allow() || !m.Allow() || m.Allow() {
+		t.Fatal("per second limit should apply")
+	}
+	// 被秒级拒绝的请求不能占分钟级的名额
+	if q := perMinute.Quota(); q.Remaining != 1 {
+		t.Fatalf("minute quota %+v", q)
+	}
+
+	clk.Advance(time.Second)
+	if !m.Allow() || m.Allow() {
+		t.Fatal("per minute limit should apply")
+	}
+	if q := m.Quota(); q.Remaining != 0 || q.Limit != 3 {
+		t.Fatalf("quota %+v", q)
+	}
+
+	// 秒级的令牌也要还回去
+	if q := perSecond.Quota(); q.Remaining != 1 {
+		t.Fatalf("second quota %+v", q)
+	}
+
+	// Wait停在分钟窗口的timer上, 推到下一分钟才放行
+	done := make(chan error, 1)
+	go func() { done <- m.Wait(context.Background()) }()
+	clk.BlockUntil(1)
+	if d := clk.AdvanceNext(); clk.Now().Sub(epoch) != time.Minute {
+		t.Fatalf("waited %v until %v, want the next minute", d, clk.Now().Sub(epoch))
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
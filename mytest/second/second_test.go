@@ -2,28 +2,35 @@ package second
 
 import (
 	"context"
-	"fmt"
 	"testing"
 	"time"
-
-	"go.uber.org/ratelimit"
-	"golang.org/x/time/rate"
 )
 
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 原来是 ratelimit.New(10, ratelimit.WithSlack(3)), 第一次Take之后sleep 0.5秒再打印间隔
+// 换成FakeClock之后可以精确断言: 空闲0.5秒最多攒下slack(3)个额度, 加上本来就该放行的1个
+// 之后恢复成每100ms一个
 func Test_Example(t *testing.T) {
-	rl := ratelimit.New(10, ratelimit.WithSlack(3)) // per second
+	clk := NewFakeClock(epoch)
+	rl := NewUber(Rate{Limit: 10, Per: time.Second, Burst: 3}, WithClock(clk))
 
-	prev := time.Now()
+	want := []time.Duration{
+		0, // 第一次
+		0, 0, 0, 0, // sleep 0.5秒攒下的额度
+		100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond,
+		100 * time.Millisecond, 100 * time.Millisecond,
+	}
 	for i := 0; i < 10; i++ {
-		now := rl.Take()
+		r := rl.Reserve()
+		if r.Delay != want[i] {
+			t.Fatalf("counter %d: delay %v, want %v", i, r.Delay, want[i])
+		}
+		clk.Advance(r.Delay)
 		if i == 0 {
-			time.Sleep(time.Second / 2.0)
+			clk.Advance(time.Second / 2.0)
 		}
-		fmt.Println(i, now.Sub(prev))
-		fmt.Printf("counter: %v, %v \n", i, time.Now().Format(time.RFC3339Nano))
-		prev = now
 	}
-
 }
 
 func Test_Example3(t *testing.T) {
@@ -31,19 +38,33 @@ func Test_Example3(t *testing.T) {
 }
 
 // 範例程式碼：https://www.jianshu.com/p/1ecb513f7632
+// rate.Every(500ms), 桶容量3, sleep一秒后桶是满的
+// 所以前3个马上通过, 之后每500ms一个
 func Test_Example2(t *testing.T) {
-	counter := 0
-	ctx := context.Background()
+	clk := NewFakeClock(epoch)
+	limiter := NewXRate(Rate{Limit: 2, Per: time.Second, Burst: 3}, WithClock(clk))
+	clk.Advance(time.Second)
 
-	// 每 200 毫秒會放一次 token 到桶子（每秒會放 5 個 token 到桶子），bucket 最多容納 1 個 token
-	limit := rate.Every(time.Millisecond * 500)
-	limiter := rate.NewLimiter(limit, 3)
-	time.Sleep(time.Second)
-	fmt.Println(limiter.Limit(), limiter.Burst()) // 5，1
+	start := clk.Now()
+	ctx := context.Background()
+	for counter := 1; counter <= 10; counter++ {
+		done := make(chan error, 1)
+		go func() { done <- limiter.Wait(ctx) }()
+		if counter > 3 {
+			// 桶空了, Wait在等timer, 推进时钟
+			clk.BlockUntil(1)
+			clk.Advance(500 * time.Millisecond)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
 
-	for i := 0; i < 10; i++ {
-		counter++
-		limiter.Wait(ctx)
-		fmt.Printf("counter: %v, %v \n", counter, time.Now().Format(time.RFC3339Nano))
+		want := time.Duration(0)
+		if counter > 3 {
+			want = time.Duration(counter-3) * 500 * time.Millisecond
+		}
+		if got := clk.Now().Sub(start); got != want {
+			t.Fatalf("counter %d at %v, want %v", counter, got, want)
+		}
 	}
 }
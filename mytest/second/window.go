@@ -0,0 +1,279 @@
+package second
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// FixedWindow 固定窗口, 窗口按Per对齐(和go-zero PeriodLimit的Align一样)
+// 每个窗口最多Limit个请求, 缺点是窗口边界两侧加起来可能有2倍的请求
+type FixedWindow struct {
+	mu    sync.Mutex
+	clock Clock
+	limit int
+	per   time.Duration
+	start time.Time
+	count int
+}
+
+// NewFixedWindow 创建固定窗口
+func NewFixedWindow(r Rate, opts ...Option) *FixedWindow {
+	checkPer(r)
+	return &FixedWindow{clock: buildOptions(opts).clock, limit: r.Limit, per: r.Per}
+}
+
+// roll 进入新窗口时计数清零
+func (f *FixedWindow) roll(now time.Time) {
+	if start := now.Truncate(f.per); !start.Equal(f.start) {
+		f.start, f.count = start, 0
+	}
+}
+
+func (f *FixedWindow) take(now time.Time) (bool, time.Duration) {
+	f.roll(now)
+	if f.count < f.limit {
+		f.count++
+		return true, 0
+	}
+	return false, f.start.Add(f.per).Sub(now)
+}
+
+func (f *FixedWindow) Allow() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ok, _ := f.take(f.clock.Now())
+	return ok
+}
+
+func (f *FixedWindow) Reserve() Reservation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ok, delay := f.take(f.clock.Now())
+	start := f.start
+	return Reservation{OK: ok, Delay: delay, cancel: func() {
+		f.mu.Lock()
+		// 窗口已经过去了就不用还了
+		if f.start.Equal(start) && f.count > 0 {
+			f.count--
+		}
+		f.mu.Unlock()
+	}}
+}
+
+func (f *FixedWindow) Wait(ctx context.Context) error {
+	return waitReserve(ctx, f, f.clock)
+}
+
+func (f *FixedWindow) Quota() Quota {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.clock.Now()
+	f.roll(now)
+	q := Quota{Limit: f.limit, Remaining: max(f.limit-f.count, 0)}
+	if f.count > 0 {
+		q.Reset = f.start.Add(f.per).Sub(now)
+	}
+	return q
+}
+
+func (f *FixedWindow) SetRate(r Rate) {
+	checkPer(r)
+	f.mu.Lock()
+	f.limit, f.per = r.Limit, r.Per
+	f.mu.Unlock()
+}
+
+// SlidingLog 滑动日志, 记录最近Per时间内每个请求的时间
+// 最精确, 但是内存和Limit成正比
+type SlidingLog struct {
+	mu    sync.Mutex
+	clock Clock
+	limit int
+	per   time.Duration
+	log   []time.Time // 按时间从小到大
+}
+
+// NewSlidingLog 创建滑动日志
+func NewSlidingLog(r Rate, opts ...Option) *SlidingLog {
+	checkPer(r)
+	return &SlidingLog{clock: buildOptions(opts).clock, limit: r.Limit, per: r.Per}
+}
+
+// expire 删掉已经滑出窗口的请求
+func (s *SlidingLog) expire(now time.Time) {
+	expired := 0
+	for expired < len(s.log) && !s.log[expired].After(now.Add(-s.per)) {
+		expired++
+	}
+	s.log = s.log[expired:]
+}
+
+func (s *SlidingLog) take(now time.Time) (bool, time.Duration) {
+	s.expire(now)
+	if len(s.log) < s.limit {
+		s.log = append(s.log, now)
+		return true, 0
+	}
+	if s.limit <= 0 {
+		return false, s.per
+	}
+	// 等到足够多的旧请求滑出窗口
+	return false, s.log[len(s.log)-s.limit].Add(s.per).Sub(now)
+}
+
+func (s *SlidingLog) Allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok, _ := s.take(s.clock.Now())
+	return ok
+}
+
+func (s *SlidingLog) Reserve() Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	ok, delay := s.take(now)
+	return Reservation{OK: ok, Delay: delay, cancel: func() {
+		s.mu.Lock()
+		for i := len(s.log) - 1; i >= 0; i-- {
+			if s.log[i].Equal(now) {
+				s.log = append(s.log[:i], s.log[i+1:]...)
+				break
+			}
+		}
+		s.mu.Unlock()
+	}}
+}
+
+func (s *SlidingLog) Wait(ctx context.Context) error {
+	return waitReserve(ctx, s, s.clock)
+}
+
+func (s *SlidingLog) Quota() Quota {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.expire(now)
+	q := Quota{Limit: s.limit, Remaining: max(s.limit-len(s.log), 0)}
+	if len(s.log) > 0 {
+		// 最后一个请求滑出窗口时额度就满了
+		q.Reset = s.log[len(s.log)-1].Add(s.per).Sub(now)
+	}
+	return q
+}
+
+func (s *SlidingLog) SetRate(r Rate) {
+	checkPer(r)
+	s.mu.Lock()
+	s.limit, s.per = r.Limit, r.Per
+	s.mu.Unlock()
+}
+
+// SlidingWindow 滑动窗口计数
+// 用上一个窗口的计数按剩余比例加权来估计滑动窗口里的请求数:
+// estimate = prev * (1 - elapsed/per) + curr
+type SlidingWindow struct {
+	mu    sync.Mutex
+	clock Clock
+	limit int
+	per   time.Duration
+	start time.Time
+	prev  int
+	curr  int
+}
+
+// NewSlidingWindow 创建滑动窗口计数
+func NewSlidingWindow(r Rate, opts ...Option) *SlidingWindow {
+	checkPer(r)
+	return &SlidingWindow{clock: buildOptions(opts).clock, limit: r.Limit, per: r.Per}
+}
+
+// roll 进入下一个窗口时当前计数变成prev, 隔了不止一个窗口就都清零
+func (s *SlidingWindow) roll(now time.Time) {
+	start := now.Truncate(s.per)
+	switch {
+	case start.Equal(s.start):
+	case start.Equal(s.start.Add(s.per)):
+		s.prev, s.curr = s.curr, 0
+		s.start = start
+	default:
+		s.prev, s.curr = 0, 0
+		s.start = start
+	}
+}
+
+func (s *SlidingWindow) take(now time.Time) (bool, time.Duration) {
+	s.roll(now)
+	elapsed := now.Sub(s.start)
+	weight := 1 - float64(elapsed)/float64(s.per)
+	if float64(s.prev)*weight+float64(s.curr)+1 <= float64(s.limit) {
+		s.curr++
+		return true, 0
+	}
+
+	end := s.start.Add(s.per).Sub(now)
+	if s.prev == 0 || s.curr+1 > s.limit {
+		return false, end
+	}
+	// prev的权重降到 (limit-curr-1)/prev 时就能放行
+	need := 1 - float64(s.limit-s.curr-1)/float64(s.prev)
+	delay := time.Duration(need*float64(s.per)) - elapsed
+	if delay <= 0 || delay > end {
+		delay = end
+	}
+	return false, delay
+}
+
+func (s *SlidingWindow) Allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok, _ := s.take(s.clock.Now())
+	return ok
+}
+
+func (s *SlidingWindow) Reserve() Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok, delay := s.take(s.clock.Now())
+	start := s.start
+	return Reservation{OK: ok, Delay: delay, cancel: func() {
+		s.mu.Lock()
+		if s.start.Equal(start) && s.curr > 0 {
+			s.curr--
+		}
+		s.mu.Unlock()
+	}}
+}
+
+func (s *SlidingWindow) Wait(ctx context.Context) error {
+	return waitReserve(ctx, s, s.clock)
+}
+
+func (s *SlidingWindow) Quota() Quota {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.roll(now)
+	weight := 1 - float64(now.Sub(s.start))/float64(s.per)
+	used := int(math.Ceil(float64(s.prev)*weight)) + s.curr
+	q := Quota{Limit: s.limit, Remaining: max(s.limit-used, 0)}
+	switch {
+	case s.curr > 0:
+		q.Reset = s.start.Add(2 * s.per).Sub(now)
+	case s.prev > 0:
+		q.Reset = s.start.Add(s.per).Sub(now)
+	}
+	return q
+}
+
+func (s *SlidingWindow) SetRate(r Rate) {
+	checkPer(r)
+	s.mu.Lock()
+	s.limit, s.per = r.Limit, r.Per
+	s.mu.Unlock()
+}
@@ -0,0 +1,227 @@
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// 在 x/sync/semaphore 的基础上扩展, 见 read-source-code/go/x_sync_semaphore.md
+// 原版是严格的先进先出: 队头是一个大请求时, 后面的小请求即使有余量也要等(队头阻塞)
+
+// Mode 等待者的唤醒策略
+type Mode int
+
+const (
+	// FIFO 和x/sync/semaphore一样, 严格按顺序唤醒, 大请求不会饿死
+	FIFO Mode = iota
+	// SkipAhead 队头的余量不够时, 允许后面余量够的请求先拿
+	// 队头等待超过StarvationTimeout后退化成FIFO, 避免大请求饿死
+	SkipAhead
+)
+
+const defaultStarvationTimeout = 100 * time.Millisecond
+
+type waiter struct {
+	n     int64
+	since time.Time
+	ready chan struct{} // 拿到信号量后关闭
+}
+
+// Option 定制Weighted
+type Option func(s *Weighted)
+
+// WithMode 设置唤醒策略, 默认FIFO
+func WithMode(m Mode) Option {
+	return func(s *Weighted) {
+		s.mode = m
+	}
+}
+
+// WithStarvationTimeout 设置SkipAhead模式下队头最多被插队多久
+func WithStarvationTimeout(d time.Duration) Option {
+	return func(s *Weighted) {
+		s.starvation = d
+	}
+}
+
+// Weighted 带权重的信号量, 支持运行时调整容量和查看状态
+type Weighted struct {
+	size    int64
+	cur     int64
+	mu      sync.Mutex
+	waiters list.List
+
+	mode       Mode
+	starvation time.Duration
+	now        func() time.Time
+}
+
+// NewWeighted 创建容量为n的信号量
+func NewWeighted(n int64, opts ...Option) *Weighted {
+	s := &Weighted{
+		size:       n,
+		starvation: defaultStarvationTimeout,
+		now:        time.Now,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Acquire 拿n个权重, 阻塞到拿到或者ctx结束
+// 失败时返回ctx.Err(), 信号量不变
+// 和原版不同, n大于容量时也会排队, 因为之后可能会Resize扩容
+func (s *Weighted) Acquire(ctx context.Context, n int64) error {
+	done := ctx.Done()
+
+	s.mu.Lock()
+	select {
+	case <-done:
+		s.mu.Unlock()
+		return ctx.Err()
+	default:
+	}
+	if s.canAcquireLocked(n) {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(&waiter{n: n, since: s.now(), ready: ready})
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// 被取消之后才拿到, 当作没拿到, 还回去
+			s.cur -= n
+			s.notifyWaiters()
+		default:
+			s.waiters.Remove(elem)
+			// 自己走了, 后面的人可能可以拿了
+			s.notifyWaiters()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+
+	case <-ready:
+		select {
+		case <-done:
+			s.Release(n)
+			return ctx.Err()
+		default:
+		}
+		return nil
+	}
+}
+
+// TryAcquire 不阻塞地拿n个权重, 返回是否成功
+func (s *Weighted) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	success := s.canAcquireLocked(n)
+	if success {
+		s.cur += n
+	}
+	s.mu.Unlock()
+	return success
+}
+
+// TryAcquireFor 最多等timeout, 返回是否拿到
+func (s *Weighted) TryAcquireFor(n int64, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Acquire(ctx, n) == nil
+}
+
+// Release 还回n个权重
+func (s *Weighted) Release(n int64) {
+	s.mu.Lock()
+	s.cur -= n
+	if s.cur < 0 {
+		s.mu.Unlock()
+		panic("semaphore: released more than held")
+	}
+	s.notifyWaiters()
+	s.mu.Unlock()
+}
+
+// Resize 运行时修改容量
+// 缩容时已经拿到的权重不受影响, 要等它们还回来之后新的请求才能拿到
+func (s *Weighted) Resize(n int64) {
+	s.mu.Lock()
+	s.size = n
+	s.notifyWaiters()
+	s.mu.Unlock()
+}
+
+// Stats 信号量的状态快照
+type Stats struct {
+	Size          int64
+	Held          int64
+	Waiting       int
+	WaitingWeight int64
+	LongestWait   time.Duration // 等得最久的等待者已经等了多久
+}
+
+// Stats 返回当前的状态
+func (s *Weighted) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Stats{Size: s.size, Held: s.cur, Waiting: s.waiters.Len()}
+	now := s.now()
+	for e := s.waiters.Front(); e != nil; e = e.Next() {
+		w := e.Value.(*waiter)
+		st.WaitingWeight += w.n
+		if d := now.Sub(w.since); d > st.LongestWait {
+			st.LongestWait = d
+		}
+	}
+	return st
+}
+
+func (s *Weighted) canAcquireLocked(n int64) bool {
+	if s.size-s.cur < n {
+		return false
+	}
+	if s.waiters.Len() == 0 {
+		return true
+	}
+	return s.mode == SkipAhead && !s.starvingLocked()
+}
+
+// starvingLocked 队头是否已经等太久了, 不能再被插队
+func (s *Weighted) starvingLocked() bool {
+	front := s.waiters.Front()
+	if front == nil {
+		return false
+	}
+	return s.now().Sub(front.Value.(*waiter).since) >= s.starvation
+}
+
+func (s *Weighted) notifyWaiters() {
+	skip := s.mode == SkipAhead && !s.starvingLocked()
+	for next := s.waiters.Front(); next != nil; {
+		w := next.Value.(*waiter)
+		if s.size-s.cur < w.n {
+			if !skip {
+				// 余量不够队头, 后面的都等着, 这样大请求不会饿死
+				break
+			}
+			next = next.Next()
+			continue
+		}
+
+		s.cur += w.n
+		cur := next
+		next = next.Next()
+		s.waiters.Remove(cur)
+		close(w.ready)
+	}
+}
@@ -0,0 +1,138 @@
+package semaphore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 队头阻塞: 容量10, 已经用了5, 队头要10, 后面要1
+func headOfLine(t *testing.T, s *Weighted) (big chan error) {
+	t.Helper()
+	if !s.TryAcquire(5) {
+		t.Fatal("acquire 5")
+	}
+
+	big = make(chan error, 1)
+	go func() { big <- s.Acquire(context.Background(), 10) }()
+	waitFor(t, func() bool { return s.Stats().Waiting == 1 })
+	return big
+}
+
+func Test_FIFO_HeadOfLineBlocking(t *testing.T) {
+	s := NewWeighted(10)
+	big := headOfLine(t, s)
+
+	// 明明还有5的余量, 但是被队头卡住了
+	if s.TryAcquireFor(1, 20*time.Millisecond) {
+		t.Fatal("FIFO should block behind the head waiter")
+	}
+
+	s.Release(5)
+	if err := <-big; err != nil {
+		t.Fatal(err)
+	}
+	s.Release(10)
+}
+
+func Test_SkipAhead(t *testing.T) {
+	s := NewWeighted(10, WithMode(SkipAhead), WithStarvationTimeout(time.Hour))
+	big := headOfLine(t, s)
+
+	if !s.TryAcquireFor(1, 20*time.Millisecond) {
+		t.Fatal("SkipAhead should let the small request through")
+	}
+
+	// 小请求在队头后面排队, 余量够的时候也能先被唤醒
+	if !s.TryAcquire(4) {
+		t.Fatal("acquire 4")
+	}
+	small := make(chan error, 1)
+	go func() { small <- s.Acquire(context.Background(), 2) }()
+	waitFor(t, func() bool { return s.Stats().Waiting == 2 })
+	s.Release(4)
+	if err := <-small; err != nil {
+		t.Fatal(err)
+	}
+
+	s.Release(5 + 1 + 2)
+	if err := <-big; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SkipAhead_Starvation(t *testing.T) {
+	s := NewWeighted(10, WithMode(SkipAhead), WithStarvationTimeout(10*time.Millisecond))
+	big := headOfLine(t, s)
+
+	time.Sleep(20 * time.Millisecond)
+	// 队头等太久了, 不允许再插队
+	if s.TryAcquire(1) {
+		t.Fatal("starving head waiter should stop skipping")
+	}
+
+	s.Release(5)
+	if err := <-big; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Resize(t *testing.T) {
+	s := NewWeighted(2)
+	if !s.TryAcquire(2) {
+		t.Fatal("acquire 2")
+	}
+
+	got := make(chan error, 1)
+	go func() { got <- s.Acquire(context.Background(), 3) }()
+	waitFor(t, func() bool { return s.Stats().Waiting == 1 })
+
+	s.Resize(5)
+	if err := <-got; err != nil {
+		t.Fatal(err)
+	}
+
+	s.Resize(1)
+	s.Release(5)
+	if s.TryAcquire(2) {
+		t.Fatal("acquire more than the new size")
+	}
+	if !s.TryAcquire(1) {
+		t.Fatal("acquire 1 after shrink")
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	s := NewWeighted(3)
+	s.TryAcquire(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Acquire(ctx, 3) }()
+	waitFor(t, func() bool { return s.Stats().Waiting == 1 })
+	time.Sleep(10 * time.Millisecond)
+
+	st := s.Stats()
+	if st.Size != 3 || st.Held != 2 || st.WaitingWeight != 3 || st.LongestWait < 10*time.Millisecond {
+		t.Fatalf("stats: %+v", st)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("want Canceled, got %v", err)
+	}
+	if st := s.Stats(); st.Waiting != 0 || st.Held != 2 {
+		t.Fatalf("stats after cancel: %+v", st)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
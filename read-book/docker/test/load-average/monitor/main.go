@@ -0,0 +1,72 @@
+package main
+
+// 观察load-average.go推高负载的过程, 代替手动跑uptime和top
+//
+//	go run ../load-average.go -n 4 &
+//	go run . -interval 1s -csv load.csv
+//
+// cpu_some这些列是两次采样之间PSI total的增量, 单位是百分比, throttled是被限流的period占比
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/loadmon"
+)
+
+func main() {
+	interval := flag.Duration("interval", time.Second, "sample interval")
+	count := flag.Int("count", 0, "number of samples, 0 means until interrupted")
+	csvPath := flag.String("csv", "", "also write rows to this CSV file")
+	proc := flag.String("proc", "/proc", "proc mount point")
+	root := flag.String("cgroup", "", "cgroup directory, default the cgroup of this process, none to skip cpu.stat")
+	repeat := flag.Int("header", 20, "repeat the table header every n rows")
+	flag.Parse()
+
+	writers := []loadmon.Writer{loadmon.NewTableWriter(os.Stdout, *repeat)}
+	if *csvPath != "" {
+		f, err := os.Create(*csvPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		writers = append(writers, loadmon.NewCSVWriter(f))
+	}
+
+	opts := []loadmon.Option{loadmon.WithProc(*proc)}
+	if *root != "none" {
+		if cg, err := openCgroup(*root); err == nil {
+			opts = append(opts, loadmon.WithCgroup(cg))
+		} else {
+			fmt.Fprintf(os.Stderr, "cgroup: %s, cpu.stat columns will be 0\n", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+
+	m := loadmon.NewMonitor(*interval, writers, opts...)
+	if err := m.Run(stop, *count); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// openCgroup root为空时用当前进程所在的cgroup, 在容器外跑时看的也是自己那个cgroup而不是根
+func openCgroup(root string) (*cgroup.Cgroup, error) {
+	if root == "" {
+		return cgroup.Self()
+	}
+	return cgroup.Open(root)
+}
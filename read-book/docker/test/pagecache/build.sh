@@ -1 +1 @@
-docker build -t go-file-signal .
\ No newline at end of file
+docker build -t go-file-signal -f Dockerfile ../../../..
\ No newline at end of file
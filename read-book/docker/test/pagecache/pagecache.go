@@ -1,82 +1,364 @@
+//go:build linux && (amd64 || arm64)
+
+// SYS_FADVISE64只有64位的平台有, 32位的是fadvise64_64, 参数也不一样
+
 package main
 
+// 看page cache的变化, 原来是写100MB的文件, 读一遍, 再调free -h用眼睛看buff/cache涨了多少
+// free看的是整台机器, 在容器里也不准, 这里换成:
+//   - 每个文件有多少页在page cache里, mmap之后用mincore查
+//   - 每个阶段前后当前进程所在cgroup的memory.stat里file/active_file/inactive_file的变化
+//   - 用fadvise(DONTNEED)把文件的缓存清掉
+// 结果用JSON输出, 方便脚本处理
+//
+// 用法:
+//
+//	./main -size 100 -phases write,read,evict,read
+//	./main -phases stat -files /app/largefile.bin,/usr/bin/bash
+//	./main -grow   # 跑完之后等grow命令, 然后一直分配内存, 每秒输出一次, 看内存不够时缓存怎么被回收
+//	kill -USR1 <pid>                    # 触发grow
+//	./main -grow -sock /tmp/pagecache.sock
+//	echo grow | nc -U /tmp/pagecache.sock
+
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"os/signal"
-	"runtime"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"unsafe"
+
+	"github.com/guonaihong/question/mytest/cgroup"
+	"github.com/guonaihong/question/mytest/proc"
 )
 
+const (
+	mb = 1024 * 1024
+
+	// fadvise的advice, syscall包里没有
+	fadvDontNeed = 4
+)
+
+// FileStat 一个文件在page cache里的情况
+type FileStat struct {
+	Path          string  `json:"path"`
+	Size          int64   `json:"size"`
+	Pages         int64   `json:"pages"`
+	Resident      int64   `json:"resident_pages"`
+	ResidentBytes int64   `json:"resident_bytes"`
+	Percent       float64 `json:"resident_percent"`
+}
+
+// CacheStat cgroup memory.stat里和page cache有关的几项, 单位是字节
+type CacheStat struct {
+	File         int64 `json:"file"`
+	ActiveFile   int64 `json:"active_file"`
+	InactiveFile int64 `json:"inactive_file"`
+}
+
+func (c CacheStat) sub(o CacheStat) CacheStat {
+	return CacheStat{
+		File:         c.File - o.File,
+		ActiveFile:   c.ActiveFile - o.ActiveFile,
+		InactiveFile: c.InactiveFile - o.InactiveFile,
+	}
+}
+
+// Phase 一个阶段的结果
+type Phase struct {
+	Name     string     `json:"name"`
+	Duration string     `json:"duration"`
+	Files    []FileStat `json:"files"`
+	Before   *CacheStat `json:"cgroup_before,omitempty"`
+	After    *CacheStat `json:"cgroup_after,omitempty"`
+	Delta    *CacheStat `json:"cgroup_delta,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Report 整个输出
+type Report struct {
+	PageSize int     `json:"page_size"`
+	Cgroup   string  `json:"cgroup,omitempty"`
+	Phases   []Phase `json:"phases"`
+}
+
+// Sample -grow时每秒输出一行
+type Sample struct {
+	Time     string     `json:"time"`
+	AllocMiB int        `json:"alloc_mib"`
+	Files    []FileStat `json:"files"`
+	Cgroup   *CacheStat `json:"cgroup,omitempty"`
+}
+
 func main() {
-	// Create a 100MB file
-	fd, err := os.Create("./largefile.bin")
+	file := flag.String("file", "./largefile.bin", "file written by the write phase")
+	size := flag.Int("size", 100, "size of the file in MB")
+	files := flag.String("files", "", "comma separated files to report, default -file")
+	phases := flag.String("phases", "write,read,evict", "comma separated phases: write, read, evict, stat")
+	root := flag.String("cgroup", "", "cgroup directory, default the cgroup of this process")
+	grow := flag.Bool("grow", false, "after the phases wait for the grow command, then allocate 10MB per second")
+	sock := flag.String("sock", "", "unix socket for the grow command, SIGUSR1 always works")
+	flag.Parse()
+
+	paths := []string{*file}
+	if *files != "" {
+		paths = strings.Split(*files, ",")
+	}
+	cg, cgErr := openCgroup(*root)
+	if cgErr != nil {
+		fmt.Fprintln(os.Stderr, "cgroup:", cgErr)
+	}
+
+	report := Report{PageSize: os.Getpagesize()}
+	if cg != nil {
+		report.Cgroup = fmt.Sprintf("v%d", cg.Version())
+	}
+	for _, name := range strings.Split(*phases, ",") {
+		report.Phases = append(report.Phases, runPhase(name, *file, *size, paths, cg))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *grow {
+		growMemory(paths, cg, *sock)
+	}
+}
+
+// openCgroup root为空时用当前进程所在的cgroup
+func openCgroup(root string) (*cgroup.Cgroup, error) {
+	if root == "" {
+		return cgroup.Self()
+	}
+	return cgroup.Open(root)
+}
+
+func runPhase(name, file string, size int, paths []string, cg *cgroup.Cgroup) Phase {
+	p := Phase{Name: name}
+	before, beforeErr := readCacheStat(cg)
+
+	start := time.Now()
+	var err error
+	switch name {
+	case "write":
+		err = writeFile(file, size)
+	case "read":
+		err = readFiles(paths)
+	case "evict":
+		err = evictFiles(paths)
+	case "stat":
+	default:
+		err = fmt.Errorf("unknown phase %q", name)
+	}
+	p.Duration = time.Since(start).String()
 	if err != nil {
-		fmt.Println("Error creating file:", err)
-		return
+		p.Error = err.Error()
 	}
-	defer fd.Close()
 
-	data := make([]byte, 1024*1024)
-	for i := 0; i < 100; i++ {
-		fd.Write(data)
+	p.Files = statFiles(paths)
+	if after, afterErr := readCacheStat(cg); beforeErr == nil && afterErr == nil {
+		delta := after.sub(before)
+		p.Before, p.After, p.Delta = &before, &after, &delta
 	}
-	fmt.Println("Created a 100MB file")
+	return p
+}
 
-	// Read the file
-	fd2, err := os.OpenFile("largefile.bin", os.O_RDONLY, 0644)
+func writeFile(path string, size int) error {
+	f, err := os.Create(path)
 	if err != nil {
-		fmt.Println("Error reading file:", err)
-		return
+		return err
 	}
-	defer fd2.Close()
-	for {
-		_, err = fd2.Read(data)
-		if err == io.EOF {
-			break
+	data := make([]byte, mb)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for i := 0; i < size; i++ {
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func readFiles(paths []string) error {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, f)
+		f.Close()
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	fmt.Println("Read the 100MB file")
+func evictFiles(paths []string) error {
+	for _, path := range paths {
+		if err := evict(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Call the free command
-	out, err := exec.Command("free", "-h").Output()
+// evict 脏页不会被DONTNEED丢掉, 先fsync
+func evict(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
-		fmt.Println("Error running free command:", err)
-		return
+		// 只读的文件没有脏页, 不用fsync
+		if f, err = os.Open(path); err != nil {
+			return err
+		}
+	} else if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	defer f.Close()
+
+	// offset和len都是0表示整个文件
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontNeed, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("fadvise %s: %w", path, errno)
+	}
+	return nil
+}
+
+func statFiles(paths []string) []FileStat {
+	stats := make([]FileStat, 0, len(paths))
+	for _, path := range paths {
+		s, err := residentPages(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		stats = append(stats, s)
 	}
-	fmt.Println("Memory usage:", string(out))
+	return stats
+}
 
-	// Set up a channel to listen for SIGUSR1 signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGUSR1)
+// residentPages mmap整个文件, mincore返回每页一个字节, 最低位是1表示在内存里
+// mmap本身不会把文件读进来, 不访问映射的内存就不会影响结果
+func residentPages(path string) (FileStat, error) {
+	s := FileStat{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
 
-	// Wait for the signal
-	fmt.Println("Waiting for SIGUSR1 signal...")
-	<-sigChan
+	fi, err := f.Stat()
+	if err != nil {
+		return s, err
+	}
+	s.Size = fi.Size()
+	if s.Size == 0 {
+		return s, nil
+	}
 
-	var memStats runtime.MemStats
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(s.Size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return s, fmt.Errorf("mmap %s: %w", path, err)
+	}
+	defer syscall.Munmap(data)
 
-	var all = map[int][]byte{}
-	for j := 0; ; j++ {
-		// Allocate a large block of memory
-		bigSlice := make([]byte, 1024*1024*10) // 1 MB
+	pageSize := int64(os.Getpagesize())
+	s.Pages = (s.Size + pageSize - 1) / pageSize
+	vec := make([]byte, s.Pages)
+	_, _, errno := syscall.Syscall(syscall.SYS_MINCORE,
+		uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&vec[0])))
+	if errno != 0 {
+		return s, fmt.Errorf("mincore %s: %w", path, errno)
+	}
+
+	for _, v := range vec {
+		if v&1 == 1 {
+			s.Resident++
+		}
+	}
+	s.ResidentBytes = s.Resident * pageSize
+	if s.ResidentBytes > s.Size {
+		s.ResidentBytes = s.Size
+	}
+	s.Percent = float64(s.Resident) * 100 / float64(s.Pages)
+	return s, nil
+}
+
+// readCacheStat cgroup包已经处理了v1和v2的区别, active_file只能从原始的memory.stat里拿
+func readCacheStat(cg *cgroup.Cgroup) (CacheStat, error) {
+	var c CacheStat
+	if cg == nil {
+		return c, cgroup.ErrNotFound
+	}
+	m, err := cg.Memory()
+	if err != nil {
+		return c, err
+	}
+
+	c.File = int64(m.Cache)
+	c.InactiveFile = int64(m.InactiveFile)
+	// v1有total_开头的就用total_的, 包括子cgroup
+	if v, ok := m.Stat["total_active_file"]; ok {
+		c.ActiveFile = int64(v)
+	} else {
+		c.ActiveFile = int64(m.Stat["active_file"])
+	}
+	return c, nil
+}
+
+// growMemory 原来的实验: 收到grow命令后每秒多占10MB, 看page cache什么时候被回收
+// grow命令注册在proc.Controller上, SIGUSR1和socket都能触发, SIGTERM/SIGINT直接退出
+func growMemory(paths []string, cg *cgroup.Cgroup, sock string) {
+	start := make(chan struct{})
+	var once sync.Once
+	ctl := proc.New()
+	ctl.Register("grow", func([]string) (string, error) {
+		once.Do(func() { close(start) })
+		return "growing", nil
+	})
+	ctl.Handle(syscall.SIGUSR1, "grow")
+	if sock != "" {
+		if err := ctl.Listen(sock); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	ctl.Start()
+	fmt.Fprintln(os.Stderr, "Waiting for the grow command (SIGUSR1 or the socket)...")
+	<-start
+
+	enc := json.NewEncoder(os.Stdout)
+	var all [][]byte
+	for {
+		bigSlice := make([]byte, 10*mb)
 		for i := range bigSlice {
 			bigSlice[i] = 1
 		}
-		all[j] = bigSlice
-
-		// Print memory statistics
-		runtime.ReadMemStats(&memStats)
-		fmt.Printf("Alloc = %v MiB\n", memStats.Alloc/1024/1024)
-		fmt.Printf("TotalAlloc = %v MiB\n", memStats.TotalAlloc/1024/1024)
-		fmt.Printf("Sys = %v MiB\n", memStats.Sys/1024/1024)
-		fmt.Printf("NumGC = %v\n", memStats.NumGC)
+		all = append(all, bigSlice)
 
-		// Sleep for a while before allocating more memory
-		time.Sleep(1 * time.Second)
+		sample := Sample{
+			Time:     time.Now().Format(time.RFC3339),
+			AllocMiB: len(all) * 10,
+			Files:    statFiles(paths),
+		}
+		if c, err := readCacheStat(cg); err == nil {
+			sample.Cgroup = &c
+		}
+		if err := enc.Encode(sample); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		time.Sleep(time.Second)
 	}
 }
@@ -0,0 +1 @@
+docker build -t go-stress .
\ No newline at end of file
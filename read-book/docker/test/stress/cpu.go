@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cpuLoad 和loop.go一样开n个go程死循环, 但是每个tick里只忙util%的时间
+// util是100时就是原来的死循环, 看cpu quota怎么限流
+type cpuLoad struct {
+	workers int
+	util    float64
+
+	level atomicFloat
+	busy  int64 // 纳秒, 所有worker加起来
+	rate  rate
+}
+
+// CPUStats cpu的指标
+type CPUStats struct {
+	Workers    int     `json:"workers"`
+	TargetUtil float64 `json:"target_util"`
+	// BusySeconds worker自己认为忙了多久, 被限流时比CPUSeconds大
+	BusySeconds float64 `json:"busy_s"`
+	// CPUSeconds getrusage的user+sys
+	CPUSeconds float64 `json:"cpu_s"`
+	// CPUPercent 最近一个interval用了多少核, 200表示两个核
+	CPUPercent float64 `json:"cpu_percent"`
+}
+
+func newCPULoad(fs *flag.FlagSet) *cpuLoad {
+	c := &cpuLoad{}
+	fs.IntVar(&c.workers, "workers", runtime.NumCPU(), "number of spinning goroutines")
+	fs.Float64Var(&c.util, "util", 100, "target utilisation of each worker in percent")
+	return c
+}
+
+func (c *cpuLoad) setLevel(level float64) {
+	c.level.store(level)
+}
+
+func (c *cpuLoad) start(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	wg.Add(c.workers)
+	for i := 0; i < c.workers; i++ {
+		go func() {
+			defer wg.Done()
+			c.worker(stop)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *cpuLoad) worker(stop <-chan struct{}) {
+	x := 1.0
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		start := time.Now()
+		busy := time.Duration(float64(tick) * c.util / 100 * c.level.load())
+		for time.Since(start) < busy {
+			// 算点东西, 不让编译器优化掉
+			for i := 0; i < 1000; i++ {
+				x = math.Sqrt(x + float64(i))
+			}
+		}
+		atomic.AddInt64(&c.busy, int64(time.Since(start)))
+		if rest := tick - time.Since(start); rest > 0 {
+			time.Sleep(rest)
+		}
+	}
+}
+
+func (c *cpuLoad) stats() interface{} {
+	cpu := cpuTime()
+	return CPUStats{
+		Workers:     c.workers,
+		TargetUtil:  math.Round(c.util*c.level.load()*100) / 100,
+		BusySeconds: math.Round(time.Duration(atomic.LoadInt64(&c.busy)).Seconds()*1000) / 1000,
+		CPUSeconds:  math.Round(cpu.Seconds()*1000) / 1000,
+		CPUPercent:  round2(c.rate.per(time.Now(), int64(cpu)) / float64(time.Second) * 100),
+	}
+}
+
+// cpuTime 进程用掉的CPU时间
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
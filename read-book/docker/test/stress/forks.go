@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// forkChild 子进程用的隐藏子命令
+const forkChild = "fork-child"
+
+// forkLoad 一直起子进程, 看pids.max的限制, 碰到限制时fork会返回EAGAIN
+// 子进程是自己再执行一遍, 活-hold这么久, 父进程退出时stdin关闭, 子进程跟着退出
+type forkLoad struct {
+	max  int
+	rate float64
+	hold time.Duration
+
+	level   atomicFloat
+	mu      sync.Mutex
+	running []*exec.Cmd
+	waiting sync.WaitGroup // 等子进程退出的go程
+	credit  float64
+	started int64
+	exited  int64
+	failed  int64
+	lastErr string
+}
+
+// ForkStats forks的指标
+type ForkStats struct {
+	Running   int    `json:"running"`
+	Target    int    `json:"target"`
+	Started   int64  `json:"started"`
+	Exited    int64  `json:"exited"`
+	Failed    int64  `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func newForkLoad(fs *flag.FlagSet) *forkLoad {
+	l := &forkLoad{}
+	fs.IntVar(&l.max, "max", 100, "maximum number of child processes, scaled by the ramp")
+	fs.Float64Var(&l.rate, "rate", 10, "children started per second")
+	fs.DurationVar(&l.hold, "hold", 0, "lifetime of each child, 0 means until stress exits")
+	return l
+}
+
+func (l *forkLoad) setLevel(level float64) {
+	l.level.store(level)
+}
+
+func (l *forkLoad) target() int {
+	return int(float64(l.max) * l.level.load())
+}
+
+func (l *forkLoad) start(stop <-chan struct{}) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			// 等子进程都退出了, 最后一行的exited才准
+			l.killAll()
+			l.waiting.Wait()
+			return
+		case <-ticker.C:
+			l.step()
+		}
+	}
+}
+
+func (l *forkLoad) step() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	target := l.target()
+	l.credit += l.rate * tick.Seconds()
+	for len(l.running) < target && l.credit >= 1 {
+		l.credit--
+		if err := l.spawnLocked(); err != nil {
+			l.failed++
+			l.lastErr = err.Error()
+			// 碰到限制了, 等下一个tick再试
+			break
+		}
+	}
+	if len(l.running) >= target {
+		l.credit = 0
+	}
+	// ramp往下走时先杀最新的
+	for len(l.running) > target {
+		cmd := l.running[len(l.running)-1]
+		l.running = l.running[:len(l.running)-1]
+		cmd.Process.Kill()
+	}
+}
+
+func (l *forkLoad) spawnLocked() error {
+	cmd := exec.Command(os.Args[0], forkChild, "-hold", l.hold.String())
+	if _, err := cmd.StdinPipe(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	l.started++
+	l.running = append(l.running, cmd)
+
+	l.waiting.Add(1)
+	go func() {
+		defer l.waiting.Done()
+		cmd.Wait()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.exited++
+		for i, c := range l.running {
+			if c == cmd {
+				l.running = append(l.running[:i], l.running[i+1:]...)
+				break
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *forkLoad) killAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, cmd := range l.running {
+		cmd.Process.Kill()
+	}
+}
+
+func (l *forkLoad) stats() interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ForkStats{
+		Running:   len(l.running),
+		Target:    l.target(),
+		Started:   l.started,
+		Exited:    l.exited,
+		Failed:    l.failed,
+		LastError: l.lastErr,
+	}
+}
+
+// runForkChild 子进程: 等到hold时间到了或者stdin关闭(父进程退出)
+func runForkChild(args []string) {
+	fs := flag.NewFlagSet(forkChild, flag.ExitOnError)
+	hold := fs.Duration("hold", 0, "")
+	fs.Parse(args)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		close(done)
+	}()
+	if *hold <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(*hold):
+	}
+}
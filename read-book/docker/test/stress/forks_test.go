@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain forkLoad执行os.Args[0], 测试时就是测试程序自己, 要能当子进程跑
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == forkChild {
+		runForkChild(os.Args[2:])
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// Test_forkLoad_step 每个tick按rate起子进程, 不超过max*level, level降下来时杀掉多的
+func Test_forkLoad_step(t *testing.T) {
+	l := &forkLoad{max: 4, rate: 20} // 每个tick 2个
+	defer l.killAll()
+
+	steps := []struct {
+		level   float64
+		running int
+	}{
+		{level: 1, running: 2},
+		{level: 1, running: 4},
+		{level: 1, running: 4},
+		{level: 0.5, running: 2},
+		{level: 0, running: 0},
+	}
+	for i, s := range steps {
+		l.setLevel(s.level)
+		l.step()
+		st := l.stats().(ForkStats)
+		if st.Running != s.running || st.Target != int(4*s.level) || st.Failed != 0 {
+			t.Fatalf("step %d: level %v stats %+v, want %d running", i, s.level, st, s.running)
+		}
+	}
+
+	// 杀掉的子进程退出后计数
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := l.stats().(ForkStats)
+		if st.Started == 4 && st.Exited == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
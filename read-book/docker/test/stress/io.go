@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// directAlign O_DIRECT要求buffer, offset和长度都按块对齐, 用4096就够了
+const directAlign = 4096
+
+// ioModes -mode能用的值, true表示写
+var ioModes = map[string]bool{"seqread": false, "seqwrite": true, "randread": false, "randwrite": true}
+
+// ioLoad 顺序或者随机读写一个文件, 和pagecache.go读写大文件类似, 可以加fsync和O_DIRECT
+// 每个tick里干活的时间占level, -rate不为0时每秒最多这么多MB
+type ioLoad struct {
+	file   string
+	size   int64 // MB
+	mode   string
+	bs     int
+	fsync  int
+	direct bool
+	rate   float64
+
+	level     atomicFloat
+	ops       int64
+	bytes     int64
+	syncs     int64
+	errors    int64
+	lastErr   atomic.Value
+	opsRate   rate
+	bytesRate rate
+}
+
+// IOStats io的指标
+type IOStats struct {
+	Mode      string  `json:"mode"`
+	Direct    bool    `json:"direct"`
+	Ops       int64   `json:"ops"`
+	Bytes     int64   `json:"bytes"`
+	Fsyncs    int64   `json:"fsyncs"`
+	IOPS      float64 `json:"iops"`
+	MBps      float64 `json:"mb_per_s"`
+	Errors    int64   `json:"errors"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+func newIOLoad(fs *flag.FlagSet) *ioLoad {
+	l := &ioLoad{}
+	fs.StringVar(&l.file, "file", "./stress.bin", "file to read or write")
+	fs.Int64Var(&l.size, "size", 100, "file size in MB")
+	fs.StringVar(&l.mode, "mode", "seqread", "seqread, seqwrite, randread or randwrite")
+	fs.IntVar(&l.bs, "bs", 1<<20, "block size in bytes")
+	fs.IntVar(&l.fsync, "fsync", 0, "fsync after every n writes, 0 means never")
+	fs.BoolVar(&l.direct, "direct", false, "open with O_DIRECT to bypass the page cache")
+	fs.Float64Var(&l.rate, "rate", 0, "throughput cap in MB per second, 0 means no cap")
+	return l
+}
+
+// validate 启动前检查参数, 不认识的mode不能悄悄当成seqread跑
+func (l *ioLoad) validate() error {
+	if _, ok := ioModes[l.mode]; !ok {
+		return fmt.Errorf("unknown -mode %q, want seqread, seqwrite, randread or randwrite", l.mode)
+	}
+	if l.bs <= 0 {
+		return fmt.Errorf("-bs %d must be positive", l.bs)
+	}
+	return nil
+}
+
+func (l *ioLoad) setLevel(level float64) {
+	l.level.store(level)
+}
+
+func (l *ioLoad) fail(err error) {
+	atomic.AddInt64(&l.errors, 1)
+	l.lastErr.Store(err.Error())
+}
+
+func (l *ioLoad) start(stop <-chan struct{}) {
+	f, err := l.open()
+	if err != nil {
+		l.fail(err)
+		return
+	}
+	defer f.Close()
+
+	buf := alignedBuffer(l.bs)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	blocks := l.size << 20 / int64(l.bs)
+	if blocks == 0 {
+		l.fail(fmt.Errorf("size %dMB is smaller than bs %d", l.size, l.bs))
+		return
+	}
+
+	write := ioModes[l.mode]
+	random := l.mode == "randread" || l.mode == "randwrite"
+	var block, writes int64
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		start := time.Now()
+		busy := time.Duration(float64(tick) * l.level.load())
+		budget := int64(-1) // 这个tick最多多少字节, -1表示不限
+		if l.rate > 0 {
+			budget = int64(l.rate * l.level.load() * tick.Seconds() * (1 << 20))
+		}
+		for time.Since(start) < busy && budget != 0 {
+			if random {
+				block = rand.Int63n(blocks)
+			}
+			off := block * int64(l.bs)
+			var n int
+			if write {
+				n, err = f.WriteAt(buf, off)
+				writes++
+				if err == nil && l.fsync > 0 && writes%int64(l.fsync) == 0 {
+					err = f.Sync()
+					atomic.AddInt64(&l.syncs, 1)
+				}
+			} else {
+				n, err = f.ReadAt(buf, off)
+			}
+			if err != nil {
+				l.fail(err)
+			}
+			atomic.AddInt64(&l.ops, 1)
+			atomic.AddInt64(&l.bytes, int64(n))
+			block = (block + 1) % blocks
+			if budget > 0 {
+				budget -= int64(n)
+				if budget < 0 {
+					budget = 0
+				}
+			}
+		}
+		if rest := tick - time.Since(start); rest > 0 {
+			time.Sleep(rest)
+		}
+	}
+}
+
+// open 读的时候文件要先存在, 不够大就先写满
+func (l *ioLoad) open() (*os.File, error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if l.direct {
+		flags |= syscall.O_DIRECT
+		if l.bs%directAlign != 0 {
+			return nil, fmt.Errorf("bs %d must be a multiple of %d with -direct", l.bs, directAlign)
+		}
+	}
+	f, err := os.OpenFile(l.file, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if size := l.size << 20; fi.Size() < size {
+		buf := alignedBuffer(1 << 20)
+		for off := fi.Size() &^ (1<<20 - 1); off < size; off += 1 << 20 {
+			if _, err := f.WriteAt(buf, off); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// alignedBuffer 多分配一点, 从对齐的位置切出来
+func alignedBuffer(n int) []byte {
+	buf := make([]byte, n+directAlign)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directAlign); rem != 0 {
+		off = directAlign - rem
+	}
+	return buf[off : off+n]
+}
+
+func (l *ioLoad) stats() interface{} {
+	now := time.Now()
+	s := IOStats{
+		Mode:   l.mode,
+		Direct: l.direct,
+		Ops:    atomic.LoadInt64(&l.ops),
+		Bytes:  atomic.LoadInt64(&l.bytes),
+		Fsyncs: atomic.LoadInt64(&l.syncs),
+		Errors: atomic.LoadInt64(&l.errors),
+	}
+	s.IOPS = round2(l.opsRate.per(now, s.Ops))
+	s.MBps = round2(l.bytesRate.per(now, s.Bytes) / (1 << 20))
+	if e, ok := l.lastErr.Load().(string); ok {
+		s.LastError = e
+	}
+	return s
+}
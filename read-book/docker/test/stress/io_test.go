@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+// Test_ioLoad_validate 不认识的mode和bs要在启动前报错
+func Test_ioLoad_validate(t *testing.T) {
+	for mode := range ioModes {
+		l := &ioLoad{mode: mode, bs: 4096}
+		if err := l.validate(); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+	}
+
+	for _, l := range []*ioLoad{
+		{mode: "", bs: 4096},
+		{mode: "randrw", bs: 4096},
+		{mode: "SeqRead", bs: 4096},
+		{mode: "seqread", bs: 0},
+		{mode: "seqwrite", bs: -1},
+	} {
+		if err := l.validate(); err == nil {
+			t.Fatalf("mode %q bs %d: want error", l.mode, l.bs)
+		}
+	}
+}
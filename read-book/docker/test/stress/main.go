@@ -0,0 +1,206 @@
+package main
+
+// 可以配置的负载, 代替loop(死循环的go程), memlimit(每秒10MB), pagecache(读写大文件)这几个写死的程序
+// 做容器限制的实验时可以写脚本跑:
+//
+//	./stress cpu -workers 4 -util 50 -duration 1m
+//	./stress mem -rate 20 -max 512 -touch random -ramp linear:30s
+//	./stress io -mode randwrite -bs 4096 -fsync 16 -direct
+//	./stress forks -max 200 -rate 50 -ramp step:10s:4
+//
+// 每隔-interval往stdout输出一行JSON, 最后一行的done是true
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// tick 调整强度的间隔, cpu和io按这个长度切片, 每片里干活的时间占level
+const tick = 100 * time.Millisecond
+
+// workload 一种负载
+type workload interface {
+	// start 开始干活, stop关闭时退出
+	start(stop <-chan struct{})
+	// setLevel level在0~1之间, 乘在目标强度上, 每个tick调用
+	setLevel(level float64)
+	// stats 输出到JSON里的指标, 每次输出时调用
+	stats() interface{}
+}
+
+// validator 有的负载启动前要检查参数, 出错时和-ramp一样按用法错误退出
+type validator interface {
+	validate() error
+}
+
+// Progress 每行JSON
+type Progress struct {
+	Time     string      `json:"time"`
+	Elapsed  float64     `json:"elapsed_s"`
+	Workload string      `json:"workload"`
+	Level    float64     `json:"level"`
+	Stats    interface{} `json:"stats"`
+	Done     bool        `json:"done,omitempty"`
+}
+
+// common 每个子命令都有的参数
+type common struct {
+	duration time.Duration
+	interval time.Duration
+	ramp     string
+}
+
+func (c *common) register(fs *flag.FlagSet) {
+	fs.DurationVar(&c.duration, "duration", 0, "how long to run, 0 means until interrupted")
+	fs.DurationVar(&c.interval, "interval", time.Second, "progress output interval")
+	fs.StringVar(&c.ramp, "ramp", "const", "ramp profile: const, linear:<d>, step:<d>:<n>, sine:<period>")
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stress <cpu|mem|io|forks> [flags]")
+	fmt.Fprintln(os.Stderr, "run 'stress <subcommand> -h' for the flags of each subcommand")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	name := os.Args[1]
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var c common
+	var w workload
+	switch name {
+	case "cpu":
+		w = newCPULoad(fs)
+	case "mem":
+		w = newMemLoad(fs)
+	case "io":
+		w = newIOLoad(fs)
+	case "forks":
+		w = newForkLoad(fs)
+	case forkChild:
+		runForkChild(os.Args[2:])
+		return
+	default:
+		usage()
+	}
+	c.register(fs)
+	fs.Parse(os.Args[2:])
+
+	if v, ok := w.(validator); ok {
+		if err := v.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	p, err := parseProfile(c.ramp)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := run(name, w, p, c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run 每个tick按profile调整强度, 每个interval输出一次进度, 到时间或者收到SIGINT/SIGTERM时退出
+func run(name string, w workload, p profile, c common) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	start := time.Now()
+	w.setLevel(p.level(0))
+	go func() {
+		w.start(stop)
+		close(done)
+	}()
+	// finish 等负载退出(比如forks杀掉子进程)之后再输出最后一行
+	finish := func() {
+		close(stop)
+		<-done
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	emit := func(level float64, done bool) error {
+		return enc.Encode(Progress{
+			Time:     time.Now().Format(time.RFC3339Nano),
+			Elapsed:  math.Round(time.Since(start).Seconds()*1000) / 1000,
+			Workload: name,
+			Level:    math.Round(level*1000) / 1000,
+			Stats:    w.stats(),
+			Done:     done,
+		})
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	lastEmit := start
+	level := p.level(0)
+	for {
+		select {
+		case <-sig:
+			finish()
+			return emit(level, true)
+		case now := <-ticker.C:
+			elapsed := now.Sub(start)
+			if c.duration > 0 && elapsed >= c.duration {
+				finish()
+				return emit(level, true)
+			}
+			level = p.level(elapsed)
+			w.setLevel(level)
+			if now.Sub(lastEmit) >= c.interval {
+				lastEmit = now
+				if err := emit(level, false); err != nil {
+					finish()
+					return err
+				}
+			}
+		}
+	}
+}
+
+// atomicFloat 给go1.17用的, 没有atomic.Uint64类型
+type atomicFloat struct{ bits uint64 }
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat) store(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+// rate 两次输出之间的速率
+type rate struct {
+	last     int64
+	lastTime time.Time
+}
+
+func (r *rate) per(now time.Time, total int64) float64 {
+	var v float64
+	if !r.lastTime.IsZero() {
+		if d := now.Sub(r.lastTime).Seconds(); d > 0 {
+			v = float64(total-r.last) / d
+		}
+	}
+	r.last, r.lastTime = total, now
+	return v
+}
+
+// round2 JSON里保留两位小数
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
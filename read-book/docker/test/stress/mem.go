@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const chunkSize = 1 << 20
+
+// memLoad 和memlimit一样一直分配内存, 但是可以设置速度, 上限和怎么访问
+// touch:
+//   - once   分配时写一遍, 之后不再访问, 内存紧张时可以被换出
+//   - all    每个tick把所有页都写一遍, 一直是热的
+//   - random 每个tick随机写1/10的页
+//   - none   只分配不写, 看虚拟内存和RSS的区别
+type memLoad struct {
+	rate  float64 // MB/s
+	max   int     // MB
+	touch string
+
+	level  atomicFloat
+	mu     sync.Mutex
+	chunks [][]byte
+	credit float64 // 这个tick还能分配多少MB, 有小数
+}
+
+// MemStats mem的指标
+type MemStats struct {
+	AllocatedMiB int    `json:"allocated_mib"`
+	TargetMiB    int    `json:"target_mib"`
+	Touch        string `json:"touch"`
+	HeapMiB      uint64 `json:"heap_mib"`
+	SysMiB       uint64 `json:"sys_mib"`
+	RSSMiB       uint64 `json:"rss_mib"`
+	NumGC        uint32 `json:"num_gc"`
+}
+
+func newMemLoad(fs *flag.FlagSet) *memLoad {
+	m := &memLoad{}
+	fs.Float64Var(&m.rate, "rate", 10, "allocation rate in MB per second")
+	fs.IntVar(&m.max, "max", 1024, "ceiling in MB, scaled by the ramp")
+	fs.StringVar(&m.touch, "touch", "once", "touch pattern: once, all, random, none")
+	return m
+}
+
+func (m *memLoad) setLevel(level float64) {
+	m.level.store(level)
+}
+
+func (m *memLoad) target() int {
+	return int(float64(m.max) * m.level.load())
+}
+
+func (m *memLoad) start(stop <-chan struct{}) {
+	switch m.touch {
+	case "once", "all", "random", "none":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown touch pattern %q, using once\n", m.touch)
+		m.touch = "once"
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.step()
+		}
+	}
+}
+
+// step 往target靠: 少了按rate分配, 多了(ramp往下走)就释放
+func (m *memLoad) step() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := m.target()
+	m.credit += m.rate * tick.Seconds()
+	for len(m.chunks) < target && m.credit >= 1 {
+		c := make([]byte, chunkSize)
+		if m.touch != "none" {
+			touch(c)
+		}
+		m.chunks = append(m.chunks, c)
+		m.credit--
+	}
+	if len(m.chunks) >= target {
+		// 到了上限不攒额度, 不然ramp往上走时会一下分配很多
+		m.credit = 0
+	}
+	if len(m.chunks) > target {
+		for i := target; i < len(m.chunks); i++ {
+			m.chunks[i] = nil
+		}
+		m.chunks = m.chunks[:target]
+		debug.FreeOSMemory()
+	}
+
+	switch m.touch {
+	case "all":
+		for _, c := range m.chunks {
+			touch(c)
+		}
+	case "random":
+		for i := 0; i < len(m.chunks)/10+1 && len(m.chunks) > 0; i++ {
+			touch(m.chunks[rand.Intn(len(m.chunks))])
+		}
+	}
+}
+
+// touch 每页写一个字节就够了
+func touch(c []byte) {
+	page := os.Getpagesize()
+	for i := 0; i < len(c); i += page {
+		c[i]++
+	}
+}
+
+func (m *memLoad) stats() interface{} {
+	m.mu.Lock()
+	allocated := len(m.chunks)
+	m.mu.Unlock()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return MemStats{
+		AllocatedMiB: allocated * chunkSize >> 20,
+		TargetMiB:    m.target(),
+		Touch:        m.touch,
+		HeapMiB:      ms.HeapAlloc >> 20,
+		SysMiB:       ms.Sys >> 20,
+		RSSMiB:       rss() >> 20,
+		NumGC:        ms.NumGC,
+	}
+}
+
+// rss /proc/self/status的VmRSS, 读不到返回0
+func rss() uint64 {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 3 && fields[0] == "VmRSS:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+// Test_memLoad_step 每个tick按rate分配, 不超过max*level, level降下来时释放
+func Test_memLoad_step(t *testing.T) {
+	m := &memLoad{rate: 30, max: 10, touch: "none"} // 每个tick 3MB
+
+	steps := []struct {
+		level  float64
+		chunks int
+	}{
+		{level: 1, chunks: 3},
+		{level: 1, chunks: 6},
+		{level: 1, chunks: 9},
+		{level: 1, chunks: 10},
+		{level: 1, chunks: 10},
+		{level: 0.5, chunks: 5},
+		// 到上限时没攒额度, 往上走还是按rate
+		{level: 1, chunks: 8},
+		{level: 0, chunks: 0},
+	}
+	for i, s := range steps {
+		m.setLevel(s.level)
+		m.step()
+		if got := len(m.chunks); got != s.chunks {
+			t.Fatalf("step %d: level %v chunks = %d, want %d", i, s.level, got, s.chunks)
+		}
+		if st := m.stats().(MemStats); st.AllocatedMiB != s.chunks || st.TargetMiB != int(10*s.level) {
+			t.Fatalf("step %d: stats %+v", i, st)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profile 强度随时间的变化, 返回0~1
+type profile interface {
+	level(elapsed time.Duration) float64
+}
+
+// constProfile 一开始就是满的
+type constProfile struct{}
+
+func (constProfile) level(time.Duration) float64 { return 1 }
+
+// linearProfile 在d时间里从0涨到1, 之后保持
+type linearProfile struct{ d time.Duration }
+
+func (p linearProfile) level(elapsed time.Duration) float64 {
+	return math.Min(float64(elapsed)/float64(p.d), 1)
+}
+
+// stepProfile 分n级, 每级持续d, 第一级是1/n
+type stepProfile struct {
+	d time.Duration
+	n int
+}
+
+func (p stepProfile) level(elapsed time.Duration) float64 {
+	step := int(elapsed/p.d) + 1
+	if step > p.n {
+		step = p.n
+	}
+	return float64(step) / float64(p.n)
+}
+
+// sineProfile 在0和1之间按正弦波动, 从0开始, 半个周期时到1
+type sineProfile struct{ period time.Duration }
+
+func (p sineProfile) level(elapsed time.Duration) float64 {
+	x := float64(elapsed) / float64(p.period) * 2 * math.Pi
+	return (1 - math.Cos(x)) / 2
+}
+
+// parseProfile 格式是 const, linear:30s, step:10s:4, sine:1m
+func parseProfile(s string) (profile, error) {
+	parts := strings.Split(s, ":")
+	bad := fmt.Errorf("bad ramp %q, want const, linear:<d>, step:<d>:<n> or sine:<period>", s)
+
+	var d time.Duration
+	if len(parts) > 1 {
+		var err error
+		if d, err = time.ParseDuration(parts[1]); err != nil || d <= 0 {
+			return nil, bad
+		}
+	}
+
+	switch {
+	case parts[0] == "const" && len(parts) == 1:
+		return constProfile{}, nil
+	case parts[0] == "linear" && len(parts) == 2:
+		return linearProfile{d: d}, nil
+	case parts[0] == "sine" && len(parts) == 2:
+		return sineProfile{period: d}, nil
+	case parts[0] == "step" && len(parts) == 3:
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n <= 0 {
+			return nil, bad
+		}
+		return stepProfile{d: d, n: n}, nil
+	}
+	return nil, bad
+}
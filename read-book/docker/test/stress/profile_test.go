@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_parseProfile(t *testing.T) {
+	tests := []struct {
+		ramp    string
+		elapsed time.Duration
+		want    float64
+	}{
+		{ramp: "const", elapsed: 0, want: 1},
+		{ramp: "linear:10s", elapsed: 0, want: 0},
+		{ramp: "linear:10s", elapsed: 5 * time.Second, want: 0.5},
+		{ramp: "linear:10s", elapsed: time.Minute, want: 1},
+		{ramp: "step:10s:4", elapsed: 0, want: 0.25},
+		{ramp: "step:10s:4", elapsed: 25 * time.Second, want: 0.75},
+		{ramp: "step:10s:4", elapsed: time.Minute, want: 1},
+		{ramp: "sine:1m", elapsed: 0, want: 0},
+		{ramp: "sine:1m", elapsed: 30 * time.Second, want: 1},
+		{ramp: "sine:1m", elapsed: 15 * time.Second, want: 0.5},
+	}
+	for _, tt := range tests {
+		p, err := parseProfile(tt.ramp)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.ramp, err)
+		}
+		if got := p.level(tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+			t.Fatalf("%s at %s: level = %v, want %v", tt.ramp, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func Test_parseProfile_Bad(t *testing.T) {
+	for _, ramp := range []string{
+		"", "const:1s", "linear", "linear:abc", "linear:0s", "linear:-1s",
+		"step:10s", "step:10s:0", "step:10s:x", "sine", "sine:1m:2", "square:1s",
+	} {
+		if _, err := parseProfile(ramp); err == nil {
+			t.Fatalf("%q: want error", ramp)
+		}
+	}
+}
@@ -0,0 +1 @@
+docker run -it --rm --cpus 1 --memory 256m --pids-limit 100 go-stress "$@"
\ No newline at end of file